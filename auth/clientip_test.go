@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.1", "172.16.0.0/12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		remoteAddr   string
+		forwardedFor string
+		expected     string
+	}{
+		{"1.1.1.1:1234", "", "1.1.1.1"},
+		// Clients that are not trusted proxies can not choose their address
+		{"1.1.1.1:1234", "2.2.2.2", "1.1.1.1"},
+		{"10.0.0.1:1234", "2.2.2.2", "2.2.2.2"},
+		// Addresses the client put in front of the ones of the proxies are ignored
+		{"10.0.0.1:1234", "3.3.3.3, 2.2.2.2, 172.16.0.5", "2.2.2.2"},
+		{"10.0.0.1:1234", "garbage, 172.16.0.5", "172.16.0.5"},
+		{"[::1]:1234", "2.2.2.2", "::1"},
+	}
+	for _, c := range cases {
+		if ip := ClientIP(c.remoteAddr, c.forwardedFor, trusted); ip != c.expected {
+			t.Errorf("%s forwarding %q: expected %s, got %s", c.remoteAddr, c.forwardedFor, c.expected, ip)
+		}
+	}
+
+	if _, err := ParseTrustedProxies([]string{"not an ip"}); err == nil {
+		t.Error("invalid proxies should be refused")
+	}
+}
@@ -0,0 +1,44 @@
+package auth
+
+import "tinder-for-clubs-backend/db"
+
+// Permission is an action a role may perform, routes declare the permission they need.
+type Permission string
+
+const (
+	PERM_ACCOUNT_READ       Permission = "account:read"
+	PERM_ACCOUNT_WRITE      Permission = "account:write"
+	PERM_ROLE_ASSIGN        Permission = "role:assign"
+	PERM_CLUB_READ          Permission = "club:read"
+	PERM_CLUB_MODERATE      Permission = "club:moderate"
+	PERM_LOGIN_HISTORY_READ Permission = "loginhistory:read"
+	// Editing the own club, for club managers
+	PERM_CLUB_EDIT Permission = "club:edit"
+)
+
+var rolePermissions = map[string][]Permission{
+	db.ROLE_SUPER_ADMIN: {
+		PERM_ACCOUNT_READ, PERM_ACCOUNT_WRITE, PERM_ROLE_ASSIGN,
+		PERM_CLUB_READ, PERM_CLUB_MODERATE, PERM_LOGIN_HISTORY_READ,
+	},
+	db.ROLE_MODERATOR: {
+		PERM_ACCOUNT_READ, PERM_CLUB_READ, PERM_CLUB_MODERATE,
+	},
+	// Read only
+	db.ROLE_AUDITOR: {
+		PERM_ACCOUNT_READ, PERM_CLUB_READ, PERM_LOGIN_HISTORY_READ,
+	},
+	db.ROLE_CLUB_MANAGER: {
+		PERM_CLUB_EDIT,
+	},
+}
+
+// HasPermission reports whether the role grants the permission.
+func HasPermission(role string, permission Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
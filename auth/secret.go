@@ -0,0 +1,15 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// HashSecret returns the keyed hash under which a secret such as an admin auth string is stored.
+// Secrets themselves are never persisted.
+func HashSecret(key []byte, secret string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(secret))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+type failures struct {
+	count        int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// LoginThrottle slows down repeated failing logins, per client IP and across all clients.
+// Once a threshold is reached every further failure doubles the time the client is blocked, up to maxDelay.
+// Failures are forgotten after window without a new one. While all clients are blocked, clients that logged in
+// successfully within trustFor still get through, so a distributed guesser can not lock out known admins.
+// The state is kept in memory, each server instance throttles the logins it handles by itself.
+type LoginThrottle struct {
+	mu              sync.Mutex
+	perIP           map[string]*failures
+	global          failures
+	lastSuccess     map[string]time.Time
+	ipThreshold     int
+	globalThreshold int
+	baseDelay       time.Duration
+	maxDelay        time.Duration
+	window          time.Duration
+	trustFor        time.Duration
+}
+
+func NewLoginThrottle(ipThreshold, globalThreshold int, baseDelay, maxDelay, window, trustFor time.Duration) *LoginThrottle {
+	return &LoginThrottle{
+		perIP:           make(map[string]*failures),
+		lastSuccess:     make(map[string]time.Time),
+		ipThreshold:     ipThreshold,
+		globalThreshold: globalThreshold,
+		baseDelay:       baseDelay,
+		maxDelay:        maxDelay,
+		window:          window,
+		trustFor:        trustFor,
+	}
+}
+
+// Allow reports whether a login attempt from ip may proceed, and if not, how long the client has to wait.
+// It is asked before the credentials are checked, so a blocked client can not tell valid ones from invalid.
+func (t *LoginThrottle) Allow(ip string, now time.Time) (bool, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if f, ok := t.perIP[ip]; ok {
+		if wait := f.blockedUntil.Sub(now); wait > 0 {
+			return false, wait
+		}
+	}
+	if lastSuccess, ok := t.lastSuccess[ip]; ok && now.Sub(lastSuccess) <= t.trustFor {
+		return true, 0
+	}
+	if wait := t.global.blockedUntil.Sub(now); wait > 0 {
+		return false, wait
+	}
+	return true, 0
+}
+
+// Failure records a failed login attempt from ip.
+func (t *LoginThrottle) Failure(ip string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, ok := t.perIP[ip]
+	if !ok {
+		f = &failures{}
+		t.perIP[ip] = f
+	}
+	t.record(f, t.ipThreshold, now)
+	t.record(&t.global, t.globalThreshold, now)
+}
+
+// Success clears the failures of ip and lets it past the global block for trustFor. Global failures are kept.
+func (t *LoginThrottle) Success(ip string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.perIP, ip)
+	t.lastSuccess[ip] = now
+}
+
+// Prune forgets clients whose failures are outside the window, and successes older than trustFor.
+func (t *LoginThrottle) Prune(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for ip, f := range t.perIP {
+		if now.Sub(f.lastFailure) > t.window && now.After(f.blockedUntil) {
+			delete(t.perIP, ip)
+		}
+	}
+	for ip, lastSuccess := range t.lastSuccess {
+		if now.Sub(lastSuccess) > t.trustFor {
+			delete(t.lastSuccess, ip)
+		}
+	}
+}
+
+func (t *LoginThrottle) record(f *failures, threshold int, now time.Time) {
+	if now.Sub(f.lastFailure) > t.window {
+		f.count = 0
+	}
+	f.count++
+	f.lastFailure = now
+
+	if threshold <= 0 || f.count < threshold {
+		return
+	}
+	delay := t.maxDelay
+	if exp := uint(f.count - threshold); exp < 32 {
+		if d := t.baseDelay << exp; d > 0 && d < t.maxDelay {
+			delay = d
+		}
+	}
+	f.blockedUntil = now.Add(delay)
+}
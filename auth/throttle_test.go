@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginThrottle_BacksOffPerIP(t *testing.T) {
+	throttle := NewLoginThrottle(3, 100, time.Second, time.Minute, time.Hour, time.Hour)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		throttle.Failure("1.1.1.1", now)
+	}
+	if ok, _ := throttle.Allow("1.1.1.1", now); !ok {
+		t.Fatal("throttled below threshold")
+	}
+
+	throttle.Failure("1.1.1.1", now)
+	ok, wait := throttle.Allow("1.1.1.1", now)
+	if ok || wait != time.Second {
+		t.Fatalf("expected 1s wait, got %v %v", ok, wait)
+	}
+	if ok, _ := throttle.Allow("2.2.2.2", now); !ok {
+		t.Fatal("other ip throttled")
+	}
+
+	//every further failure doubles the delay
+	throttle.Failure("1.1.1.1", now)
+	if _, wait := throttle.Allow("1.1.1.1", now); wait != 2*time.Second {
+		t.Fatalf("expected 2s wait, got %v", wait)
+	}
+	for i := 0; i < 10; i++ {
+		throttle.Failure("1.1.1.1", now)
+	}
+	if _, wait := throttle.Allow("1.1.1.1", now); wait != time.Minute {
+		t.Fatalf("expected wait capped at 1m, got %v", wait)
+	}
+
+	throttle.Success("1.1.1.1", now)
+	if ok, _ := throttle.Allow("1.1.1.1", now); !ok {
+		t.Fatal("still throttled after success")
+	}
+}
+
+func TestLoginThrottle_Global(t *testing.T) {
+	throttle := NewLoginThrottle(100, 3, time.Second, time.Minute, time.Hour, 24*time.Hour)
+	now := time.Now()
+	throttle.Success("4.4.4.4", now.Add(-23*time.Hour))
+	throttle.Success("5.5.5.5", now.Add(-25*time.Hour))
+
+	throttle.Failure("1.1.1.1", now)
+	throttle.Failure("2.2.2.2", now)
+	throttle.Failure("3.3.3.3", now)
+	if ok, wait := throttle.Allow("6.6.6.6", now); ok || wait != time.Second {
+		t.Fatalf("global threshold not applied, got %v %v", ok, wait)
+	}
+	if ok, _ := throttle.Allow("5.5.5.5", now); ok {
+		t.Fatal("an old success should not pass the global block")
+	}
+	if ok, _ := throttle.Allow("4.4.4.4", now); !ok {
+		t.Fatal("a recent success should pass the global block")
+	}
+	if ok, _ := throttle.Allow("6.6.6.6", now.Add(2*time.Second)); !ok {
+		t.Fatal("still throttled after delay")
+	}
+
+	throttle.Prune(now)
+	throttle.Failure("1.1.1.1", now)
+	if ok, _ := throttle.Allow("4.4.4.4", now); !ok {
+		t.Fatal("pruning should keep recent successes")
+	}
+	if ok, _ := throttle.Allow("5.5.5.5", now); ok {
+		t.Fatal("an old success should not pass the global block after pruning either")
+	}
+}
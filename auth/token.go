@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+// Token types, an access token can never be used to refresh and vice versa.
+const (
+	ACCESS_TOKEN  = "access"
+	REFRESH_TOKEN = "refresh"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+var ErrTokenExpired = errors.New("token expired")
+
+// JWT header, only HS256 is issued and accepted.
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Claims carried by app user tokens. Subject is the LoopUID the token is bound to.
+type Claims struct {
+	Subject   string `json:"sub"`
+	TokenID   string `json:"jti"`
+	TokenType string `json:"typ"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func (c *Claims) ExpiryTime() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// TokenPair is handed to the mini-app on register, login and refresh.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	// Seconds until the access token expires
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+// TokenIssuer signs and verifies app user tokens with a shared HMAC secret.
+type TokenIssuer struct {
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func NewTokenIssuer(secret []byte, accessTTL, refreshTTL time.Duration) *TokenIssuer {
+	return &TokenIssuer{secret: secret, accessTTL: accessTTL, refreshTTL: refreshTTL}
+}
+
+// IssuePair creates a new access and refresh token for the given LoopUID.
+func (ti *TokenIssuer) IssuePair(loopUID string) (*TokenPair, error) {
+	accessToken, err := ti.issue(loopUID, ACCESS_TOKEN, ti.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := ti.issue(loopUID, REFRESH_TOKEN, ti.refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(ti.accessTTL / time.Second),
+	}, nil
+}
+
+func (ti *TokenIssuer) issue(loopUID, tokenType string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		Subject:   loopUID,
+		TokenID:   uuid.New().String(),
+		TokenType: tokenType,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + ti.sign(signingInput), nil
+}
+
+// Verify checks signature, type and expiry of a token and returns its claims.
+// Revocation is checked by the caller.
+func (ti *TokenIssuer) Verify(token, tokenType string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return nil, ErrInvalidToken
+	}
+	expected := ti.sign(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.TokenType != tokenType || claims.Subject == "" || claims.TokenID == "" {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	return &claims, nil
+}
+
+func (ti *TokenIssuer) sign(signingInput string) string {
+	mac := hmac.New(sha256.New, ti.secret)
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
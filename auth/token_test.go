@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenIssuer_IssueAndVerify(t *testing.T) {
+	issuer := NewTokenIssuer([]byte("secret"), time.Hour, 24*time.Hour)
+	pair, err := issuer.IssuePair("uid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := issuer.Verify(pair.AccessToken, ACCESS_TOKEN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "uid" {
+		t.Fatalf("unexpected subject %s", claims.Subject)
+	}
+
+	//tokens can not be used as the other type
+	if _, err := issuer.Verify(pair.AccessToken, REFRESH_TOKEN); err != ErrInvalidToken {
+		t.Fatalf("access token accepted as refresh token: %v", err)
+	}
+	if _, err := issuer.Verify(pair.RefreshToken, ACCESS_TOKEN); err != ErrInvalidToken {
+		t.Fatalf("refresh token accepted as access token: %v", err)
+	}
+}
+
+func TestTokenIssuer_VerifyRejectsForgedAndExpired(t *testing.T) {
+	issuer := NewTokenIssuer([]byte("secret"), time.Hour, time.Hour)
+	pair, err := issuer.IssuePair("uid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := NewTokenIssuer([]byte("other secret"), time.Hour, time.Hour)
+	if _, err := other.Verify(pair.AccessToken, ACCESS_TOKEN); err != ErrInvalidToken {
+		t.Fatalf("token signed with another secret accepted: %v", err)
+	}
+
+	parts := strings.Split(pair.AccessToken, ".")
+	forged := parts[0] + "." + parts[1] + "x." + parts[2]
+	if _, err := issuer.Verify(forged, ACCESS_TOKEN); err != ErrInvalidToken {
+		t.Fatalf("tampered token accepted: %v", err)
+	}
+
+	expiredIssuer := NewTokenIssuer([]byte("secret"), -time.Minute, time.Hour)
+	expired, err := expiredIssuer.IssuePair("uid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issuer.Verify(expired.AccessToken, ACCESS_TOKEN); err != ErrTokenExpired {
+		t.Fatalf("expired token accepted: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRU_Evicts(t *testing.T) {
+	c := NewLRU(2, time.Hour)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	// a becomes the most recently used
+	if v, ok := c.Get("a"); !ok || v.(int) != 1 {
+		t.Fatalf("expected a=1, got %v %v", v, ok)
+	}
+	c.Add("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("least recently used entry b should be evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("a should be kept")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Error("c should be kept")
+	}
+	if c.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", c.Len())
+	}
+}
+
+func TestLRU_Expires(t *testing.T) {
+	now := time.Now()
+	c := NewLRU(10, time.Minute)
+	c.now = func() time.Time { return now }
+	c.Add("a", 1)
+
+	now = now.Add(30 * time.Second)
+	if _, ok := c.Get("a"); !ok {
+		t.Error("entry should not expire before ttl")
+	}
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("a"); ok {
+		t.Error("entry should expire after ttl")
+	}
+	if c.Len() != 0 {
+		t.Errorf("expired entry should be removed, got %d entries", c.Len())
+	}
+}
+
+func TestLRU_UpdateAndRemove(t *testing.T) {
+	c := NewLRU(10, time.Hour)
+	c.Add("a", 1)
+	c.Add("a", 2)
+	if v, _ := c.Get("a"); v.(int) != 2 {
+		t.Errorf("expected updated value 2, got %v", v)
+	}
+	c.Remove("a")
+	if _, ok := c.Get("a"); ok {
+		t.Error("removed entry should be gone")
+	}
+
+	disabled := NewLRU(0, time.Hour)
+	disabled.Add("a", 1)
+	if _, ok := disabled.Get("a"); ok {
+		t.Error("a cache of size 0 should keep nothing")
+	}
+}
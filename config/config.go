@@ -7,35 +7,193 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
+	"time"
 	"tinder-for-clubs-backend/common"
 )
 
 //DBCredential struct
 type DBCredential struct {
+	// "mysql" (default), "postgres" or "sqlite3"
+	Driver string `yaml:"driver"`
+	// Database file of sqlite3, ":memory:" keeps the database in memory until the server stops
+	DBPath    string `yaml:"db-path"`
 	DBAddress string `yaml:"db-address"`
 	DBUser    string `yaml:"db-user"`
 	DBPass    string `yaml:"db-pass"`
 	DBPort    string `yaml:"db-port"`
 	DBName    string `yaml:"db-name"`
+	// Postgres sslmode, e.g. "disable" or "verify-full". The default of lib/pq is "require".
+	SSLMode string `yaml:"ssl-mode"`
 }
 
 type General struct {
 	PictureStoragePath string `yaml:"static-storage-path"`
+	// Resized copies generated for every uploaded picture, served with the size query parameter
+	PictureVariants []PictureVariant `yaml:"picture-variants"`
+	// Picture lookups kept in memory, 0 uses the default of 10000
+	PictureCacheSize int `yaml:"picture-cache-size"`
+	// Uploaded pictures no club uses are deleted after this many days, 0 keeps them forever
+	PictureGCDays int `yaml:"picture-gc-days"`
+	// Jpeg quality uploads are re-encoded at, 1 to 100
+	PictureQuality int `yaml:"picture-quality"`
+	// Uploads are scaled down to fit in a square of this many pixels
+	PictureMaxDimension int `yaml:"picture-max-dimension"`
+	// Uploads larger than this are refused before they are decoded, 0 uses 8192, 8192 and 40000000
+	PictureMaxUploadWidth  int   `yaml:"picture-max-upload-width"`
+	PictureMaxUploadHeight int   `yaml:"picture-max-upload-height"`
+	PictureMaxUploadPixels int64 `yaml:"picture-max-upload-pixels"`
+	// Maximum number of gallery pictures per club
+	ClubPictureLimit int `yaml:"club-picture-limit"`
+}
+
+//PictureVariant a picture is scaled down to fit in MaxWidth x MaxHeight, keeping its aspect ratio
+type PictureVariant struct {
+	Name      string `yaml:"name"`
+	MaxWidth  int    `yaml:"max-width"`
+	MaxHeight int    `yaml:"max-height"`
+}
+
+//Session admin session store settings
+type Session struct {
+	// "db", "redis" or "memory"
+	Backend string `yaml:"backend"`
+	// Fixed key used to sign session cookies, must be kept across restarts and instances
+	SecretKey string `yaml:"secret-key"`
+	// Absolute lifetime of a session from login, activity does not extend it, e.g. "24h"
+	TTL time.Duration `yaml:"ttl"`
+	// Sessions inactive for longer than this are dropped, e.g. "2h". Zero disables it.
+	IdleTimeout time.Duration `yaml:"idle-timeout"`
+
+	RedisAddress  string `yaml:"redis-address"`
+	RedisPassword string `yaml:"redis-password"`
+	RedisDB       int    `yaml:"redis-db"`
+}
+
+//AppAuth mini-app user token settings
+type AppAuth struct {
+	// HMAC secret used to sign app user tokens
+	TokenSecret string `yaml:"token-secret"`
+	// e.g. "1h"
+	AccessTokenTTL time.Duration `yaml:"access-token-ttl"`
+	// e.g. "720h"
+	RefreshTokenTTL time.Duration `yaml:"refresh-token-ttl"`
+}
+
+//AdminAuth club manager and admin login settings
+type AdminAuth struct {
+	// Key of the hash under which auth strings are stored. Changing it invalidates all auth strings.
+	AuthHashKey string `yaml:"auth-hash-key"`
+
+	// Failed logins from one IP before it gets throttled
+	MaxFailuresPerIP int `yaml:"max-failures-per-ip"`
+	// Failed logins from all clients before every login gets throttled, except from clients that logged in before
+	MaxFailuresGlobal int `yaml:"max-failures-global"`
+	// Delay once a threshold is reached, doubled on every further failure
+	ThrottleBaseDelay time.Duration `yaml:"throttle-base-delay"`
+	ThrottleMaxDelay  time.Duration `yaml:"throttle-max-delay"`
+	// Failures are forgotten after this long without a new one
+	ThrottleWindow time.Duration `yaml:"throttle-window"`
+	// How long after a successful login its client IP is not throttled for the failures of all clients
+	ThrottleTrustSuccessFor time.Duration `yaml:"throttle-trust-success-for"`
+	// Proxies in front of the server, IPs or CIDR ranges. Only their X-Forwarded-For header is used as client IP.
+	TrustedProxies []string `yaml:"trusted-proxies"`
+
+	// How long a QR code login challenge can be approved, e.g. "2m"
+	QRChallengeTTL time.Duration `yaml:"qr-challenge-ttl"`
+}
+
+//Mail outgoing email settings
+type Mail struct {
+	SMTPHost string `yaml:"smtp-host"`
+	SMTPPort string `yaml:"smtp-port"`
+	SMTPUser string `yaml:"smtp-user"`
+	SMTPPass string `yaml:"smtp-pass"`
+	From     string `yaml:"from"`
+	// Link sent in club manager invites, %s is replaced by the invite token
+	InviteURL string `yaml:"invite-url"`
+}
+
+//Storage where uploaded pictures are kept
+type Storage struct {
+	// "local" (default) keeps pictures in general static-storage-path, "s3" in a bucket of an S3 compatible storage
+	Backend string `yaml:"backend"`
+
+	// e.g. "https://s3.eu-west-1.amazonaws.com" or "http://127.0.0.1:9000" for MinIO
+	S3Endpoint  string `yaml:"s3-endpoint"`
+	S3Region    string `yaml:"s3-region"`
+	S3Bucket    string `yaml:"s3-bucket"`
+	S3AccessKey string `yaml:"s3-access-key"`
+	S3SecretKey string `yaml:"s3-secret-key"`
+
+	// Redirect picture requests to signed URLs of the storage instead of proxying the picture, s3 only
+	SignedURLs bool `yaml:"signed-urls"`
+	// How long signed URLs are valid, e.g. "15m"
+	SignedURLTTL time.Duration `yaml:"signed-url-ttl"`
+}
+
+//Ranking order of the clubs in the swipe view list
+type Ranking struct {
+	// Weights of the signals clubs are scored by, all 0 uses 0.5, 0.3 and 0.2
+	TagWeight          float64 `yaml:"tag-weight"`
+	CoOccurrenceWeight float64 `yaml:"co-occurrence-weight"`
+	PopularityWeight   float64 `yaml:"popularity-weight"`
+	// Share of the list given to random clubs instead of the best scored ones, 0 to 1. 0.2 when not set.
+	ExplorationRatio *float64 `yaml:"exploration-ratio"`
+	// How long clubs the user passed on are left out of new view lists, 720h when not set
+	PassCooldown time.Duration `yaml:"pass-cooldown"`
+	// How long the tags and favourites of all clubs are kept for ranking before they are loaded again, 5m when not set
+	SignalsTTL time.Duration `yaml:"signals-ttl"`
 }
 
 //GlobalConfiguration struct
 type GlobalConfiguration struct {
 	DBCredential DBCredential `yaml:"db-config"`
 	General      General      `yaml:"general"`
+	Session      Session      `yaml:"session"`
+	AppAuth      AppAuth      `yaml:"app-auth"`
+	AdminAuth    AdminAuth    `yaml:"admin-auth"`
+	Mail         Mail         `yaml:"mail"`
+	Storage      Storage      `yaml:"storage"`
+	Ranking      Ranking      `yaml:"ranking"`
 }
 
 //GetConnectionString Build a database connection
 func (c *DBCredential) GetConnectionString() string {
+	switch c.GetDriver() {
+	case "sqlite3":
+		return c.DBPath
+	case "postgres":
+		connectionString := fmt.Sprintf("host=%v port=%v user=%v password=%v dbname=%v",
+			quotePostgresValue(c.DBAddress), quotePostgresValue(c.DBPort), quotePostgresValue(c.DBUser),
+			quotePostgresValue(c.DBPass), quotePostgresValue(c.DBName))
+		if c.SSLMode != "" {
+			connectionString += " sslmode=" + quotePostgresValue(c.SSLMode)
+		}
+		return connectionString
+	}
 	return fmt.Sprintf("%v:%v@tcp(%v:%v)/%v?multiStatements=TRUE&parseTime=true&charset=utf8mb4,utf8", c.DBUser, c.DBPass, c.DBAddress, c.DBPort, c.DBName)
 }
 
+// Quotes a value of a postgres connection string, so that it may contain spaces and quotes.
+func quotePostgresValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	return "'" + strings.Replace(value, "'", `\'`, -1) + "'"
+}
+
+//GetDriver Get the gorm dialect of the database, mysql when not configured
+func (c *DBCredential) GetDriver() string {
+	if c.Driver == "" {
+		return "mysql"
+	}
+	return c.Driver
+}
+
 //ConnectionCredentialLogString Get database connection information
 func (c *DBCredential) ConnectionCredentialLogString() string {
+	if c.GetDriver() == "sqlite3" {
+		return fmt.Sprintf("Driver: sqlite3  Path: %v\n", c.DBPath)
+	}
 	return fmt.Sprintf("Username: %v  Address: %v  DBName: %v\n", c.DBUser, c.DBAddress, c.DBName)
 }
 
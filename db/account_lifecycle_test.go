@@ -0,0 +1,164 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+	"testing"
+	"time"
+)
+
+func createTestManager(t *testing.T, accountID, clubID, role string, joinedAt time.Time) {
+	account := AdminAccount{AccountID: accountID, ClubID: clubID, Role: ROLE_CLUB_MANAGER}
+	if err := account.Insert(DB); err != nil {
+		t.Fatal(err)
+	}
+	membership := ClubMembership{
+		Model:     gorm.Model{CreatedAt: joinedAt},
+		ClubID:    clubID,
+		AccountID: accountID,
+		Role:      role,
+	}
+	if err := membership.Insert(DB); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func createTestClub(t *testing.T, clubID string) {
+	club := ClubInfo{ClubID: clubID, Name: "Chess Club", Published: true}
+	if err := club.Insert(DB); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteAccountCascade_LastManager(t *testing.T) {
+	initTestDB(t)
+	createTestClub(t, "club-1")
+	createTestManager(t, "owner", "club-1", MEMBER_OWNER, time.Now())
+
+	picture := AccountPicture{AccountID: "owner", ClubID: "club-1", PictureID: "pic-1", PictureName: "pic-1.png"}
+	if err := picture.Insert(DB); err != nil {
+		t.Fatal(err)
+	}
+	favouriteLog := UserFavouriteLog{LoopUID: "loop-1", ClubID: "club-1", Action: FAVORITE_ACTION}
+	if err := favouriteLog.Insert(DB); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteAccountCascade(DB, "owner"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetAccountByUserId(DB, "owner"); !gorm.IsRecordNotFoundError(err) {
+		t.Fatalf("deleted account is still found, err: %v", err)
+	}
+	var deleted AdminAccount
+	if err := DB.Unscoped().Where("account_id = ?", "owner").First(&deleted).Error; err != nil {
+		t.Fatalf("account should be soft deleted: %v", err)
+	}
+
+	club, err := GetClubInfoByClubId(DB, "club-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if club.Published {
+		t.Error("club of the last manager should be unpublished")
+	}
+
+	if _, err := GetPictureById(DB, "pic-1"); !gorm.IsRecordNotFoundError(err) {
+		t.Errorf("picture should be archived, err: %v", err)
+	}
+
+	var logNum int64
+	if err := DB.Model(&UserFavouriteLog{}).Where("club_id = ?", "club-1").Count(&logNum).Error; err != nil {
+		t.Fatal(err)
+	}
+	if logNum != 1 {
+		t.Errorf("favourite logs should be kept, got %d", logNum)
+	}
+}
+
+func TestDeleteAccountCascade_PromotesOldestEditor(t *testing.T) {
+	initTestDB(t)
+	createTestClub(t, "club-1")
+	now := time.Now()
+	createTestManager(t, "owner", "club-1", MEMBER_OWNER, now.Add(-3*time.Hour))
+	createTestManager(t, "editor-old", "club-1", MEMBER_EDITOR, now.Add(-2*time.Hour))
+	createTestManager(t, "editor-new", "club-1", MEMBER_EDITOR, now.Add(-time.Hour))
+
+	if err := DeleteAccountCascade(DB, "owner"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetClubMembership(DB, "club-1", "owner"); !gorm.IsRecordNotFoundError(err) {
+		t.Errorf("membership of the deleted account should be removed, err: %v", err)
+	}
+	membership, err := GetClubMembership(DB, "club-1", "editor-old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if membership.Role != MEMBER_OWNER {
+		t.Errorf("oldest editor should become owner, got %s", membership.Role)
+	}
+	membership, err = GetClubMembership(DB, "club-1", "editor-new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if membership.Role != MEMBER_EDITOR {
+		t.Errorf("newer editor should stay editor, got %s", membership.Role)
+	}
+
+	club, err := GetClubInfoByClubId(DB, "club-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !club.Published {
+		t.Error("club with remaining managers should stay published")
+	}
+}
+
+func TestDeleteAccountCascade_EditorKeepsOwner(t *testing.T) {
+	initTestDB(t)
+	createTestClub(t, "club-1")
+	now := time.Now()
+	createTestManager(t, "owner", "club-1", MEMBER_OWNER, now.Add(-2*time.Hour))
+	createTestManager(t, "editor", "club-1", MEMBER_EDITOR, now.Add(-time.Hour))
+
+	if err := DeleteAccountCascade(DB, "editor"); err != nil {
+		t.Fatal(err)
+	}
+
+	managers, err := GetClubManagers(DB, "club-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(managers) != 1 || managers[0].AccountID != "owner" || managers[0].Role != MEMBER_OWNER {
+		t.Errorf("only the owner should be left, got %+v", managers)
+	}
+}
+
+func TestAdminAccount_UpdateSuspended(t *testing.T) {
+	initTestDB(t)
+	createTestManager(t, "manager", "club-1", MEMBER_OWNER, time.Now())
+
+	account := AdminAccount{AccountID: "manager"}
+	if err := account.UpdateSuspended(DB, true); err != nil {
+		t.Fatal(err)
+	}
+	suspended, err := GetAccountByUserId(DB, "manager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !suspended.Suspended {
+		t.Error("account should be suspended")
+	}
+
+	if err := account.UpdateSuspended(DB, false); err != nil {
+		t.Fatal(err)
+	}
+	reactivated, err := GetAccountByUserId(DB, "manager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reactivated.Suspended {
+		t.Error("account should be reactivated")
+	}
+}
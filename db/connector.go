@@ -3,6 +3,8 @@ package db
 import (
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	"log"
 	"tinder-for-clubs-backend/common"
 	"tinder-for-clubs-backend/config"
@@ -14,8 +16,12 @@ var DB *gorm.DB
 func Init(dbCred config.DBCredential) {
 	var err error
 	log.Printf("Connection Info: %v", dbCred.ConnectionCredentialLogString())
-	DB, err = gorm.Open("mysql", dbCred.GetConnectionString())
+	DB, err = gorm.Open(dbCred.GetDriver(), dbCred.GetConnectionString())
 	common.ErrFatalLog(err)
+	if dbCred.GetDriver() == "sqlite3" {
+		// Every connection would get its own in-memory database, and SQLite only has one writer anyway
+		DB.DB().SetMaxOpenConns(1)
+	}
 
 	// Checking connection status
 	err = DB.DB().Ping()
@@ -26,30 +32,16 @@ func Init(dbCred config.DBCredential) {
 		log.Fatalf("DB connection failed %s", err.Error())
 	}
 	log.Printf("DB connection established successfully!")
+}
 
-	// Struct AutoMigrate
-	err = DB.AutoMigrate(&AdminAccount{}).Error
-	common.ErrFatalLog(err)
-	err = DB.AutoMigrate(&LoginHistory{}).Error
-	common.ErrFatalLog(err)
-	err = DB.AutoMigrate(&ClubInfo{}).Error
-	common.ErrFatalLog(err)
-	err = DB.Set("gorm:table_options", "CHARSET=utf8mb4").AutoMigrate(&UserList{}).Error
-	common.ErrFatalLog(err)
-	err = DB.AutoMigrate(&ClubTags{}).Error
-	common.ErrFatalLog(err)
-	err = DB.AutoMigrate(&ClubTagRelationship{}).Error
-	common.ErrFatalLog(err)
-	err = DB.AutoMigrate(&AccountPicture{}).Error
-	common.ErrFatalLog(err)
-	err = DB.AutoMigrate(&UserFavourite{}).Error
-	common.ErrFatalLog(err)
-	err = DB.AutoMigrate(&UserFavouriteLog{}).Error
-	common.ErrFatalLog(err)
-	err = DB.AutoMigrate(&ViewList{}).Error
-	common.ErrFatalLog(err)
-	err = DB.AutoMigrate(&ViewListLog{}).Error
+// Stops the server when migrations are pending, they are applied with the migrate command.
+func CheckSchema() {
+	pending, err := GetPendingMigrations()
 	common.ErrFatalLog(err)
+	if len(pending) > 0 {
+		log.Fatalf("Database schema is behind, %d migrations are pending starting with %d %s. Run the migrate up command first.",
+			len(pending), pending[0].Version, pending[0].Name)
+	}
 }
 
 func Close() {
@@ -8,14 +8,40 @@ import (
 // Admin Accounts
 type AdminAccount struct {
 	gorm.Model
-	AccountID  string `gorm:"type:varchar(40);unique_index" json:"account_id"`
-	AuthString string `gorm:"type:varchar(256);"            json:"auth_string"`
-	ClubID     string `gorm:"type:varchar(40);"             json:"club_id"`
-	Email      string `gorm:"type:varchar(100);"            json:"email"`
-	PhoneNum   string `gorm:"type:varchar(20);"             json:"phone_num"`
-	Note       string `gorm:"type:varchar(200);"            json:"note"`
-	// For managers of Tinder for Clubs
-	IsAdmin bool `gorm:"type:tinyint(1);" json:"is_admin"`
+	AccountID string `gorm:"type:varchar(40);unique_index" json:"account_id"`
+	// Legacy plaintext auth string, emptied by MigrateAuthStrings
+	AuthString string `gorm:"type:varchar(256);"            json:"-"`
+	// Keyed hash of the auth string, the auth string itself is only shown once when created
+	AuthHash string `gorm:"type:varchar(64);index"        json:"-"`
+	ClubID   string `gorm:"type:varchar(40);"             json:"club_id"`
+	Email    string `gorm:"type:varchar(100);"            json:"email"`
+	PhoneNum string `gorm:"type:varchar(20);"             json:"phone_num"`
+	Note     string `gorm:"type:varchar(200);"            json:"note"`
+	// For managers of Tinder for Clubs, true for every role but ROLE_CLUB_MANAGER
+	IsAdmin bool   `json:"is_admin"`
+	Role    string `gorm:"type:varchar(20);" json:"role"`
+	// Suspended accounts can not log in until reactivated
+	Suspended bool `json:"suspended"`
+}
+
+const (
+	// Manages its own club only
+	ROLE_CLUB_MANAGER = "CLUB_MANAGER"
+	// Platform roles
+	ROLE_SUPER_ADMIN = "SUPER_ADMIN"
+	ROLE_MODERATOR   = "MODERATOR"
+	ROLE_AUDITOR     = "AUDITOR"
+)
+
+func IsValidRole(role string) bool {
+	return role == ROLE_CLUB_MANAGER || role == ROLE_SUPER_ADMIN || role == ROLE_MODERATOR || role == ROLE_AUDITOR
+}
+
+func (ac *AdminAccount) UpdateRole(txDb *gorm.DB, role string) error {
+	err := txDb.Model(&AdminAccount{}).Where("account_id = ?", ac.AccountID).
+		Updates(map[string]interface{}{"role": role, "is_admin": role != ROLE_CLUB_MANAGER}).
+		Error
+	return err
 }
 
 func (ac *AdminAccount) Insert(txDb *gorm.DB) error {
@@ -23,22 +49,134 @@ func (ac *AdminAccount) Insert(txDb *gorm.DB) error {
 	return err
 }
 
-func (ac *AdminAccount) Update() error {
-	err := DB.Model(&AdminAccount{}).Where("account_id = ?", ac.AccountID).
+func (ac *AdminAccount) Update(txDb *gorm.DB) error {
+	err := txDb.Model(&AdminAccount{}).Where("account_id = ?", ac.AccountID).
 		Updates(map[string]interface{}{"email": ac.Email, "phone_num": ac.PhoneNum, "note": ac.Note}).
 		Error
 	return err
 }
 
-func GetAccountByUserId(userId string) (*AdminAccount, error) {
+func (ac *AdminAccount) UpdateAuthHash(txDb *gorm.DB, authHash string) error {
+	err := txDb.Model(&AdminAccount{}).Where("account_id = ?", ac.AccountID).
+		Updates(map[string]interface{}{"auth_hash": authHash, "auth_string": ""}).
+		Error
+	return err
+}
+
+func GetAccountByAuthHash(txDb *gorm.DB, authHash string) (*AdminAccount, error) {
+	var account AdminAccount
+	err := txDb.Where("auth_hash = ?", authHash).First(&account).Error
+	return &account, err
+}
+
+// Hashes auth strings still stored in plaintext and removes the plaintext.
+func MigrateAuthStrings(txDb *gorm.DB, hash func(authString string) string) error {
+	accounts := make([]AdminAccount, 0)
+	err := txDb.Where("auth_string <> ''").Find(&accounts).Error
+	if err != nil {
+		return err
+	}
+
+	for _, account := range accounts {
+		err := account.UpdateAuthHash(txDb, hash(account.AuthString))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Soft deletes an account, it can no longer log in.
+func DeleteAccountByID(txDb *gorm.DB, accountID string) error {
+	err := txDb.Where("account_id = ?", accountID).Delete(&AdminAccount{}).Error
+	return err
+}
+
+func (ac *AdminAccount) UpdateSuspended(txDb *gorm.DB, suspended bool) error {
+	err := txDb.Model(&AdminAccount{}).Where("account_id = ?", ac.AccountID).Update("suspended", suspended).Error
+	return err
+}
+
+// Soft deletes an account and removes it from its club.
+// When it was the last manager, the club is unpublished and its pictures archived. When it was the owner,
+// the longest standing co-manager becomes the owner. Favourite and view logs are kept for analytics.
+func DeleteAccountCascade(db *gorm.DB, accountID string) error {
+	account, err := GetAccountByUserId(db, accountID)
+	if err != nil {
+		return err
+	}
+
+	txDb := db.Begin()
+	err = DeleteAccountByID(txDb, accountID)
+	if err != nil {
+		txDb.Rollback()
+		return err
+	}
+
+	if account.ClubID != "" {
+		err = removeClubManager(txDb, account)
+		if err != nil {
+			txDb.Rollback()
+			return err
+		}
+	}
+
+	return txDb.Commit().Error
+}
+
+func removeClubManager(txDb *gorm.DB, account *AdminAccount) error {
+	var membership ClubMembership
+	err := txDb.Where("club_id = ? AND account_id = ?", account.ClubID, account.AccountID).First(&membership).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+	err = DeleteClubMembership(txDb, account.ClubID, account.AccountID)
+	if err != nil {
+		return err
+	}
+	// Nobody may join on behalf of a removed manager
+	err = txDb.Where("club_id = ? AND invited_by = ? AND accepted_by = ''", account.ClubID, account.AccountID).
+		Delete(&ClubInvite{}).Error
+	if err != nil {
+		return err
+	}
+
+	remaining := make([]ClubMembership, 0)
+	err = txDb.Where("club_id = ?", account.ClubID).Order("created_at").Find(&remaining).Error
+	if err != nil {
+		return err
+	}
+
+	// Last manager gone, the club goes with it
+	if len(remaining) == 0 {
+		err = txDb.Model(&ClubInfo{}).Where("club_id = ?", account.ClubID).Update("published", false).Error
+		if err != nil {
+			return err
+		}
+		err = txDb.Where("club_id = ? AND accepted_by = ''", account.ClubID).Delete(&ClubInvite{}).Error
+		if err != nil {
+			return err
+		}
+		err = txDb.Model(&AccountPicture{}).Where("club_id = ?", account.ClubID).Update("archived", true).Error
+		return err
+	}
+
+	if membership.Role == MEMBER_OWNER {
+		err = txDb.Model(&ClubMembership{}).Where("club_id = ? AND account_id = ?", account.ClubID, remaining[0].AccountID).
+			Update("role", MEMBER_OWNER).Error
+	}
+	return err
+}
+
+func GetAccountByUserId(txDb *gorm.DB, userId string) (*AdminAccount, error) {
 	var account AdminAccount
-	err := DB.Where("account_id = ?", userId).First(&account).Error
+	err := txDb.Where("account_id = ?", userId).First(&account).Error
 	return &account, err
 }
 
-func GetTotalAccountNum() (int64, error) {
+func GetTotalAccountNum(txDb *gorm.DB) (int64, error) {
 	var num int64
-	err := DB.Table("admin_account").Count(&num).Error
+	err := txDb.Table("admin_account").Where("deleted_at IS NULL").Count(&num).Error
 	return num, err
 }
 
@@ -53,11 +191,12 @@ type AccountInfoCondition struct {
 	SortOrder string
 }
 
-func GetAllAccountInfoByCondition(condition *AccountInfoCondition) ([]AccountInfo, error) {
+func GetAllAccountInfoByCondition(txDb *gorm.DB, condition *AccountInfoCondition) ([]AccountInfo, error) {
 	var accounts []AccountInfo
 
-	baseQuery := DB.Select("a.*, c.name club_name").Table("admin_account a").
-		Joins("LEFT JOIN club_info c ON c.club_id = a.club_id")
+	baseQuery := txDb.Select("a.*, c.name club_name").Table("admin_account a").
+		Joins("LEFT JOIN club_info c ON c.club_id = a.club_id").
+		Where("a.deleted_at IS NULL")
 
 	if condition != nil {
 		if condition.SortBy != "" {
@@ -76,15 +215,260 @@ func GetAllAccountInfoByCondition(condition *AccountInfoCondition) ([]AccountInf
 	return accounts, err
 }
 
+const (
+	LOGIN_SUCCESS   = "SUCCESS"
+	LOGIN_FAILED    = "FAILED"
+	LOGIN_THROTTLED = "THROTTLED"
+	LOGIN_SUSPENDED = "SUSPENDED"
+)
+
+const (
+	// Created the club, can invite and revoke other managers
+	MEMBER_OWNER  = "OWNER"
+	MEMBER_EDITOR = "EDITOR"
+)
+
+// Managers of a club
+type ClubMembership struct {
+	gorm.Model
+	ClubID    string `gorm:"type:varchar(40);unique_index:uni_member" json:"club_id"`
+	AccountID string `gorm:"type:varchar(40);unique_index:uni_member" json:"account_id"`
+	Role      string `gorm:"type:varchar(20)"                         json:"role"`
+}
+
+func (cm *ClubMembership) Insert(txDb *gorm.DB) error {
+	err := txDb.Create(cm).Error
+	return err
+}
+
+func GetClubMembership(txDb *gorm.DB, clubID, accountID string) (*ClubMembership, error) {
+	var membership ClubMembership
+	err := txDb.Where("club_id = ? AND account_id = ?", clubID, accountID).First(&membership).Error
+	return &membership, err
+}
+
+func DeleteClubMembership(txDb *gorm.DB, clubID, accountID string) error {
+	err := txDb.Unscoped().Where("club_id = ? AND account_id = ?", clubID, accountID).Delete(&ClubMembership{}).Error
+	return err
+}
+
+type ClubManager struct {
+	AccountID string    `json:"account_id"`
+	Email     string    `json:"email"`
+	PhoneNum  string    `json:"phone_num"`
+	Role      string    `json:"role"`
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+func GetClubManagers(txDb *gorm.DB, clubID string) ([]ClubManager, error) {
+	managers := make([]ClubManager, 0)
+	err := txDb.Table("club_membership m").
+		Select("m.account_id, a.email, a.phone_num, m.role, m.created_at joined_at").
+		Joins("JOIN admin_account a ON a.account_id = m.account_id AND a.deleted_at IS NULL").
+		Where("m.club_id = ? AND m.deleted_at IS NULL", clubID).
+		Order("m.created_at").
+		Scan(&managers).Error
+	return managers, err
+}
+
+// Invitations for new club managers
+type ClubInvite struct {
+	gorm.Model
+	// Keyed hash of the invite token sent by email
+	InviteHash string `gorm:"type:varchar(64);unique_index"`
+	ClubID     string `gorm:"type:varchar(40);index"`
+	Email      string `gorm:"type:varchar(100)"`
+	InvitedBy  string `gorm:"type:varchar(40)"`
+	ExpiresAt  time.Time
+	// Account created when the invite was accepted
+	AcceptedBy string `gorm:"type:varchar(40)"`
+}
+
+func (ci *ClubInvite) Insert(txDb *gorm.DB) error {
+	err := txDb.Create(ci).Error
+	return err
+}
+
+func GetClubInviteByHash(txDb *gorm.DB, inviteHash string) (*ClubInvite, error) {
+	var invite ClubInvite
+	err := txDb.Where("invite_hash = ?", inviteHash).First(&invite).Error
+	return &invite, err
+}
+
+// Marks an open, unexpired invite accepted. Returns false when it was used or expired meanwhile.
+func AcceptClubInvite(txDb *gorm.DB, inviteHash, accountID string, now time.Time) (bool, error) {
+	result := txDb.Model(&ClubInvite{}).
+		Where("invite_hash = ? AND accepted_by = '' AND expires_at > ?", inviteHash, now).
+		Update("accepted_by", accountID)
+	return result.RowsAffected == 1, result.Error
+}
+
 // Admin Account Login History
 type LoginHistory struct {
 	gorm.Model
-	Username string `gorm:"not null;index:username"`
-	IP       string
+	// Account id of the login, empty when no account matched
+	Username string `gorm:"not null;index:username" json:"username"`
+	IP       string `gorm:"index"                   json:"ip"`
 	// Whether the login attempt is successful
-	AttemptResult string `gorm:"not null;"`
+	AttemptResult string `gorm:"not null;" json:"attempt_result"`
 	// Dump the header info associated with this session
-	HeaderDump string `gorm:"type:varchar(1000);"`
+	HeaderDump string `gorm:"type:varchar(1000);" json:"header_dump"`
+}
+
+func (lh *LoginHistory) Insert(txDb *gorm.DB) error {
+	err := txDb.Create(lh).Error
+	return err
+}
+
+type LoginHistoryCondition struct {
+	PageRequest
+	Username      string
+	IP            string
+	AttemptResult string
+	From          time.Time
+	To            time.Time
+}
+
+func loginHistoryQuery(txDb *gorm.DB, condition *LoginHistoryCondition) *gorm.DB {
+	baseQuery := txDb.Model(&LoginHistory{})
+	if condition == nil {
+		return baseQuery
+	}
+	if condition.Username != "" {
+		baseQuery = baseQuery.Where("username = ?", condition.Username)
+	}
+	if condition.IP != "" {
+		baseQuery = baseQuery.Where("ip = ?", condition.IP)
+	}
+	if condition.AttemptResult != "" {
+		baseQuery = baseQuery.Where("attempt_result = ?", condition.AttemptResult)
+	}
+	if !condition.From.IsZero() {
+		baseQuery = baseQuery.Where("created_at >= ?", condition.From)
+	}
+	if !condition.To.IsZero() {
+		baseQuery = baseQuery.Where("created_at < ?", condition.To)
+	}
+	return baseQuery
+}
+
+// Returns login history matching the condition, latest first.
+func GetLoginHistoryByCondition(txDb *gorm.DB, condition *LoginHistoryCondition) ([]LoginHistory, error) {
+	histories := make([]LoginHistory, 0)
+	baseQuery := loginHistoryQuery(txDb, condition).Order("created_at DESC")
+
+	//pagination
+	if condition != nil {
+		if condition.Offset != 0 {
+			baseQuery = baseQuery.Offset(condition.Offset)
+		}
+		if condition.Limit != 0 {
+			baseQuery = baseQuery.Limit(condition.Limit)
+		}
+	}
+
+	err := baseQuery.Find(&histories).Error
+	return histories, err
+}
+
+func GetLoginHistoryNumByCondition(txDb *gorm.DB, condition *LoginHistoryCondition) (int64, error) {
+	var num int64
+	err := loginHistoryQuery(txDb, condition).Count(&num).Error
+	return num, err
+}
+
+const (
+	CHALLENGE_PENDING  = "PENDING"
+	CHALLENGE_APPROVED = "APPROVED"
+	CHALLENGE_CONSUMED = "CONSUMED"
+)
+
+// QR code login challenges. A challenge is approved by a logged in device and consumed once by the browser that created it.
+type LoginChallenge struct {
+	gorm.Model
+	ChallengeID string `gorm:"type:varchar(40);unique_index"`
+	// Keyed hash of the poll token only known to the browser waiting for the challenge
+	PollHash   string    `gorm:"type:varchar(64)"`
+	Status     string    `gorm:"type:varchar(20)"`
+	ApprovedBy string    `gorm:"type:varchar(40)"`
+	ExpiresAt  time.Time `gorm:"index"`
+}
+
+func (lc *LoginChallenge) Insert(txDb *gorm.DB) error {
+	err := txDb.Create(lc).Error
+	return err
+}
+
+func GetLoginChallengeByID(txDb *gorm.DB, challengeID string) (*LoginChallenge, error) {
+	var challenge LoginChallenge
+	err := txDb.Where("challenge_id = ?", challengeID).First(&challenge).Error
+	return &challenge, err
+}
+
+// Approves a pending, unexpired challenge. Returns false when the challenge can not be approved (anymore).
+func ApproveLoginChallenge(txDb *gorm.DB, challengeID, accountID string, now time.Time) (bool, error) {
+	result := txDb.Model(&LoginChallenge{}).
+		Where("challenge_id = ? AND status = ? AND expires_at > ?", challengeID, CHALLENGE_PENDING, now).
+		Updates(map[string]interface{}{"status": CHALLENGE_APPROVED, "approved_by": accountID})
+	return result.RowsAffected == 1, result.Error
+}
+
+// Marks an approved challenge consumed. Only one caller can ever get true for a challenge.
+func ConsumeLoginChallenge(txDb *gorm.DB, challengeID string, now time.Time) (bool, error) {
+	result := txDb.Model(&LoginChallenge{}).
+		Where("challenge_id = ? AND status = ? AND expires_at > ?", challengeID, CHALLENGE_APPROVED, now).
+		Update("status", CHALLENGE_CONSUMED)
+	return result.RowsAffected == 1, result.Error
+}
+
+func DeleteExpiredLoginChallenges(txDb *gorm.DB, now time.Time) error {
+	err := txDb.Unscoped().Where("expires_at < ?", now).Delete(&LoginChallenge{}).Error
+	return err
+}
+
+// Admin sessions persisted server side, so that they survive restarts and are shared between instances
+type AdminSession struct {
+	gorm.Model
+	SessionID string `gorm:"type:varchar(64);unique_index"`
+	AccountID string `gorm:"type:varchar(40);index"`
+	// The size makes it a blob on every database instead of varbinary(255) on MySQL
+	Data         []byte    `gorm:"size:65535"`
+	ExpiresAt    time.Time `gorm:"index"`
+	LastActiveAt time.Time
+}
+
+func GetAdminSessionByID(txDb *gorm.DB, sessionID string) (*AdminSession, error) {
+	var session AdminSession
+	err := txDb.Where("session_id = ?", sessionID).First(&session).Error
+	return &session, err
+}
+
+func (as *AdminSession) InsertOrUpdate(txDb *gorm.DB) error {
+	err := txDb.Where("session_id = ?", as.SessionID).
+		Assign(map[string]interface{}{"account_id": as.AccountID, "data": as.Data, "expires_at": as.ExpiresAt, "last_active_at": as.LastActiveAt}).
+		FirstOrCreate(as).Error
+	return err
+}
+
+func TouchAdminSession(txDb *gorm.DB, sessionID string, at time.Time) error {
+	err := txDb.Model(&AdminSession{}).Where("session_id = ?", sessionID).Update("last_active_at", at).Error
+	return err
+}
+
+// Sessions are revoked by removing them, so hard delete here.
+func DeleteAdminSession(txDb *gorm.DB, sessionID string) error {
+	err := txDb.Unscoped().Where("session_id = ?", sessionID).Delete(&AdminSession{}).Error
+	return err
+}
+
+func DeleteAdminSessionsByAccountID(txDb *gorm.DB, accountID string) error {
+	err := txDb.Unscoped().Where("account_id = ?", accountID).Delete(&AdminSession{}).Error
+	return err
+}
+
+func DeleteExpiredAdminSessions(txDb *gorm.DB, now time.Time) error {
+	err := txDb.Unscoped().Where("expires_at < ?", now).Delete(&AdminSession{}).Error
+	return err
 }
 
 // Club Information
@@ -97,38 +481,82 @@ type ClubInfo struct {
 	GroupLink string `gorm:"type:varchar(500);"             json:"group_link"`
 	VideoLink string `gorm:"type:varchar(500);"             json:"video_link"`
 	// Whether the club is viewable
-	Published   bool   `gorm:"type:tinyint(1);index" json:"published"`
+	Published   bool   `gorm:"index" json:"published"`
 	Description string `gorm:"type:varchar(4000);" json:"description"`
 
 	LogoID string `gorm:"type:varchar(40)" json:"logo_id"`
-	// Stores the ID of the pictures. The first picture will also be the cover photo
-	Pic1ID string `gorm:"type:varchar(500);" json:"pic1_id"`
-	Pic2ID string `gorm:"type:varchar(500);" json:"pic2_id"`
-	Pic3ID string `gorm:"type:varchar(500);" json:"pic3_id"`
-	Pic4ID string `gorm:"type:varchar(500);" json:"pic4_id"`
-	Pic5ID string `gorm:"type:varchar(500);" json:"pic5_id"`
-	Pic6ID string `gorm:"type:varchar(500);" json:"pic6_id"`
+	// Gallery pictures are kept in ClubPicture
 }
 
-func UpdateClubPublishedOrNot(clubID string,published bool) error {
-	err := DB.Model(ClubInfo{}).Where("club_id = ?",clubID).Update("published", published).Error
+// Pictures in the gallery of a club
+type ClubPicture struct {
+	gorm.Model
+	ClubID    string `gorm:"type:varchar(40);unique_index:uni_club_picture" json:"club_id"`
+	PictureID string `gorm:"type:varchar(40);unique_index:uni_club_picture;index" json:"picture_id"`
+	// Order in the gallery starting from 1
+	Position int    `json:"position"`
+	Caption  string `gorm:"type:varchar(200)" json:"caption"`
+	// The picture shown on the swipe card, one per club
+	Cover bool `json:"cover"`
+}
+
+func GetClubGallery(txDb *gorm.DB, clubID string) ([]ClubPicture, error) {
+	pictures := make([]ClubPicture, 0)
+	err := txDb.Where("club_id = ?", clubID).Order("position").Find(&pictures).Error
+	return pictures, err
+}
+
+// Replaces the whole gallery of a club.
+func ReplaceClubGallery(txDb *gorm.DB, clubID string, pictures []ClubPicture) error {
+	err := txDb.Unscoped().Where("club_id = ?", clubID).Delete(&ClubPicture{}).Error
+	if err != nil {
+		return err
+	}
+	for i := range pictures {
+		pictures[i].ClubID = clubID
+		if err := txDb.Create(&pictures[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Gives the gallery pictures the positions of their order in pictureIDs.
+func ReorderClubGallery(db *gorm.DB, clubID string, pictureIDs []string) error {
+	txDb := db.Begin()
+	for idx, pictureID := range pictureIDs {
+		err := txDb.Model(&ClubPicture{}).Where("club_id = ? AND picture_id = ?", clubID, pictureID).
+			Update("position", idx+1).Error
+		if err != nil {
+			txDb.Rollback()
+			return err
+		}
+	}
+	return txDb.Commit().Error
+}
+
+func UpdateClubPublishedOrNot(txDb *gorm.DB, clubID string, published bool) error {
+	err := txDb.Model(ClubInfo{}).Where("club_id = ?", clubID).Update("published", published).Error
 	return err
 }
 
 type ClubInfoCount struct {
 	ClubInfo
 	FavouriteNum int64 `json:"favourite_num"`
-	ViewNum      int64 `json:"view_num"`
+	// Times the club was read, at most once per view list
+	ViewNum int64 `json:"view_num"`
+	// Users having read the club
+	UniqueViewerNum int64 `json:"unique_viewer_num"`
 }
 
-func GetClubInfoCountByClubId(id string) (ClubInfoCount, error) {
+func GetClubInfoCountByClubId(txDb *gorm.DB, id string) (ClubInfoCount, error) {
 	var clubInfo ClubInfoCount
-	favouriteNumQuery := DB.Select("club_id, count(*) favourite_num").Table("user_favourite").Group("club_id").Having("club_id = ?",id).SubQuery()
-	viewNumQuery := DB.Select("club_id, count(*) view_num").Table("view_list_log").Group("club_id").Having("club_id = ?", id).SubQuery()
-	err := DB.Table("club_info c").Select("c.*, f.favourite_num, v.view_num").
+	favouriteNumQuery := txDb.Select("club_id, count(*) favourite_num").Table("user_favourite").Where("club_id = ?", id).Group("club_id").SubQuery()
+	viewNumQuery := txDb.Select("club_id, count(*) view_num, count(distinct loop_uid) unique_viewer_num").Table("view_list_log").Where("club_id = ?", id).Group("club_id").SubQuery()
+	err := txDb.Table("club_info c").Select("c.*, f.favourite_num, v.view_num, v.unique_viewer_num").
 		Joins("LEFT JOIN ? f ON c.club_id = f.club_id", favouriteNumQuery).
 		Joins("LEFT JOIN ? v ON c.club_id = v.club_id", viewNumQuery).
-		Where("c.club_id = ?",id).
+		Where("c.club_id = ?", id).
 		Scan(&clubInfo).Error
 	return clubInfo, err
 }
@@ -147,23 +575,23 @@ type ClubInfoCondition struct {
 	Published string
 }
 
-//Returns given condition club info and their count of favourite num and view num.
-func GetClubInfoCountsByCondition(condition *ClubInfoCondition) ([]ClubInfoCount, error) {
+// Returns given condition club info and their count of favourite num and view num.
+func GetClubInfoCountsByCondition(txDb *gorm.DB, condition *ClubInfoCondition) ([]ClubInfoCount, error) {
 	var clubInfos []ClubInfoCount
 
-	favouriteNumQuery := DB.Select("club_id, count(*) favourite_num").Table("user_favourite").Group("club_id").SubQuery()
-	viewNumQuery := DB.Select("club_id, count(*) view_num").Table("view_list_log").Group("club_id").SubQuery()
-	baseQuery := DB.Table("club_info c").Select("c.*, f.favourite_num, v.view_num").
+	favouriteNumQuery := txDb.Select("club_id, count(*) favourite_num").Table("user_favourite").Group("club_id").SubQuery()
+	viewNumQuery := txDb.Select("club_id, count(*) view_num, count(distinct loop_uid) unique_viewer_num").Table("view_list_log").Group("club_id").SubQuery()
+	baseQuery := txDb.Table("club_info c").Select("c.*, f.favourite_num, v.view_num, v.unique_viewer_num").
 		Joins("LEFT JOIN ? f ON c.club_id = f.club_id", favouriteNumQuery).
 		Joins("LEFT JOIN ? v ON c.club_id = v.club_id", viewNumQuery)
 
 	//search by conditions
 	if condition != nil {
 		if condition.Published == "true" {
-			baseQuery = baseQuery.Where("c.published = 1")
+			baseQuery = baseQuery.Where("c.published = ?", true)
 		}
 		if condition.Published == "false" {
-			baseQuery = baseQuery.Where("c.published = 0")
+			baseQuery = baseQuery.Where("c.published = ?", false)
 		}
 		if condition.SortBy != "" {
 			baseQuery = baseQuery.Order("c.created_at " + condition.SortOrder)
@@ -181,21 +609,21 @@ func GetClubInfoCountsByCondition(condition *ClubInfoCondition) ([]ClubInfoCount
 	return clubInfos, err
 }
 
-func GetClubInfoNumByCondition(condition *ClubInfoCondition) (int64, error) {
+func GetClubInfoNumByCondition(txDb *gorm.DB, condition *ClubInfoCondition) (int64, error) {
 	var num int64
 
-	favouriteNumQuery := DB.Select("club_id, count(*) favourite_num").Table("user_favourite").Group("club_id").SubQuery()
-	viewNumQuery := DB.Select("club_id, count(*) view_num").Table("view_list_log").Group("club_id").SubQuery()
-	baseQuery := DB.Table("club_info c").
+	favouriteNumQuery := txDb.Select("club_id, count(*) favourite_num").Table("user_favourite").Group("club_id").SubQuery()
+	viewNumQuery := txDb.Select("club_id, count(*) view_num").Table("view_list_log").Group("club_id").SubQuery()
+	baseQuery := txDb.Table("club_info c").
 		Joins("LEFT JOIN ? f ON c.club_id = f.club_id", favouriteNumQuery).
 		Joins("LEFT JOIN ? v ON c.club_id = v.club_id", viewNumQuery)
 
 	if condition != nil {
 		if condition.Published == "true" {
-			baseQuery = baseQuery.Where("c.published = 1")
+			baseQuery = baseQuery.Where("c.published = ?", true)
 		}
 		if condition.Published == "false" {
-			baseQuery = baseQuery.Where("c.published = 0")
+			baseQuery = baseQuery.Where("c.published = ?", false)
 		}
 	}
 
@@ -208,60 +636,62 @@ func (ci *ClubInfo) Insert(txDb *gorm.DB) error {
 	return err
 }
 
-func GetClubInfoByClubId(id string) (*ClubInfo, error) {
+func GetClubInfoByClubId(txDb *gorm.DB, id string) (*ClubInfo, error) {
 	clubInfo := &ClubInfo{}
-	err := DB.Where("club_id = ?", id).Find(clubInfo).Error
+	err := txDb.Where("club_id = ?", id).Find(clubInfo).Error
 	return clubInfo, err
 }
 
-func GetPublishedClubInfosByClubIds(ids []string) ([]ClubInfo, error) {
+func GetPublishedClubInfosByClubIds(txDb *gorm.DB, ids []string) ([]ClubInfo, error) {
 	clubInfos := make([]ClubInfo, 0)
-	err := DB.Where("club_id in (?) AND published = 1", ids).Find(&clubInfos).Error
+	err := txDb.Where("club_id in (?) AND published = ?", ids, true).Find(&clubInfos).Error
 	return clubInfos, err
 }
 
-//Club manager may add or remove info in club, so we must update all columns, even the columns have default value.
+// Club manager may add or remove info in club, so we must update all columns, even the columns have default value.
 func (ci *ClubInfo) Update(txDb *gorm.DB) error {
 	err := txDb.Model(&ClubInfo{}).Where("club_id = ?", ci.ClubID).
 		Updates(map[string]interface{}{"name": ci.Name, "website": ci.Website, "email": ci.Email, "group_link": ci.GroupLink, "video_link": ci.VideoLink, "published": ci.Published, "description": ci.Description,
-			"logo_id": ci.LogoID, "pic1_id": ci.Pic1ID, "pic2_id": ci.Pic2ID, "pic3_id": ci.Pic3ID, "pic4_id": ci.Pic4ID, "pic5_id": ci.Pic5ID, "pic6_id": ci.Pic6ID}).Error
+			"logo_id": ci.LogoID}).Error
 	return err
 }
 
-//FavouriteClubInfo is a assist struct to query club info to app user.
+// FavouriteClubInfo is a assist struct to query club info to app user.
 type FavouriteClubInfo struct {
 	ClubInfo
 	Favourite bool
 }
 
-//Get all club infos attached with current user favourite or not
-func GetAllPublishedFavouriteClubInfo(uid string) ([]FavouriteClubInfo, error) {
+// Get all club infos attached with current user favourite or not
+func GetAllPublishedFavouriteClubInfo(txDb *gorm.DB, uid string) ([]FavouriteClubInfo, error) {
 	favouriteClubInfos := make([]FavouriteClubInfo, 0)
-	err := DB.Table("club_info c").Select("c.*, f.favourite").
-		Joins("LEFT JOIN (SELECT * FROM user_favourite WHERE loop_uid = ?) f ON c.club_id = f.club_id WHERE c.published = 1", uid).
+	err := txDb.Table("club_info c").Select("c.*, f.favourite").
+		Joins("LEFT JOIN (SELECT * FROM user_favourite WHERE loop_uid = ?) f ON c.club_id = f.club_id", uid).
+		Where("c.published = ?", true).
 		Scan(&favouriteClubInfos).
 		Error
 	return favouriteClubInfos, err
 }
 
-func GetUnreadPublishedFavouriteClubInfo(uid, viewListID string) ([]FavouriteClubInfo, error) {
+func GetUnreadPublishedFavouriteClubInfo(txDb *gorm.DB, uid, viewListID string) ([]FavouriteClubInfo, error) {
 	favouriteClubInfos := make([]FavouriteClubInfo, 0)
-	userFavourite := DB.Select("*").Table("user_favourite").Where("loop_uid = ?", uid).SubQuery()
-	readClubIds := DB.Select("club_id").Table("view_list_log").Where("loop_uid = ? AND view_list_id = ?", uid, viewListID).SubQuery()
+	userFavourite := txDb.Select("*").Table("user_favourite").Where("loop_uid = ?", uid).SubQuery()
+	readClubIds := txDb.Select("club_id").Table("view_list_log").Where("loop_uid = ? AND view_list_id = ?", uid, viewListID).SubQuery()
 
-	err := DB.Table("club_info c").Select("c.*, f.favourite").
+	err := txDb.Table("club_info c").Select("c.*, f.favourite").
 		Joins("LEFT JOIN ? f ON c.club_id = f.club_id", userFavourite).
 		Joins("LEFT JOIN ? v ON v.club_id = c.club_id", readClubIds).
-		Where("c.published = 1 and v.club_id IS NULL").
+		Where("c.published = ? and v.club_id IS NULL", true).
 		Scan(&favouriteClubInfos).
 		Error
 	return favouriteClubInfos, err
 }
 
-func GetAllPublishedFavouriteClubInfoByClubIDs(uid string, ids []string) ([]FavouriteClubInfo, error) {
+func GetAllPublishedFavouriteClubInfoByClubIDs(txDb *gorm.DB, uid string, ids []string) ([]FavouriteClubInfo, error) {
 	favouriteClubInfos := make([]FavouriteClubInfo, 0)
-	err := DB.Table("club_info c").Select("c.*, f.favourite").
-		Joins("LEFT JOIN (SELECT * FROM user_favourite WHERE loop_uid = ?) f ON c.club_id = f.club_id WHERE c.club_id in (?) and c.published = 1", uid, ids).
+	err := txDb.Table("club_info c").Select("c.*, f.favourite").
+		Joins("LEFT JOIN (SELECT * FROM user_favourite WHERE loop_uid = ?) f ON c.club_id = f.club_id", uid).
+		Where("c.club_id in (?) and c.published = ?", ids, true).
 		Scan(&favouriteClubInfos).
 		Error
 	return favouriteClubInfos, err
@@ -270,9 +700,20 @@ func GetAllPublishedFavouriteClubInfoByClubIDs(uid string, ids []string) ([]Favo
 // Account pictures uploaded
 type AccountPicture struct {
 	gorm.Model
-	AccountID   string `gorm:"type:varchar(40);index"  json:"account_id"`
+	AccountID string `gorm:"type:varchar(40);index"  json:"account_id"`
+	// Club of the uploading account, the picture can be used by every manager of the club
+	ClubID      string `gorm:"type:varchar(40);index"  json:"club_id"`
 	PictureID   string `gorm:"type:varchar(40);unique_index"  json:"picture_id"`
 	PictureName string `gorm:"type:varchar(60)"   json:"picture_name"`
+	// Detected from the content on upload, empty for pictures uploaded when only jpeg was accepted
+	MimeType string `gorm:"type:varchar(30)" json:"mime_type"`
+	// Hex SHA-256 of the original, used as ETag. Filled in when first served for older pictures.
+	ContentHash string `gorm:"type:varchar(64)" json:"-"`
+	Caption     string `gorm:"type:varchar(200)" json:"caption"`
+	// Description for screen readers
+	AltText string `gorm:"type:varchar(300)" json:"alt_text"`
+	// Pictures of deleted clubs are archived and no longer served
+	Archived bool `json:"archived"`
 }
 
 func (ap *AccountPicture) Insert(txDb *gorm.DB) error {
@@ -280,18 +721,67 @@ func (ap *AccountPicture) Insert(txDb *gorm.DB) error {
 	return err
 }
 
-func GetPictureNameById(pictureId string) (string, error) {
+func GetPictureById(txDb *gorm.DB, pictureId string) (*AccountPicture, error) {
 	var picture AccountPicture
-	err := DB.Where("picture_id = ?", pictureId).First(&picture).Error
-	if err != nil {
-		return "", err
-	}
-	return picture.PictureName, nil
+	err := txDb.Where("picture_id = ? AND archived = ?", pictureId, false).First(&picture).Error
+	return &picture, err
+}
+
+func UpdatePictureContentHash(txDb *gorm.DB, pictureId, contentHash string) error {
+	err := txDb.Model(&AccountPicture{}).Where("picture_id = ?", pictureId).Update("content_hash", contentHash).Error
+	return err
+}
+
+func GetClubPictures(txDb *gorm.DB, clubID string) ([]AccountPicture, error) {
+	pictures := make([]AccountPicture, 0)
+	err := txDb.Where("club_id = ? AND archived = ?", clubID, false).Order("created_at DESC").Find(&pictures).Error
+	return pictures, err
+}
+
+func GetClubPicture(txDb *gorm.DB, clubID, pictureID string) (*AccountPicture, error) {
+	var picture AccountPicture
+	err := txDb.Where("club_id = ? AND picture_id = ? AND archived = ?", clubID, pictureID, false).First(&picture).Error
+	return &picture, err
+}
+
+func (ap *AccountPicture) UpdateText(txDb *gorm.DB) error {
+	err := txDb.Model(&AccountPicture{}).Where("picture_id = ?", ap.PictureID).
+		Updates(map[string]interface{}{"caption": ap.Caption, "alt_text": ap.AltText}).Error
+	return err
 }
 
-func GetAccPictureIDS(accountId string) ([]AccountPicture, error) {
+// Matches account_picture rows that no club uses as logo or in its gallery
+func whereUnusedPicture(txDb *gorm.DB) *gorm.DB {
+	return txDb.Where("NOT EXISTS (SELECT 1 FROM club_info c WHERE c.logo_id = account_picture.picture_id)").
+		Where("NOT EXISTS (SELECT 1 FROM club_picture p WHERE p.picture_id = account_picture.picture_id AND p.deleted_at IS NULL)")
+}
+
+// Deletes the picture unless it is archived or used, and reports whether it was deleted. The delete checks the
+// usage itself, so a picture taken into use since it was found unused is kept.
+func DeleteUnusedPicture(txDb *gorm.DB, pictureID string) (bool, error) {
+	result := whereUnusedPicture(txDb.Where("picture_id = ? AND archived = ?", pictureID, false)).
+		Delete(&AccountPicture{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Pictures created before the given time that no club uses as logo or in its gallery.
+// Archived pictures of deleted clubs are kept.
+func GetUnusedPicturesCreatedBefore(txDb *gorm.DB, before time.Time) ([]AccountPicture, error) {
 	pictures := make([]AccountPicture, 0)
-	err := DB.Select("picture_id").Where("account_id = ?", accountId).Find(&pictures).Error
+	err := whereUnusedPicture(txDb.Where("archived = ? AND created_at < ?", false, before)).
+		Find(&pictures).Error
+	return pictures, err
+}
+
+func GetClubPictureIDs(txDb *gorm.DB, clubID string) ([]AccountPicture, error) {
+	pictures := make([]AccountPicture, 0)
+	err := txDb.Select("picture_id").Where("club_id = ? AND archived = ?", clubID, false).Find(&pictures).Error
+	return pictures, err
+}
+
+func GetAccPictureIDS(txDb *gorm.DB, accountId string) ([]AccountPicture, error) {
+	pictures := make([]AccountPicture, 0)
+	err := txDb.Select("picture_id").Where("account_id = ?", accountId).Find(&pictures).Error
 	return pictures, err
 }
 
@@ -301,20 +791,20 @@ type ClubTags struct {
 	Tag   string `gorm:"type:varchar(40);unique_index:uni_tag"`
 }
 
-func GetClubTagsByTagIds(ids []string) ([]ClubTags, error) {
+func GetClubTagsByTagIds(txDb *gorm.DB, ids []string) ([]ClubTags, error) {
 	tags := make([]ClubTags, 0)
-	err := DB.Where("tag_id in (?)", ids).Find(&tags).Error
+	err := txDb.Where("tag_id in (?)", ids).Find(&tags).Error
 	return tags, err
 }
 
-func (ct *ClubTags) Insert() error {
-	err := DB.Create(&ct).Error
+func (ct *ClubTags) Insert(txDb *gorm.DB) error {
+	err := txDb.Create(&ct).Error
 	return err
 }
 
-func GetAllClubTags() ([]ClubTags, error) {
+func GetAllClubTags(txDb *gorm.DB) ([]ClubTags, error) {
 	tags := make([]ClubTags, 0)
-	err := DB.Find(&tags).Error
+	err := txDb.Find(&tags).Error
 	return tags, err
 }
 
@@ -324,15 +814,21 @@ type ClubTagRelationship struct {
 	TagID  string `gorm:"type:varchar(40);index"`
 }
 
-func GetTagRelationshipsByTagIDs(tagIDs []string) ([]ClubTagRelationship, error) {
+func GetTagRelationshipsByTagIDs(txDb *gorm.DB, tagIDs []string) ([]ClubTagRelationship, error) {
 	relations := make([]ClubTagRelationship, 0)
-	err := DB.Select("DISTINCT(club_id),tag_id").Where("tag_id in (?)", tagIDs).Find(&relations).Error
+	err := txDb.Select("DISTINCT(club_id),tag_id").Where("tag_id in (?)", tagIDs).Find(&relations).Error
 	return relations, err
 }
 
-func GetTagRelationshipsByClubID(clubID string) ([]ClubTagRelationship, error) {
+func GetTagRelationshipsByClubID(txDb *gorm.DB, clubID string) ([]ClubTagRelationship, error) {
 	relations := make([]ClubTagRelationship, 0)
-	err := DB.Where("club_id = ?", clubID).Find(&relations).Error
+	err := txDb.Where("club_id = ?", clubID).Find(&relations).Error
+	return relations, err
+}
+
+func GetAllTagRelationships(txDb *gorm.DB) ([]ClubTagRelationship, error) {
+	relations := make([]ClubTagRelationship, 0)
+	err := txDb.Select("club_id, tag_id").Find(&relations).Error
 	return relations, err
 }
 
@@ -351,8 +847,8 @@ type UpdateUser struct {
 	SrcLoopUID string
 }
 
-func (u *UpdateUser) Update() error {
-	err := DB.Model(UserList{}).Where("loop_uid = ?", u.SrcLoopUID).
+func (u *UpdateUser) Update(txDb *gorm.DB) error {
+	err := txDb.Model(UserList{}).Where("loop_uid = ?", u.SrcLoopUID).
 		Updates(map[string]interface{}{"loop_uid": u.LoopUID, "loop_user_name": u.LoopUserName}).
 		Error
 	return err
@@ -360,66 +856,289 @@ func (u *UpdateUser) Update() error {
 
 type UserList struct {
 	gorm.Model
-	//consider user may cancel authorization, use index rather than unique index here.
-	LoopUID      string `gorm:"type:varchar(70);index"`
+	// Registering a LoopUID twice fails with a duplicate key error, also when both registrations run concurrently.
+	LoopUID      string `gorm:"type:varchar(70);unique_index"`
 	LoopUserName string `gorm:"type:varchar(50)"`
 	JoinTime     time.Time
+	// Keyed hash of the secret issued at register, which the app proves the user with on login.
+	// Users registered before secrets were issued have none, they can not log in and are removed by a migration.
+	SecretHash string `gorm:"type:varchar(64)"`
 }
 
-func (ul *UserList) Insert() error {
-	err := DB.Create(ul).Error
+func (ul *UserList) Insert(txDb *gorm.DB) error {
+	err := txDb.Create(ul).Error
 	return err
 }
 
-func GetAppUserByUid(uid string) (*UserList, error) {
+func GetAppUserByUid(txDb *gorm.DB, uid string) (*UserList, error) {
 	var user UserList
-	err := DB.Where("loop_uid = ?", uid).First(&user).Error
+	err := txDb.Where("loop_uid = ?", uid).First(&user).Error
 	return &user, err
 }
 
+// Revoked app user tokens. Rows can be removed once the token would have expired anyway.
+type RevokedToken struct {
+	gorm.Model
+	TokenID   string    `gorm:"type:varchar(40);unique_index"`
+	LoopUID   string    `gorm:"type:varchar(70);index"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// Fails with a duplicate key error when the token is revoked already.
+func (rt *RevokedToken) Insert(txDb *gorm.DB) error {
+	err := txDb.Create(rt).Error
+	return err
+}
+
+func IsTokenRevoked(txDb *gorm.DB, tokenID string) (bool, error) {
+	var num int64
+	err := txDb.Model(&RevokedToken{}).Where("token_id = ?", tokenID).Count(&num).Error
+	return num > 0, err
+}
+
+func DeleteExpiredRevokedTokens(txDb *gorm.DB, now time.Time) error {
+	err := txDb.Unscoped().Where("expires_at < ?", now).Delete(&RevokedToken{}).Error
+	return err
+}
+
 type ViewList struct {
 	//append only
 	gorm.Model
 	LoopUID    string `gorm:"type:varchar(70);unique_index:uni_view"`
 	ViewListID string `gorm:"type:varchar(40);unique_index:uni_view"`
+	// Set when the order of its clubs is stored, so a view list without unread clubs is not ranked again
+	ClubsOrdered bool
 }
 
-func GetLatestViewListByUID(uid string) (*ViewList, error) {
+func GetLatestViewListByUID(txDb *gorm.DB, uid string) (*ViewList, error) {
 	var viewList ViewList
-	err := DB.Where("loop_uid = ?", uid).Last(&viewList).Error
+	err := txDb.Where("loop_uid = ?", uid).Last(&viewList).Error
 	return &viewList, err
 }
 
-func (vl *ViewList) Insert() error {
-	err := DB.Create(vl).Error
+func (vl *ViewList) Insert(txDb *gorm.DB) error {
+	err := txDb.Create(vl).Error
 	return err
 }
 
+// Clubs of a view list in the order they are shown, fixed when the view list is fetched first
+type ViewListClub struct {
+	gorm.Model
+	ViewListID string `gorm:"type:varchar(40);unique_index:uni_view_list_club"`
+	ClubID     string `gorm:"type:varchar(40);unique_index:uni_view_list_club"`
+	// Order in the view list starting from 1
+	Position int
+}
+
+func GetViewListClubs(txDb *gorm.DB, viewListID string) ([]ViewListClub, error) {
+	clubs := make([]ViewListClub, 0)
+	err := txDb.Where("view_list_id = ?", viewListID).Order("position").Find(&clubs).Error
+	return clubs, err
+}
+
+// Stores the order of the clubs of a view list, clubIDs are given positions from 1 on, and marks the view list
+// ordered.
+func InsertViewListClubs(db *gorm.DB, viewListID string, clubIDs []string) error {
+	txDb := db.Begin()
+	for idx, clubID := range clubIDs {
+		err := txDb.Create(&ViewListClub{ViewListID: viewListID, ClubID: clubID, Position: idx + 1}).Error
+		if err != nil {
+			txDb.Rollback()
+			return err
+		}
+	}
+	err := txDb.Model(&ViewList{}).Where("view_list_id = ?", viewListID).Update("clubs_ordered", true).Error
+	if err != nil {
+		txDb.Rollback()
+		return err
+	}
+	return txDb.Commit().Error
+}
+
 type ViewListLog struct {
-	//append only
+	// One row per club read in a view list, updated_at is when it was read last
 	gorm.Model
-	ViewListID string `gorm:"type:varchar(40);index"`
-	LoopUID    string `gorm:"type:varchar(70);index"`
-	ClubID     string `gorm:"type:varchar(40);index"`
+	ViewListID string `gorm:"type:varchar(40);index;unique_index:uni_view_list_log"`
+	LoopUID    string `gorm:"type:varchar(70);index;unique_index:uni_view_list_log"`
+	ClubID     string `gorm:"type:varchar(40);index;unique_index:uni_view_list_log"`
 }
 
-func (l *ViewListLog) Insert() error {
-	err := DB.Create(l).Error
+func (l *ViewListLog) Insert(txDb *gorm.DB) error {
+	err := txDb.Create(l).Error
+	return err
+}
+
+// Inserts the log, or only touches updated_at when the club is read in the view list already
+func (l *ViewListLog) Upsert(txDb *gorm.DB) error {
+	conflict := "ON CONFLICT (view_list_id, loop_uid, club_id) DO UPDATE SET updated_at = excluded.updated_at"
+	if txDb.Dialect().GetName() == "mysql" {
+		conflict = "ON DUPLICATE KEY UPDATE updated_at = VALUES(updated_at)"
+	}
+	err := txDb.Set("gorm:insert_option", conflict).Create(l).Error
 	return err
 }
 
-func GetViewedListByID(uid, viewId string) ([]ViewListLog, error) {
+func GetViewedListByID(txDb *gorm.DB, uid, viewId string) ([]ViewListLog, error) {
 	logs := make([]ViewListLog, 0)
-	err := DB.Where("loop_uid = ? and view_list_id = ?", uid, viewId).Find(&logs).Error
+	err := txDb.Where("loop_uid = ? and view_list_id = ?", uid, viewId).Find(&logs).Error
 	return logs, err
 }
 
+const (
+	SWIPE_LIKE      = "LIKE"
+	SWIPE_SUPERLIKE = "SUPERLIKE"
+	// Hides the club from the view lists of the user for a while
+	SWIPE_PASS = "PASS"
+	// Goes on to the next club, the club shows up again in the next view list
+	SWIPE_SKIP = "SKIP"
+)
+
+func IsValidSwipeAction(action string) bool {
+	return action == SWIPE_LIKE || action == SWIPE_SUPERLIKE || action == SWIPE_PASS || action == SWIPE_SKIP
+}
+
+// The last swipe of a user on a club in a view list. Swiping again in the same view list replaces the action.
+type SwipeAction struct {
+	gorm.Model
+	ViewListID string `gorm:"type:varchar(40);unique_index:uni_swipe_action"`
+	LoopUID    string `gorm:"type:varchar(70);index"`
+	ClubID     string `gorm:"type:varchar(40);unique_index:uni_swipe_action"`
+	Action     string `gorm:"type:varchar(20)"`
+	// When the user swiped according to the app, unknown for swipes stored before it was kept
+	ClientTimestamp *time.Time
+}
+
+// Tells whether the user made the swipe before other. Swipes of unknown time are never earlier.
+func (sa *SwipeAction) SwipedBefore(other *SwipeAction) bool {
+	return sa.ClientTimestamp != nil && other.ClientTimestamp != nil && sa.ClientTimestamp.Before(*other.ClientTimestamp)
+}
+
+func GetSwipeAction(txDb *gorm.DB, viewListID, clubID string) (*SwipeAction, error) {
+	var swipe SwipeAction
+	err := txDb.Where("view_list_id = ? and club_id = ?", viewListID, clubID).First(&swipe).Error
+	return &swipe, err
+}
+
+// Clubs the user passed on since the given time, in any view list
+func GetPassedClubIDs(txDb *gorm.DB, uid string, since time.Time) ([]string, error) {
+	swipes := make([]SwipeAction, 0)
+	err := txDb.Select("club_id").Where("loop_uid = ? and action = ? and updated_at >= ?", uid, SWIPE_PASS, since).
+		Find(&swipes).Error
+	clubIDs := make([]string, 0, len(swipes))
+	for _, swipe := range swipes {
+		clubIDs = append(clubIDs, swipe.ClubID)
+	}
+	return clubIDs, err
+}
+
+// Stores the swipe, or changes the action of the earlier swipe on the club in the view list. The first swipe marks
+// the club read in the view list. Likes favour the club and passes unfavour it, nothing is written when the action
+// is unchanged. Swipes may arrive late, a swipe made before the stored one does not replace it.
+func SaveSwipeAction(txDb *gorm.DB, swipe *SwipeAction) error {
+	var existing SwipeAction
+	err := txDb.Where("view_list_id = ? and club_id = ?", swipe.ViewListID, swipe.ClubID).First(&existing).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+	if err == nil {
+		unchanged := existing.Action == swipe.Action
+		if swipe.SwipedBefore(&existing) || (unchanged && !existing.SwipedBefore(swipe)) {
+			*swipe = existing
+			return nil
+		}
+		err = txDb.Model(&existing).
+			Updates(map[string]interface{}{"action": swipe.Action, "client_timestamp": swipe.ClientTimestamp}).Error
+		if err != nil {
+			return err
+		}
+		existing.Action = swipe.Action
+		existing.ClientTimestamp = swipe.ClientTimestamp
+		*swipe = existing
+		if unchanged {
+			return nil
+		}
+	} else {
+		err = txDb.Create(swipe).Error
+		if err != nil {
+			return err
+		}
+		viewLog := ViewListLog{ViewListID: swipe.ViewListID, LoopUID: swipe.LoopUID, ClubID: swipe.ClubID}
+		err = viewLog.Upsert(txDb)
+		if err != nil {
+			return err
+		}
+	}
+
+	if swipe.Action == SWIPE_SKIP {
+		return nil
+	}
+	favourite := swipe.Action != SWIPE_PASS
+	var current UserFavourite
+	err = txDb.Where("loop_uid = ? and club_id = ?", swipe.LoopUID, swipe.ClubID).First(&current).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+	if current.Favourite == favourite {
+		return nil
+	}
+	return SetFavourite(txDb, swipe.LoopUID, swipe.ClubID, favourite)
+}
+
+// Events the app sent in one batch, kept so a replayed batch is answered with the results of the first one
+type SwipeBatch struct {
+	gorm.Model
+	LoopUID       string `gorm:"type:varchar(70);unique_index:uni_swipe_batch"`
+	ClientEventID string `gorm:"type:varchar(64);unique_index:uni_swipe_batch"`
+	// Results of the events as JSON
+	Results string `gorm:"type:text"`
+}
+
+func GetSwipeBatch(txDb *gorm.DB, uid, clientEventID string) (*SwipeBatch, error) {
+	var batch SwipeBatch
+	err := txDb.Where("loop_uid = ? and client_event_id = ?", uid, clientEventID).First(&batch).Error
+	return &batch, err
+}
+
+func (b *SwipeBatch) Insert(txDb *gorm.DB) error {
+	err := txDb.Create(b).Error
+	return err
+}
+
+// An event of a batch by the ID the app gave it, so an event sent again in another batch is not applied twice
+type SwipeEvent struct {
+	gorm.Model
+	LoopUID       string `gorm:"type:varchar(70);unique_index:uni_swipe_event"`
+	ClientEventID string `gorm:"type:varchar(64);unique_index:uni_swipe_event"`
+	ViewListID    string `gorm:"type:varchar(40)"`
+	ClubID        string `gorm:"type:varchar(40)"`
+	// READ or the swipe action
+	Action          string `gorm:"type:varchar(20)"`
+	ClientTimestamp time.Time
+}
+
+// Returns which of the client event IDs of the user are stored already
+func GetSwipeEventIDs(txDb *gorm.DB, uid string, clientEventIDs []string) ([]string, error) {
+	events := make([]SwipeEvent, 0)
+	err := txDb.Select("client_event_id").Where("loop_uid = ? and client_event_id in (?)", uid, clientEventIDs).
+		Find(&events).Error
+	ids := make([]string, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ClientEventID)
+	}
+	return ids, err
+}
+
+func (e *SwipeEvent) Insert(txDb *gorm.DB) error {
+	err := txDb.Create(e).Error
+	return err
+}
+
 type UserFavourite struct {
 	//append and delete
 	gorm.Model
 	LoopUID   string `gorm:"type:varchar(70);index"`
 	ClubID    string `gorm:"type:varchar(40);index"`
-	Favourite bool   `gorm:"type:tinyint(1)"`
+	Favourite bool
 }
 
 func (f *UserFavourite) InsertOrUpdate(txDb *gorm.DB) error {
@@ -427,9 +1146,16 @@ func (f *UserFavourite) InsertOrUpdate(txDb *gorm.DB) error {
 	return err
 }
 
-func GetUserFavouritesByUID(uid string) ([]UserFavourite, error) {
+func GetUserFavouritesByUID(txDb *gorm.DB, uid string) ([]UserFavourite, error) {
+	favourites := make([]UserFavourite, 0)
+	err := txDb.Where("loop_uid = ? and favourite = ?", uid, true).Find(&favourites).Error
+	return favourites, err
+}
+
+// Current favourites of all users, for ranking clubs
+func GetAllUserFavourites(txDb *gorm.DB) ([]UserFavourite, error) {
 	favourites := make([]UserFavourite, 0)
-	err := DB.Where("loop_uid = ? and favourite = 1", uid).Find(&favourites).Error
+	err := txDb.Select("loop_uid, club_id").Where("favourite = ?", true).Find(&favourites).Error
 	return favourites, err
 }
 
@@ -450,3 +1176,27 @@ func (l *UserFavouriteLog) Insert(txDb *gorm.DB) error {
 	err := txDb.Create(l).Error
 	return err
 }
+
+// Favours or unfavours the club and logs the action
+func SetFavourite(txDb *gorm.DB, uid, clubID string, favourite bool) error {
+	userFavourite := UserFavourite{
+		LoopUID:   uid,
+		ClubID:    clubID,
+		Favourite: favourite,
+	}
+	err := userFavourite.InsertOrUpdate(txDb)
+	if err != nil {
+		return err
+	}
+
+	action := UNFAVORITE_ACTION
+	if favourite {
+		action = FAVORITE_ACTION
+	}
+	favouriteLog := UserFavouriteLog{
+		LoopUID: uid,
+		ClubID:  clubID,
+		Action:  action,
+	}
+	return favouriteLog.Insert(txDb)
+}
@@ -1,40 +1,89 @@
 package db
 
 import (
-	"log"
+	"os"
 	"testing"
 	"tinder-for-clubs-backend/config"
 )
 
-func initTestConfiguration() *config.GlobalConfiguration {
-	configuration := config.GlobalConfiguration{
-		DBCredential: config.DBCredential{
-			DBAddress: "127.0.0.1",
-			DBName: "tinder-for-clubs",
-			DBPort: "3306",
-			DBUser: "root",
-			DBPass: "root",
-		},
-	}
-	return &configuration
+// Opens an empty database as the global DB, replacing the one of the previous test.
+// Tests run on an in-memory SQLite database unless TEST_DB_DRIVER selects mysql or postgres, which are
+// configured with TEST_DB_ADDRESS, TEST_DB_PORT, TEST_DB_USER, TEST_DB_PASS and TEST_DB_NAME.
+func initTestDB(t *testing.T) {
+	if DB != nil {
+		DB.Close()
+	}
+	dbCred := testDBCredential()
+	Init(dbCred)
+	DB.LogMode(false)
+	if dbCred.GetDriver() != "sqlite3" {
+		// The database is shared by all tests, every test starts from an empty schema
+		dropTestTables(t)
+	}
+	if _, err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Drops the tables of all migrations, the first one can not be rolled back.
+func dropTestTables(t *testing.T) {
+	models := append(baseModels(), &userList120000{}, &viewListClub120500{}, &swipeAction120600{}, &swipeBatch120700{}, &swipeEvent121000{}, &SchemaMigration{})
+	if err := DB.DropTableIfExists(models...).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testDBCredential() config.DBCredential {
+	driver := os.Getenv("TEST_DB_DRIVER")
+	if driver == "" || driver == "sqlite3" {
+		return config.DBCredential{Driver: "sqlite3", DBPath: ":memory:"}
+	}
+	return config.DBCredential{
+		Driver:    driver,
+		DBAddress: os.Getenv("TEST_DB_ADDRESS"),
+		DBPort:    os.Getenv("TEST_DB_PORT"),
+		DBUser:    os.Getenv("TEST_DB_USER"),
+		DBPass:    os.Getenv("TEST_DB_PASS"),
+		DBName:    os.Getenv("TEST_DB_NAME"),
+		SSLMode:   "disable",
+	}
 }
 
 func TestGetAllAccountInfoByCondition(t *testing.T)  {
-	configuration := initTestConfiguration()
-	Init(configuration.DBCredential)
+	initTestDB(t)
+	createTestClub(t, "club-1")
+	account := AdminAccount{AccountID: "manager", ClubID: "club-1", Role: ROLE_CLUB_MANAGER}
+	if err := account.Insert(DB); err != nil {
+		t.Fatal(err)
+	}
 
-	accounts, err := GetAllAccountInfoByCondition(nil)
+	accounts, err := GetAllAccountInfoByCondition(DB, nil)
 
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	_ = accounts
+	if len(accounts) != 1 || accounts[0].AccountID != "manager" {
+		t.Errorf("unexpected accounts %+v", accounts)
+	}
 }
 
 func TestGetClubInfoCountsByCondition(t *testing.T) {
-	configuration := initTestConfiguration()
-	Init(configuration.DBCredential)
+	initTestDB(t)
+	createTestClub(t, "club-1")
+	unpublished := ClubInfo{ClubID: "club-2", Name: "Drama Club"}
+	if err := unpublished.Insert(DB); err != nil {
+		t.Fatal(err)
+	}
+	for _, uid := range []string{"loop-1", "loop-2"} {
+		favourite := UserFavourite{LoopUID: uid, ClubID: "club-1", Favourite: true}
+		if err := favourite.InsertOrUpdate(DB); err != nil {
+			t.Fatal(err)
+		}
+	}
+	viewListLog := ViewListLog{ViewListID: "view-1", LoopUID: "loop-1", ClubID: "club-1"}
+	if err := viewListLog.Insert(DB); err != nil {
+		t.Fatal(err)
+	}
 
 	condition := &ClubInfoCondition{
 		Published:"true",
@@ -42,22 +91,97 @@ func TestGetClubInfoCountsByCondition(t *testing.T) {
 		SortOrder: "DESC",
 	}
 
-	counts, err := GetClubInfoCountsByCondition(condition)
+	counts, err := GetClubInfoCountsByCondition(DB, condition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 1 || counts[0].ClubID != "club-1" || counts[0].FavouriteNum != 2 || counts[0].ViewNum != 1 {
+		t.Errorf("unexpected club counts %+v", counts)
+	}
+
+	totalSize, err := GetClubInfoNumByCondition(DB, condition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totalSize != 1 {
+		t.Errorf("expected 1 published club, got %d", totalSize)
+	}
+
+	condition.Published = "false"
+	counts, err = GetClubInfoCountsByCondition(DB, condition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 1 || counts[0].ClubID != "club-2" || counts[0].FavouriteNum != 0 {
+		t.Errorf("unexpected unpublished club counts %+v", counts)
+	}
+
+	count, err := GetClubInfoCountByClubId(DB, "club-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.FavouriteNum != 2 || count.ViewNum != 1 {
+		t.Errorf("unexpected counts of club-1 %+v", count)
+	}
+}
+
+func TestGetAllPublishedFavouriteClubInfo(t *testing.T) {
+	initTestDB(t)
+	createTestClub(t, "club-1")
+	createTestClub(t, "club-2")
+	unpublished := ClubInfo{ClubID: "club-3", Name: "Drama Club"}
+	if err := unpublished.Insert(DB); err != nil {
+		t.Fatal(err)
+	}
+	favourite := UserFavourite{LoopUID: "loop-1", ClubID: "club-2", Favourite: true}
+	if err := favourite.InsertOrUpdate(DB); err != nil {
+		t.Fatal(err)
+	}
+
+	clubInfos, err := GetAllPublishedFavouriteClubInfo(DB, "loop-1")
 	if err != nil {
 		t.Fatal(err)
 	}
-	_ = counts
+	if len(clubInfos) != 2 {
+		t.Fatalf("expected the 2 published clubs, got %+v", clubInfos)
+	}
+	for _, clubInfo := range clubInfos {
+		if clubInfo.Favourite != (clubInfo.ClubID == "club-2") {
+			t.Errorf("wrong favourite flag for %s", clubInfo.ClubID)
+		}
+	}
 
-	totalSize, err := GetClubInfoNumByCondition(condition)
+	clubInfos, err = GetAllPublishedFavouriteClubInfoByClubIDs(DB, "loop-1", []string{"club-2", "club-3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clubInfos) != 1 || clubInfos[0].ClubID != "club-2" || !clubInfos[0].Favourite {
+		t.Errorf("expected only the published club-2, got %+v", clubInfos)
+	}
+
+	viewListLog := ViewListLog{ViewListID: "view-1", LoopUID: "loop-1", ClubID: "club-1"}
+	if err := viewListLog.Insert(DB); err != nil {
+		t.Fatal(err)
+	}
+	clubInfos, err = GetUnreadPublishedFavouriteClubInfo(DB, "loop-1", "view-1")
 	if err != nil {
 		t.Fatal(err)
 	}
-	log.Println(totalSize)
+	if len(clubInfos) != 1 || clubInfos[0].ClubID != "club-2" {
+		t.Errorf("expected only the unread club-2, got %+v", clubInfos)
+	}
+
+	favourites, err := GetUserFavouritesByUID(DB, "loop-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(favourites) != 1 || favourites[0].ClubID != "club-2" {
+		t.Errorf("unexpected favourites %+v", favourites)
+	}
 }
 
 func TestClubInfo_Update(t *testing.T) {
-	configuration := initTestConfiguration()
-	Init(configuration.DBCredential)
+	initTestDB(t)
 
 	clubInfo := ClubInfo{
 		ClubID: "7661a656-5ba6-4fc4-b43c-cd8b6cc09a6e",
@@ -70,16 +194,73 @@ func TestClubInfo_Update(t *testing.T) {
 	}
 
 	//update club info
-	clubInfo.Pic1ID = "75cd39d5-baa0-40cd-8314-833d784cfc2d.png"
-	err = DB.Model(&clubInfo).Where("club_id = ?", clubInfo.ClubID).Updates(map[string]interface{}{"pic1_id":clubInfo.Pic1ID}).Error
+	clubInfo.LogoID = "75cd39d5-baa0-40cd-8314-833d784cfc2d"
+	err = DB.Model(&clubInfo).Where("club_id = ?", clubInfo.ClubID).Updates(map[string]interface{}{"logo_id":clubInfo.LogoID}).Error
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	//cover club info
-	clubInfo.Pic1ID = ""
-	err = DB.Model(&clubInfo).Where("club_id = ?", clubInfo.ClubID).Updates(map[string]interface{}{"pic1_id":clubInfo.Pic1ID}).Error
+	clubInfo.LogoID = ""
+	err = DB.Model(&clubInfo).Where("club_id = ?", clubInfo.ClubID).Updates(map[string]interface{}{"logo_id":clubInfo.LogoID}).Error
 	if err != nil {
 		t.Fatal(err)
 	}
 }
+
+// Runs on every start, so it has to leave hashed accounts as they are.
+func TestMigrateAuthStrings(t *testing.T) {
+	initTestDB(t)
+	for _, account := range []AdminAccount{
+		{AccountID: "legacy", AuthString: "plaintext"},
+		{AccountID: "hashed", AuthHash: "kept"},
+	} {
+		if err := account.Insert(DB); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hashed := 0
+	hash := func(authString string) string {
+		hashed++
+		return "hash of " + authString
+	}
+
+	for run := 1; run <= 2; run++ {
+		if err := MigrateAuthStrings(DB, hash); err != nil {
+			t.Fatal(err)
+		}
+		if hashed != 1 {
+			t.Errorf("run %d: only the plaintext auth string should be hashed, once, got %d hashes", run, hashed)
+		}
+		for accountID, authHash := range map[string]string{"legacy": "hash of plaintext", "hashed": "kept"} {
+			var account AdminAccount
+			if err := DB.Where("account_id = ?", accountID).First(&account).Error; err != nil {
+				t.Fatal(err)
+			}
+			if account.AuthHash != authHash || account.AuthString != "" {
+				t.Errorf("run %d: %s should have hash %q and no plaintext, got %+v", run, accountID, authHash, account)
+			}
+		}
+	}
+	if account, err := GetAccountByAuthHash(DB, "hash of plaintext"); err != nil || account.AccountID != "legacy" {
+		t.Errorf("the migrated account should be found by its hash, got %+v %v", account, err)
+	}
+}
+
+func TestIsDuplicateKeyError(t *testing.T) {
+	initTestDB(t)
+
+	for i := 0; i < 2; i++ {
+		swipe := SwipeAction{ViewListID: "view", LoopUID: "user", ClubID: "club", Action: SWIPE_LIKE}
+		err := DB.Create(&swipe).Error
+		if i == 0 && err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 && !IsDuplicateKeyError(err) {
+			t.Errorf("second swipe should violate the unique index, got %v", err)
+		}
+	}
+	if IsDuplicateKeyError(DB.Where("club_id = ?", "missing").First(&SwipeAction{}).Error) {
+		t.Error("not found is no duplicate key")
+	}
+}
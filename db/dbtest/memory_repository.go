@@ -0,0 +1,1224 @@
+package dbtest
+
+import (
+	"github.com/jinzhu/gorm"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"tinder-for-clubs-backend/db"
+)
+
+// MemoryRepository keeps all records in memory. It implements every repository with the semantics of the
+// database queries, so handlers can be tested without a database.
+type MemoryRepository struct {
+	mu     sync.Mutex
+	nextID uint
+
+	accounts      []db.AdminAccount
+	memberships   []db.ClubMembership
+	invites       []db.ClubInvite
+	loginHistory  []db.LoginHistory
+	challenges    []db.LoginChallenge
+	clubs         []db.ClubInfo
+	gallery       []db.ClubPicture
+	pictures      []db.AccountPicture
+	tags          []db.ClubTags
+	tagRelations  []db.ClubTagRelationship
+	users         []db.UserList
+	revokedTokens []db.RevokedToken
+	favourites    []db.UserFavourite
+	favouriteLogs []db.UserFavouriteLog
+	viewLists     []db.ViewList
+	viewListLogs  []db.ViewListLog
+	viewListClubs []db.ViewListClub
+	swipes        []db.SwipeAction
+	swipeBatches  []db.SwipeBatch
+	swipeEvents   []db.SwipeEvent
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{}
+}
+
+// Returns the repositories all backed by r.
+func (r *MemoryRepository) Repositories() db.Repositories {
+	return db.Repositories{
+		Accounts:   r,
+		Logins:     r,
+		Clubs:      r,
+		Pictures:   r,
+		Users:      r,
+		Favourites: r,
+		ViewLists:  r,
+	}
+}
+
+// Tags are maintained outside of the server, this adds one for tests.
+func (r *MemoryRepository) InsertClubTag(tag *db.ClubTags) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tag.Model = r.newModel()
+	r.tags = append(r.tags, *tag)
+}
+
+func (r *MemoryRepository) newModel() gorm.Model {
+	r.nextID++
+	now := time.Now()
+	return gorm.Model{ID: r.nextID, CreatedAt: now, UpdatedAt: now}
+}
+
+// Returns the bounds of the page of a result with length records.
+func pageBounds(length int, offset, limit int64) (int, int) {
+	start := int(offset)
+	if start > length {
+		start = length
+	}
+	end := length
+	if limit != 0 && start+int(limit) < end {
+		end = start + int(limit)
+	}
+	return start, end
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MemoryRepository) findAccount(accountID string) int {
+	for i := range r.accounts {
+		if r.accounts[i].AccountID == accountID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *MemoryRepository) findClub(clubID string) int {
+	for i := range r.clubs {
+		if r.clubs[i].ClubID == clubID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *MemoryRepository) findMembership(clubID, accountID string) int {
+	for i := range r.memberships {
+		if r.memberships[i].ClubID == clubID && r.memberships[i].AccountID == accountID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *MemoryRepository) findPicture(pictureID string) int {
+	for i := range r.pictures {
+		if r.pictures[i].PictureID == pictureID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *MemoryRepository) insertAccount(account *db.AdminAccount) error {
+	if r.findAccount(account.AccountID) >= 0 {
+		return db.ErrDuplicateKey
+	}
+	account.Model = r.newModel()
+	r.accounts = append(r.accounts, *account)
+	return nil
+}
+
+func (r *MemoryRepository) insertMembership(membership db.ClubMembership) error {
+	if r.findMembership(membership.ClubID, membership.AccountID) >= 0 {
+		return db.ErrDuplicateKey
+	}
+	membership.Model = r.newModel()
+	r.memberships = append(r.memberships, membership)
+	return nil
+}
+
+func (r *MemoryRepository) GetAccountByUserId(accountID string) (*db.AdminAccount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.findAccount(accountID); i >= 0 {
+		account := r.accounts[i]
+		return &account, nil
+	}
+	return &db.AdminAccount{}, gorm.ErrRecordNotFound
+}
+
+func (r *MemoryRepository) GetAccountByAuthHash(authHash string) (*db.AdminAccount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, account := range r.accounts {
+		if account.AuthHash == authHash {
+			return &account, nil
+		}
+	}
+	return &db.AdminAccount{}, gorm.ErrRecordNotFound
+}
+
+func (r *MemoryRepository) GetAllAccountInfoByCondition(condition *db.AccountInfoCondition) ([]db.AccountInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	accounts := make([]db.AccountInfo, 0, len(r.accounts))
+	for _, account := range r.accounts {
+		info := db.AccountInfo{AdminAccount: account}
+		if i := r.findClub(account.ClubID); i >= 0 {
+			info.ClubName = r.clubs[i].Name
+		}
+		accounts = append(accounts, info)
+	}
+	if condition == nil {
+		return accounts, nil
+	}
+	if condition.SortBy != "" {
+		clubCreatedAt := func(info db.AccountInfo) time.Time {
+			if i := r.findClub(info.ClubID); i >= 0 {
+				return r.clubs[i].CreatedAt
+			}
+			return time.Time{}
+		}
+		desc := strings.EqualFold(condition.SortOrder, "desc")
+		sort.SliceStable(accounts, func(i, j int) bool {
+			if desc {
+				return clubCreatedAt(accounts[i]).After(clubCreatedAt(accounts[j]))
+			}
+			return clubCreatedAt(accounts[i]).Before(clubCreatedAt(accounts[j]))
+		})
+	}
+	start, end := pageBounds(len(accounts), condition.Offset, condition.Limit)
+	return accounts[start:end], nil
+}
+
+func (r *MemoryRepository) GetTotalAccountNum() (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return int64(len(r.accounts)), nil
+}
+
+func (r *MemoryRepository) UpdateAccount(account *db.AdminAccount) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.findAccount(account.AccountID); i >= 0 {
+		r.accounts[i].Email = account.Email
+		r.accounts[i].PhoneNum = account.PhoneNum
+		r.accounts[i].Note = account.Note
+	}
+	return nil
+}
+
+func (r *MemoryRepository) UpdateAccountRole(accountID, role string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.findAccount(accountID); i >= 0 {
+		r.accounts[i].Role = role
+		r.accounts[i].IsAdmin = role != db.ROLE_CLUB_MANAGER
+	}
+	return nil
+}
+
+func (r *MemoryRepository) UpdateAccountSuspended(accountID string, suspended bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.findAccount(accountID); i >= 0 {
+		r.accounts[i].Suspended = suspended
+	}
+	return nil
+}
+
+func (r *MemoryRepository) UpdateAccountAuthHash(accountID, authHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.findAccount(accountID); i >= 0 {
+		r.accounts[i].AuthHash = authHash
+		r.accounts[i].AuthString = ""
+	}
+	return nil
+}
+
+func (r *MemoryRepository) DeleteAccountCascade(accountID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := r.findAccount(accountID)
+	if i < 0 {
+		return gorm.ErrRecordNotFound
+	}
+	account := r.accounts[i]
+	r.accounts = append(r.accounts[:i], r.accounts[i+1:]...)
+	if account.ClubID == "" {
+		return nil
+	}
+
+	role := ""
+	if m := r.findMembership(account.ClubID, accountID); m >= 0 {
+		role = r.memberships[m].Role
+		r.memberships = append(r.memberships[:m], r.memberships[m+1:]...)
+	}
+	r.deleteOpenInvites(account.ClubID, accountID)
+	// Memberships are kept in creation order, so the first one left is the longest standing
+	for m := range r.memberships {
+		if r.memberships[m].ClubID != account.ClubID {
+			continue
+		}
+		if role == db.MEMBER_OWNER {
+			r.memberships[m].Role = db.MEMBER_OWNER
+		}
+		return nil
+	}
+
+	// Last manager gone, the club goes with it
+	if c := r.findClub(account.ClubID); c >= 0 {
+		r.clubs[c].Published = false
+	}
+	r.deleteOpenInvites(account.ClubID, "")
+	for p := range r.pictures {
+		if r.pictures[p].ClubID == account.ClubID {
+			r.pictures[p].Archived = true
+		}
+	}
+	return nil
+}
+
+// Deletes the open invites of the club sent by the account, or all of them when accountID is empty.
+func (r *MemoryRepository) deleteOpenInvites(clubID, accountID string) {
+	kept := r.invites[:0]
+	for _, invite := range r.invites {
+		if invite.ClubID != clubID || invite.AcceptedBy != "" || (accountID != "" && invite.InvitedBy != accountID) {
+			kept = append(kept, invite)
+		}
+	}
+	r.invites = kept
+}
+
+func (r *MemoryRepository) CreateClubAccount(account *db.AdminAccount) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.findAccount(account.AccountID) >= 0 || r.findClub(account.ClubID) >= 0 {
+		return db.ErrDuplicateKey
+	}
+	if err := r.insertAccount(account); err != nil {
+		return err
+	}
+	r.clubs = append(r.clubs, db.ClubInfo{Model: r.newModel(), ClubID: account.ClubID})
+	return r.insertMembership(db.ClubMembership{ClubID: account.ClubID, AccountID: account.AccountID, Role: db.MEMBER_OWNER})
+}
+
+func (r *MemoryRepository) GetClubMembership(clubID, accountID string) (*db.ClubMembership, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.findMembership(clubID, accountID); i >= 0 {
+		membership := r.memberships[i]
+		return &membership, nil
+	}
+	return &db.ClubMembership{}, gorm.ErrRecordNotFound
+}
+
+func (r *MemoryRepository) GetClubManagers(clubID string) ([]db.ClubManager, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	managers := make([]db.ClubManager, 0)
+	for _, membership := range r.memberships {
+		if membership.ClubID != clubID {
+			continue
+		}
+		i := r.findAccount(membership.AccountID)
+		if i < 0 {
+			continue
+		}
+		managers = append(managers, db.ClubManager{
+			AccountID: membership.AccountID,
+			Email:     r.accounts[i].Email,
+			PhoneNum:  r.accounts[i].PhoneNum,
+			Role:      membership.Role,
+			JoinedAt:  membership.CreatedAt,
+		})
+	}
+	return managers, nil
+}
+
+func (r *MemoryRepository) InsertClubInvite(invite *db.ClubInvite) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.invites {
+		if existing.InviteHash == invite.InviteHash {
+			return db.ErrDuplicateKey
+		}
+	}
+	invite.Model = r.newModel()
+	r.invites = append(r.invites, *invite)
+	return nil
+}
+
+func (r *MemoryRepository) GetClubInviteByHash(inviteHash string) (*db.ClubInvite, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, invite := range r.invites {
+		if invite.InviteHash == inviteHash {
+			return &invite, nil
+		}
+	}
+	return &db.ClubInvite{}, gorm.ErrRecordNotFound
+}
+
+func (r *MemoryRepository) AcceptClubInvite(inviteHash string, account *db.AdminAccount, now time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.invites {
+		invite := &r.invites[i]
+		if invite.InviteHash != inviteHash || invite.AcceptedBy != "" || !invite.ExpiresAt.After(now) {
+			continue
+		}
+		if r.findAccount(account.AccountID) >= 0 {
+			return false, db.ErrDuplicateKey
+		}
+		invite.AcceptedBy = account.AccountID
+		if err := r.insertAccount(account); err != nil {
+			return false, err
+		}
+		err := r.insertMembership(db.ClubMembership{ClubID: account.ClubID, AccountID: account.AccountID, Role: db.MEMBER_EDITOR})
+		return err == nil, err
+	}
+	return false, nil
+}
+
+func (r *MemoryRepository) InsertLoginHistory(history *db.LoginHistory) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	history.Model = r.newModel()
+	r.loginHistory = append(r.loginHistory, *history)
+	return nil
+}
+
+// Returns the login history matching condition, latest first.
+func (r *MemoryRepository) filterLoginHistory(condition *db.LoginHistoryCondition) []db.LoginHistory {
+	histories := make([]db.LoginHistory, 0)
+	for i := len(r.loginHistory) - 1; i >= 0; i-- {
+		history := r.loginHistory[i]
+		if condition != nil {
+			if condition.Username != "" && history.Username != condition.Username ||
+				condition.IP != "" && history.IP != condition.IP ||
+				condition.AttemptResult != "" && history.AttemptResult != condition.AttemptResult ||
+				!condition.From.IsZero() && history.CreatedAt.Before(condition.From) ||
+				!condition.To.IsZero() && !history.CreatedAt.Before(condition.To) {
+				continue
+			}
+		}
+		histories = append(histories, history)
+	}
+	return histories
+}
+
+func (r *MemoryRepository) GetLoginHistoryByCondition(condition *db.LoginHistoryCondition) ([]db.LoginHistory, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	histories := r.filterLoginHistory(condition)
+	if condition == nil {
+		return histories, nil
+	}
+	start, end := pageBounds(len(histories), condition.Offset, condition.Limit)
+	return histories[start:end], nil
+}
+
+func (r *MemoryRepository) GetLoginHistoryNumByCondition(condition *db.LoginHistoryCondition) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return int64(len(r.filterLoginHistory(condition))), nil
+}
+
+func (r *MemoryRepository) InsertLoginChallenge(challenge *db.LoginChallenge) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	challenge.Model = r.newModel()
+	r.challenges = append(r.challenges, *challenge)
+	return nil
+}
+
+func (r *MemoryRepository) GetLoginChallengeByID(challengeID string) (*db.LoginChallenge, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, challenge := range r.challenges {
+		if challenge.ChallengeID == challengeID {
+			return &challenge, nil
+		}
+	}
+	return &db.LoginChallenge{}, gorm.ErrRecordNotFound
+}
+
+// Moves an unexpired challenge from status from to status to. Returns nil when no challenge was moved.
+func (r *MemoryRepository) transitionChallenge(challengeID, from, to string, now time.Time) *db.LoginChallenge {
+	for i := range r.challenges {
+		challenge := &r.challenges[i]
+		if challenge.ChallengeID == challengeID && challenge.Status == from && challenge.ExpiresAt.After(now) {
+			challenge.Status = to
+			return challenge
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) ApproveLoginChallenge(challengeID, accountID string, now time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	challenge := r.transitionChallenge(challengeID, db.CHALLENGE_PENDING, db.CHALLENGE_APPROVED, now)
+	if challenge == nil {
+		return false, nil
+	}
+	challenge.ApprovedBy = accountID
+	return true, nil
+}
+
+func (r *MemoryRepository) ConsumeLoginChallenge(challengeID string, now time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.transitionChallenge(challengeID, db.CHALLENGE_APPROVED, db.CHALLENGE_CONSUMED, now) != nil, nil
+}
+
+func (r *MemoryRepository) DeleteExpiredLoginChallenges(now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := r.challenges[:0]
+	for _, challenge := range r.challenges {
+		if !challenge.ExpiresAt.Before(now) {
+			kept = append(kept, challenge)
+		}
+	}
+	r.challenges = kept
+	return nil
+}
+
+func (r *MemoryRepository) GetClubInfoByClubId(clubID string) (*db.ClubInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.findClub(clubID); i >= 0 {
+		club := r.clubs[i]
+		return &club, nil
+	}
+	return &db.ClubInfo{}, gorm.ErrRecordNotFound
+}
+
+func (r *MemoryRepository) clubInfoCount(club db.ClubInfo) db.ClubInfoCount {
+	count := db.ClubInfoCount{ClubInfo: club}
+	for _, favourite := range r.favourites {
+		if favourite.ClubID == club.ClubID {
+			count.FavouriteNum++
+		}
+	}
+	viewers := make(map[string]bool)
+	for _, viewListLog := range r.viewListLogs {
+		if viewListLog.ClubID == club.ClubID {
+			count.ViewNum++
+			viewers[viewListLog.LoopUID] = true
+		}
+	}
+	count.UniqueViewerNum = int64(len(viewers))
+	return count
+}
+
+func (r *MemoryRepository) GetClubInfoCountByClubId(clubID string) (db.ClubInfoCount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.findClub(clubID); i >= 0 {
+		return r.clubInfoCount(r.clubs[i]), nil
+	}
+	return db.ClubInfoCount{}, gorm.ErrRecordNotFound
+}
+
+func (r *MemoryRepository) filterClubs(condition *db.ClubInfoCondition) []db.ClubInfo {
+	clubs := make([]db.ClubInfo, 0)
+	for _, club := range r.clubs {
+		if condition != nil {
+			if condition.Published == "true" && !club.Published || condition.Published == "false" && club.Published {
+				continue
+			}
+		}
+		clubs = append(clubs, club)
+	}
+	return clubs
+}
+
+func (r *MemoryRepository) GetClubInfoCountsByCondition(condition *db.ClubInfoCondition) ([]db.ClubInfoCount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clubs := r.filterClubs(condition)
+	counts := make([]db.ClubInfoCount, 0, len(clubs))
+	for _, club := range clubs {
+		counts = append(counts, r.clubInfoCount(club))
+	}
+	if condition == nil {
+		return counts, nil
+	}
+	if condition.SortBy != "" {
+		desc := strings.EqualFold(condition.SortOrder, "desc")
+		sort.SliceStable(counts, func(i, j int) bool {
+			if desc {
+				return counts[i].CreatedAt.After(counts[j].CreatedAt)
+			}
+			return counts[i].CreatedAt.Before(counts[j].CreatedAt)
+		})
+	}
+	start, end := pageBounds(len(counts), condition.Offset, condition.Limit)
+	return counts[start:end], nil
+}
+
+func (r *MemoryRepository) GetClubInfoNumByCondition(condition *db.ClubInfoCondition) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return int64(len(r.filterClubs(condition))), nil
+}
+
+func (r *MemoryRepository) GetPublishedClubInfosByClubIds(clubIDs []string) ([]db.ClubInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clubs := make([]db.ClubInfo, 0)
+	for _, club := range r.clubs {
+		if club.Published && containsString(clubIDs, club.ClubID) {
+			clubs = append(clubs, club)
+		}
+	}
+	return clubs, nil
+}
+
+func (r *MemoryRepository) UpdateClubPublishedOrNot(clubID string, published bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.findClub(clubID); i >= 0 {
+		r.clubs[i].Published = published
+	}
+	return nil
+}
+
+func (r *MemoryRepository) UpdateClubInfo(clubInfo *db.ClubInfo, tagIDs []string, gallery []db.ClubPicture) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.findClub(clubInfo.ClubID); i >= 0 {
+		club := &r.clubs[i]
+		club.Name = clubInfo.Name
+		club.Website = clubInfo.Website
+		club.Email = clubInfo.Email
+		club.GroupLink = clubInfo.GroupLink
+		club.VideoLink = clubInfo.VideoLink
+		club.Published = clubInfo.Published
+		club.Description = clubInfo.Description
+		club.LogoID = clubInfo.LogoID
+	}
+
+	kept := make([]db.ClubPicture, 0, len(r.gallery))
+	for _, picture := range r.gallery {
+		if picture.ClubID != clubInfo.ClubID {
+			kept = append(kept, picture)
+		}
+	}
+	for i := range gallery {
+		gallery[i].ClubID = clubInfo.ClubID
+		gallery[i].Model = r.newModel()
+		kept = append(kept, gallery[i])
+	}
+	r.gallery = kept
+
+	if len(tagIDs) > 0 {
+		relations := make([]db.ClubTagRelationship, 0, len(r.tagRelations))
+		for _, relation := range r.tagRelations {
+			if relation.ClubID != clubInfo.ClubID {
+				relations = append(relations, relation)
+			}
+		}
+		for _, tagID := range tagIDs {
+			relations = append(relations, db.ClubTagRelationship{Model: r.newModel(), ClubID: clubInfo.ClubID, TagID: tagID})
+		}
+		r.tagRelations = relations
+	}
+	return nil
+}
+
+func (r *MemoryRepository) GetClubGallery(clubID string) ([]db.ClubPicture, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pictures := make([]db.ClubPicture, 0)
+	for _, picture := range r.gallery {
+		if picture.ClubID == clubID {
+			pictures = append(pictures, picture)
+		}
+	}
+	sort.SliceStable(pictures, func(i, j int) bool {
+		return pictures[i].Position < pictures[j].Position
+	})
+	return pictures, nil
+}
+
+func (r *MemoryRepository) ReorderClubGallery(clubID string, pictureIDs []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for idx, pictureID := range pictureIDs {
+		for i := range r.gallery {
+			if r.gallery[i].ClubID == clubID && r.gallery[i].PictureID == pictureID {
+				r.gallery[i].Position = idx + 1
+			}
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) GetAllClubTags() ([]db.ClubTags, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tags := make([]db.ClubTags, len(r.tags))
+	copy(tags, r.tags)
+	return tags, nil
+}
+
+func (r *MemoryRepository) GetClubTagsByTagIds(tagIDs []string) ([]db.ClubTags, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tags := make([]db.ClubTags, 0)
+	for _, tag := range r.tags {
+		if containsString(tagIDs, tag.TagID) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+func (r *MemoryRepository) GetTagRelationshipsByTagIDs(tagIDs []string) ([]db.ClubTagRelationship, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	relations := make([]db.ClubTagRelationship, 0)
+	seen := make(map[[2]string]bool)
+	for _, relation := range r.tagRelations {
+		key := [2]string{relation.ClubID, relation.TagID}
+		if containsString(tagIDs, relation.TagID) && !seen[key] {
+			seen[key] = true
+			relations = append(relations, db.ClubTagRelationship{ClubID: relation.ClubID, TagID: relation.TagID})
+		}
+	}
+	return relations, nil
+}
+
+func (r *MemoryRepository) GetTagRelationshipsByClubID(clubID string) ([]db.ClubTagRelationship, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	relations := make([]db.ClubTagRelationship, 0)
+	for _, relation := range r.tagRelations {
+		if relation.ClubID == clubID {
+			relations = append(relations, relation)
+		}
+	}
+	return relations, nil
+}
+
+func (r *MemoryRepository) GetAllTagRelationships() ([]db.ClubTagRelationship, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	relations := make([]db.ClubTagRelationship, 0, len(r.tagRelations))
+	for _, relation := range r.tagRelations {
+		relations = append(relations, db.ClubTagRelationship{ClubID: relation.ClubID, TagID: relation.TagID})
+	}
+	return relations, nil
+}
+
+func (r *MemoryRepository) InsertPicture(picture *db.AccountPicture) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.findPicture(picture.PictureID) >= 0 {
+		return db.ErrDuplicateKey
+	}
+	picture.Model = r.newModel()
+	r.pictures = append(r.pictures, *picture)
+	return nil
+}
+
+func (r *MemoryRepository) GetPictureById(pictureID string) (*db.AccountPicture, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.findPicture(pictureID); i >= 0 && !r.pictures[i].Archived {
+		picture := r.pictures[i]
+		return &picture, nil
+	}
+	return &db.AccountPicture{}, gorm.ErrRecordNotFound
+}
+
+func (r *MemoryRepository) GetClubPicture(clubID, pictureID string) (*db.AccountPicture, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.findPicture(pictureID); i >= 0 && !r.pictures[i].Archived && r.pictures[i].ClubID == clubID {
+		picture := r.pictures[i]
+		return &picture, nil
+	}
+	return &db.AccountPicture{}, gorm.ErrRecordNotFound
+}
+
+// Returns the pictures of the club that are not archived, latest first.
+func (r *MemoryRepository) clubPictures(clubID string) []db.AccountPicture {
+	pictures := make([]db.AccountPicture, 0)
+	for i := len(r.pictures) - 1; i >= 0; i-- {
+		if r.pictures[i].ClubID == clubID && !r.pictures[i].Archived {
+			pictures = append(pictures, r.pictures[i])
+		}
+	}
+	return pictures
+}
+
+func (r *MemoryRepository) GetClubPictures(clubID string) ([]db.AccountPicture, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.clubPictures(clubID), nil
+}
+
+func (r *MemoryRepository) GetClubPictureIDs(clubID string) ([]db.AccountPicture, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pictures := r.clubPictures(clubID)
+	for i := range pictures {
+		pictures[i] = db.AccountPicture{PictureID: pictures[i].PictureID}
+	}
+	return pictures, nil
+}
+
+func (r *MemoryRepository) UpdatePictureContentHash(pictureID, contentHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.findPicture(pictureID); i >= 0 {
+		r.pictures[i].ContentHash = contentHash
+	}
+	return nil
+}
+
+func (r *MemoryRepository) UpdatePictureText(picture *db.AccountPicture) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.findPicture(picture.PictureID); i >= 0 {
+		r.pictures[i].Caption = picture.Caption
+		r.pictures[i].AltText = picture.AltText
+	}
+	return nil
+}
+
+// Pictures used as logo or in a gallery, by their IDs.
+func (r *MemoryRepository) usedPictureIDs() map[string]bool {
+	used := make(map[string]bool)
+	for _, club := range r.clubs {
+		used[club.LogoID] = true
+	}
+	for _, picture := range r.gallery {
+		used[picture.PictureID] = true
+	}
+	return used
+}
+
+func (r *MemoryRepository) DeleteUnusedPicture(pictureID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := r.findPicture(pictureID)
+	if i < 0 || r.pictures[i].Archived || r.usedPictureIDs()[pictureID] {
+		return false, nil
+	}
+	r.pictures = append(r.pictures[:i], r.pictures[i+1:]...)
+	return true, nil
+}
+
+func (r *MemoryRepository) GetUnusedPicturesCreatedBefore(before time.Time) ([]db.AccountPicture, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	used := r.usedPictureIDs()
+	pictures := make([]db.AccountPicture, 0)
+	for _, picture := range r.pictures {
+		if !picture.Archived && picture.CreatedAt.Before(before) && !used[picture.PictureID] {
+			pictures = append(pictures, picture)
+		}
+	}
+	return pictures, nil
+}
+
+func (r *MemoryRepository) GetAppUserByUid(loopUID string) (*db.UserList, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, user := range r.users {
+		if user.LoopUID == loopUID {
+			return &user, nil
+		}
+	}
+	return &db.UserList{}, gorm.ErrRecordNotFound
+}
+
+func (r *MemoryRepository) InsertAppUser(user *db.UserList) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, registered := range r.users {
+		if registered.LoopUID == user.LoopUID {
+			return db.ErrDuplicateKey
+		}
+	}
+	user.Model = r.newModel()
+	r.users = append(r.users, *user)
+	return nil
+}
+
+func (r *MemoryRepository) UpdateAppUser(user *db.UpdateUser) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, registered := range r.users {
+		if registered.LoopUID == user.LoopUID && user.LoopUID != user.SrcLoopUID {
+			return db.ErrDuplicateKey
+		}
+	}
+	for i := range r.users {
+		if r.users[i].LoopUID == user.SrcLoopUID {
+			r.users[i].LoopUID = user.LoopUID
+			r.users[i].LoopUserName = user.LoopUserName
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) RevokeToken(token *db.RevokedToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, revoked := range r.revokedTokens {
+		if revoked.TokenID == token.TokenID {
+			return db.ErrDuplicateKey
+		}
+	}
+	token.Model = r.newModel()
+	r.revokedTokens = append(r.revokedTokens, *token)
+	return nil
+}
+
+func (r *MemoryRepository) IsTokenRevoked(tokenID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, revoked := range r.revokedTokens {
+		if revoked.TokenID == tokenID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *MemoryRepository) DeleteExpiredRevokedTokens(now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := r.revokedTokens[:0]
+	for _, revoked := range r.revokedTokens {
+		if !revoked.ExpiresAt.Before(now) {
+			kept = append(kept, revoked)
+		}
+	}
+	r.revokedTokens = kept
+	return nil
+}
+
+func (r *MemoryRepository) SetFavourite(loopUID, clubID string, favourite bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.setFavourite(loopUID, clubID, favourite)
+	return nil
+}
+
+// Callers hold r.mu.
+func (r *MemoryRepository) setFavourite(loopUID, clubID string, favourite bool) {
+	found := false
+	for i := range r.favourites {
+		if r.favourites[i].LoopUID == loopUID && r.favourites[i].ClubID == clubID {
+			r.favourites[i].Favourite = favourite
+			found = true
+		}
+	}
+	if !found {
+		r.favourites = append(r.favourites, db.UserFavourite{Model: r.newModel(), LoopUID: loopUID, ClubID: clubID, Favourite: favourite})
+	}
+
+	action := db.UNFAVORITE_ACTION
+	if favourite {
+		action = db.FAVORITE_ACTION
+	}
+	r.favouriteLogs = append(r.favouriteLogs, db.UserFavouriteLog{Model: r.newModel(), LoopUID: loopUID, ClubID: clubID, Action: action})
+}
+
+func (r *MemoryRepository) GetUserFavouritesByUID(loopUID string) ([]db.UserFavourite, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	favourites := make([]db.UserFavourite, 0)
+	for _, favourite := range r.favourites {
+		if favourite.LoopUID == loopUID && favourite.Favourite {
+			favourites = append(favourites, favourite)
+		}
+	}
+	return favourites, nil
+}
+
+func (r *MemoryRepository) GetAllUserFavourites() ([]db.UserFavourite, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	favourites := make([]db.UserFavourite, 0)
+	for _, favourite := range r.favourites {
+		if favourite.Favourite {
+			favourites = append(favourites, db.UserFavourite{LoopUID: favourite.LoopUID, ClubID: favourite.ClubID})
+		}
+	}
+	return favourites, nil
+}
+
+// Returns the published clubs accepted by include, with whether the user favourites them.
+func (r *MemoryRepository) favouriteClubInfos(loopUID string, include func(club db.ClubInfo) bool) []db.FavouriteClubInfo {
+	clubs := make([]db.FavouriteClubInfo, 0)
+	for _, club := range r.clubs {
+		if !club.Published || !include(club) {
+			continue
+		}
+		info := db.FavouriteClubInfo{ClubInfo: club}
+		for _, favourite := range r.favourites {
+			if favourite.LoopUID == loopUID && favourite.ClubID == club.ClubID {
+				info.Favourite = favourite.Favourite
+			}
+		}
+		clubs = append(clubs, info)
+	}
+	return clubs
+}
+
+func (r *MemoryRepository) GetAllPublishedFavouriteClubInfo(loopUID string) ([]db.FavouriteClubInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.favouriteClubInfos(loopUID, func(db.ClubInfo) bool { return true }), nil
+}
+
+func (r *MemoryRepository) GetAllPublishedFavouriteClubInfoByClubIDs(loopUID string, clubIDs []string) ([]db.FavouriteClubInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.favouriteClubInfos(loopUID, func(club db.ClubInfo) bool {
+		return containsString(clubIDs, club.ClubID)
+	}), nil
+}
+
+func (r *MemoryRepository) GetUnreadPublishedFavouriteClubInfo(loopUID, viewListID string) ([]db.FavouriteClubInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	read := make(map[string]bool)
+	for _, viewListLog := range r.viewListLogs {
+		if viewListLog.LoopUID == loopUID && viewListLog.ViewListID == viewListID {
+			read[viewListLog.ClubID] = true
+		}
+	}
+	return r.favouriteClubInfos(loopUID, func(club db.ClubInfo) bool {
+		return !read[club.ClubID]
+	}), nil
+}
+
+func (r *MemoryRepository) GetLatestViewListByUID(loopUID string) (*db.ViewList, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.viewLists) - 1; i >= 0; i-- {
+		if r.viewLists[i].LoopUID == loopUID {
+			viewList := r.viewLists[i]
+			return &viewList, nil
+		}
+	}
+	return &db.ViewList{}, gorm.ErrRecordNotFound
+}
+
+func (r *MemoryRepository) InsertViewList(viewList *db.ViewList) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.viewLists {
+		if existing.LoopUID == viewList.LoopUID && existing.ViewListID == viewList.ViewListID {
+			return db.ErrDuplicateKey
+		}
+	}
+	viewList.Model = r.newModel()
+	r.viewLists = append(r.viewLists, *viewList)
+	return nil
+}
+
+func (r *MemoryRepository) UpsertViewListLog(viewListLog *db.ViewListLog) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.markClubRead(viewListLog)
+	return nil
+}
+
+func (r *MemoryRepository) GetViewListClubs(viewListID string) ([]db.ViewListClub, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clubs := make([]db.ViewListClub, 0)
+	for _, club := range r.viewListClubs {
+		if club.ViewListID == viewListID {
+			clubs = append(clubs, club)
+		}
+	}
+	sort.SliceStable(clubs, func(i, j int) bool {
+		return clubs[i].Position < clubs[j].Position
+	})
+	return clubs, nil
+}
+
+func (r *MemoryRepository) InsertViewListClubs(viewListID string, clubIDs []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, club := range r.viewListClubs {
+		if club.ViewListID == viewListID && containsString(clubIDs, club.ClubID) {
+			return db.ErrDuplicateKey
+		}
+	}
+	for idx, clubID := range clubIDs {
+		r.viewListClubs = append(r.viewListClubs, db.ViewListClub{Model: r.newModel(), ViewListID: viewListID, ClubID: clubID, Position: idx + 1})
+	}
+	for i := range r.viewLists {
+		if r.viewLists[i].ViewListID == viewListID {
+			r.viewLists[i].ClubsOrdered = true
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) GetSwipeAction(viewListID, clubID string) (*db.SwipeAction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, swipe := range r.swipes {
+		if swipe.ViewListID == viewListID && swipe.ClubID == clubID {
+			return &swipe, nil
+		}
+	}
+	return &db.SwipeAction{}, gorm.ErrRecordNotFound
+}
+
+func (r *MemoryRepository) SaveSwipeAction(swipe *db.SwipeAction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.saveSwipeAction(swipe)
+	return nil
+}
+
+// Callers hold r.mu.
+func (r *MemoryRepository) saveSwipeAction(swipe *db.SwipeAction) {
+	found := false
+	for i := range r.swipes {
+		if r.swipes[i].ViewListID == swipe.ViewListID && r.swipes[i].ClubID == swipe.ClubID {
+			found = true
+			unchanged := r.swipes[i].Action == swipe.Action
+			if swipe.SwipedBefore(&r.swipes[i]) || (unchanged && !r.swipes[i].SwipedBefore(swipe)) {
+				*swipe = r.swipes[i]
+				return
+			}
+			r.swipes[i].Action = swipe.Action
+			r.swipes[i].ClientTimestamp = swipe.ClientTimestamp
+			r.swipes[i].UpdatedAt = time.Now()
+			*swipe = r.swipes[i]
+			if unchanged {
+				return
+			}
+		}
+	}
+	if !found {
+		swipe.Model = r.newModel()
+		r.swipes = append(r.swipes, *swipe)
+		r.markClubRead(&db.ViewListLog{ViewListID: swipe.ViewListID, LoopUID: swipe.LoopUID, ClubID: swipe.ClubID})
+	}
+
+	if swipe.Action == db.SWIPE_SKIP {
+		return
+	}
+	favourite := swipe.Action != db.SWIPE_PASS
+	current := false
+	for _, userFavourite := range r.favourites {
+		if userFavourite.LoopUID == swipe.LoopUID && userFavourite.ClubID == swipe.ClubID {
+			current = userFavourite.Favourite
+		}
+	}
+	if current == favourite {
+		return
+	}
+	r.setFavourite(swipe.LoopUID, swipe.ClubID, favourite)
+}
+
+func (r *MemoryRepository) GetPassedClubIDs(loopUID string, since time.Time) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clubIDs := make([]string, 0)
+	for _, swipe := range r.swipes {
+		if swipe.LoopUID == loopUID && swipe.Action == db.SWIPE_PASS && !swipe.UpdatedAt.Before(since) {
+			clubIDs = append(clubIDs, swipe.ClubID)
+		}
+	}
+	return clubIDs, nil
+}
+
+func (r *MemoryRepository) GetSwipeBatch(loopUID, clientEventID string) (*db.SwipeBatch, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, batch := range r.swipeBatches {
+		if batch.LoopUID == loopUID && batch.ClientEventID == clientEventID {
+			return &batch, nil
+		}
+	}
+	return &db.SwipeBatch{}, gorm.ErrRecordNotFound
+}
+
+func (r *MemoryRepository) GetSwipeEventIDs(loopUID string, clientEventIDs []string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make([]string, 0)
+	for _, event := range r.swipeEvents {
+		if event.LoopUID == loopUID && containsString(clientEventIDs, event.ClientEventID) {
+			ids = append(ids, event.ClientEventID)
+		}
+	}
+	return ids, nil
+}
+
+func (r *MemoryRepository) SaveSwipeBatch(batch *db.SwipeBatch, events []db.SwipeEvent, reads []db.ViewListLog, swipes []db.SwipeAction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.swipeBatches {
+		if existing.LoopUID == batch.LoopUID && existing.ClientEventID == batch.ClientEventID {
+			return db.ErrDuplicateKey
+		}
+	}
+	stored := make(map[string]bool)
+	for _, existing := range r.swipeEvents {
+		if existing.LoopUID == batch.LoopUID {
+			stored[existing.ClientEventID] = true
+		}
+	}
+	for _, event := range events {
+		if stored[event.ClientEventID] {
+			return db.ErrDuplicateKey
+		}
+		stored[event.ClientEventID] = true
+	}
+	batch.Model = r.newModel()
+	r.swipeBatches = append(r.swipeBatches, *batch)
+	for i := range events {
+		events[i].Model = r.newModel()
+		r.swipeEvents = append(r.swipeEvents, events[i])
+	}
+
+	for i := range reads {
+		r.markClubRead(&reads[i])
+	}
+	for i := range swipes {
+		r.saveSwipeAction(&swipes[i])
+	}
+	return nil
+}
+
+// Callers hold r.mu.
+func (r *MemoryRepository) markClubRead(viewListLog *db.ViewListLog) {
+	for i := range r.viewListLogs {
+		existing := &r.viewListLogs[i]
+		if existing.ViewListID == viewListLog.ViewListID && existing.LoopUID == viewListLog.LoopUID &&
+			existing.ClubID == viewListLog.ClubID {
+			existing.UpdatedAt = time.Now()
+			*viewListLog = *existing
+			return
+		}
+	}
+	viewListLog.Model = r.newModel()
+	r.viewListLogs = append(r.viewListLogs, *viewListLog)
+}
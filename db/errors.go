@@ -0,0 +1,27 @@
+package db
+
+import (
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Returned by repositories not backed by a database when a unique index would be violated
+var ErrDuplicateKey = errors.New("duplicate key")
+
+// Tells whether err is the violation of a unique index, by any of the supported databases.
+func IsDuplicateKeyError(err error) bool {
+	if err == ErrDuplicateKey {
+		return true
+	}
+	switch e := err.(type) {
+	case *mysql.MySQLError:
+		return e.Number == 1062
+	case *pq.Error:
+		return e.Code == "23505"
+	case sqlite3.Error:
+		return e.ExtendedCode == sqlite3.ErrConstraintUnique || e.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	return false
+}
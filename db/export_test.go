@@ -0,0 +1,4 @@
+package db
+
+// Opens an empty database as the global DB for the tests of package db_test.
+var InitTestDB = initTestDB
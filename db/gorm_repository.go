@@ -0,0 +1,435 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+// Repositories backed by a database connection
+type gormRepository struct {
+	db *gorm.DB
+}
+
+func NewGormRepositories(db *gorm.DB) Repositories {
+	repository := gormRepository{db: db}
+	return Repositories{
+		Accounts:   repository,
+		Logins:     repository,
+		Clubs:      repository,
+		Pictures:   repository,
+		Users:      repository,
+		Favourites: repository,
+		ViewLists:  repository,
+	}
+}
+
+func (r gormRepository) GetAccountByUserId(accountID string) (*AdminAccount, error) {
+	return GetAccountByUserId(r.db, accountID)
+}
+
+func (r gormRepository) GetAccountByAuthHash(authHash string) (*AdminAccount, error) {
+	return GetAccountByAuthHash(r.db, authHash)
+}
+
+func (r gormRepository) GetAllAccountInfoByCondition(condition *AccountInfoCondition) ([]AccountInfo, error) {
+	return GetAllAccountInfoByCondition(r.db, condition)
+}
+
+func (r gormRepository) GetTotalAccountNum() (int64, error) {
+	return GetTotalAccountNum(r.db)
+}
+
+func (r gormRepository) UpdateAccount(account *AdminAccount) error {
+	return account.Update(r.db)
+}
+
+func (r gormRepository) UpdateAccountRole(accountID, role string) error {
+	account := AdminAccount{AccountID: accountID}
+	return account.UpdateRole(r.db, role)
+}
+
+func (r gormRepository) UpdateAccountSuspended(accountID string, suspended bool) error {
+	account := AdminAccount{AccountID: accountID}
+	return account.UpdateSuspended(r.db, suspended)
+}
+
+func (r gormRepository) UpdateAccountAuthHash(accountID, authHash string) error {
+	account := AdminAccount{AccountID: accountID}
+	return account.UpdateAuthHash(r.db, authHash)
+}
+
+func (r gormRepository) DeleteAccountCascade(accountID string) error {
+	return DeleteAccountCascade(r.db, accountID)
+}
+
+func (r gormRepository) CreateClubAccount(account *AdminAccount) error {
+	txDb := r.db.Begin()
+	err := account.Insert(txDb)
+	if err != nil {
+		txDb.Rollback()
+		return err
+	}
+	clubInfo := ClubInfo{ClubID: account.ClubID}
+	err = clubInfo.Insert(txDb)
+	if err != nil {
+		txDb.Rollback()
+		return err
+	}
+	membership := ClubMembership{
+		ClubID:    account.ClubID,
+		AccountID: account.AccountID,
+		Role:      MEMBER_OWNER,
+	}
+	err = membership.Insert(txDb)
+	if err != nil {
+		txDb.Rollback()
+		return err
+	}
+	return txDb.Commit().Error
+}
+
+func (r gormRepository) GetClubMembership(clubID, accountID string) (*ClubMembership, error) {
+	return GetClubMembership(r.db, clubID, accountID)
+}
+
+func (r gormRepository) GetClubManagers(clubID string) ([]ClubManager, error) {
+	return GetClubManagers(r.db, clubID)
+}
+
+func (r gormRepository) InsertClubInvite(invite *ClubInvite) error {
+	return invite.Insert(r.db)
+}
+
+func (r gormRepository) GetClubInviteByHash(inviteHash string) (*ClubInvite, error) {
+	return GetClubInviteByHash(r.db, inviteHash)
+}
+
+func (r gormRepository) AcceptClubInvite(inviteHash string, account *AdminAccount, now time.Time) (bool, error) {
+	// One transaction, so an invite creates one account at most
+	txDb := r.db.Begin()
+	accepted, err := AcceptClubInvite(txDb, inviteHash, account.AccountID, now)
+	if err != nil || !accepted {
+		txDb.Rollback()
+		return false, err
+	}
+	err = account.Insert(txDb)
+	if err != nil {
+		txDb.Rollback()
+		return false, err
+	}
+	membership := ClubMembership{
+		ClubID:    account.ClubID,
+		AccountID: account.AccountID,
+		Role:      MEMBER_EDITOR,
+	}
+	err = membership.Insert(txDb)
+	if err != nil {
+		txDb.Rollback()
+		return false, err
+	}
+	return true, txDb.Commit().Error
+}
+
+func (r gormRepository) InsertLoginHistory(history *LoginHistory) error {
+	return history.Insert(r.db)
+}
+
+func (r gormRepository) GetLoginHistoryByCondition(condition *LoginHistoryCondition) ([]LoginHistory, error) {
+	return GetLoginHistoryByCondition(r.db, condition)
+}
+
+func (r gormRepository) GetLoginHistoryNumByCondition(condition *LoginHistoryCondition) (int64, error) {
+	return GetLoginHistoryNumByCondition(r.db, condition)
+}
+
+func (r gormRepository) InsertLoginChallenge(challenge *LoginChallenge) error {
+	return challenge.Insert(r.db)
+}
+
+func (r gormRepository) GetLoginChallengeByID(challengeID string) (*LoginChallenge, error) {
+	return GetLoginChallengeByID(r.db, challengeID)
+}
+
+func (r gormRepository) ApproveLoginChallenge(challengeID, accountID string, now time.Time) (bool, error) {
+	return ApproveLoginChallenge(r.db, challengeID, accountID, now)
+}
+
+func (r gormRepository) ConsumeLoginChallenge(challengeID string, now time.Time) (bool, error) {
+	return ConsumeLoginChallenge(r.db, challengeID, now)
+}
+
+func (r gormRepository) DeleteExpiredLoginChallenges(now time.Time) error {
+	return DeleteExpiredLoginChallenges(r.db, now)
+}
+
+func (r gormRepository) GetClubInfoByClubId(clubID string) (*ClubInfo, error) {
+	return GetClubInfoByClubId(r.db, clubID)
+}
+
+func (r gormRepository) GetClubInfoCountByClubId(clubID string) (ClubInfoCount, error) {
+	return GetClubInfoCountByClubId(r.db, clubID)
+}
+
+func (r gormRepository) GetClubInfoCountsByCondition(condition *ClubInfoCondition) ([]ClubInfoCount, error) {
+	return GetClubInfoCountsByCondition(r.db, condition)
+}
+
+func (r gormRepository) GetClubInfoNumByCondition(condition *ClubInfoCondition) (int64, error) {
+	return GetClubInfoNumByCondition(r.db, condition)
+}
+
+func (r gormRepository) GetPublishedClubInfosByClubIds(clubIDs []string) ([]ClubInfo, error) {
+	return GetPublishedClubInfosByClubIds(r.db, clubIDs)
+}
+
+func (r gormRepository) UpdateClubPublishedOrNot(clubID string, published bool) error {
+	return UpdateClubPublishedOrNot(r.db, clubID, published)
+}
+
+func (r gormRepository) UpdateClubInfo(clubInfo *ClubInfo, tagIDs []string, gallery []ClubPicture) error {
+	txDb := r.db.Begin()
+	err := clubInfo.Update(txDb)
+	if err != nil {
+		txDb.Rollback()
+		return err
+	}
+
+	err = ReplaceClubGallery(txDb, clubInfo.ClubID, gallery)
+	if err != nil {
+		txDb.Rollback()
+		return err
+	}
+
+	if len(tagIDs) > 0 {
+		// Clean up old associations, then insert latest relationship
+		err := CleanAllTags(txDb, clubInfo.ClubID)
+		if err != nil {
+			txDb.Rollback()
+			return err
+		}
+		for _, tagID := range tagIDs {
+			relationship := ClubTagRelationship{
+				ClubID: clubInfo.ClubID,
+				TagID:  tagID,
+			}
+			err := relationship.Insert(txDb)
+			if err != nil {
+				txDb.Rollback()
+				return err
+			}
+		}
+	}
+	return txDb.Commit().Error
+}
+
+func (r gormRepository) GetClubGallery(clubID string) ([]ClubPicture, error) {
+	return GetClubGallery(r.db, clubID)
+}
+
+func (r gormRepository) ReorderClubGallery(clubID string, pictureIDs []string) error {
+	return ReorderClubGallery(r.db, clubID, pictureIDs)
+}
+
+func (r gormRepository) GetAllClubTags() ([]ClubTags, error) {
+	return GetAllClubTags(r.db)
+}
+
+func (r gormRepository) GetClubTagsByTagIds(tagIDs []string) ([]ClubTags, error) {
+	return GetClubTagsByTagIds(r.db, tagIDs)
+}
+
+func (r gormRepository) GetTagRelationshipsByTagIDs(tagIDs []string) ([]ClubTagRelationship, error) {
+	return GetTagRelationshipsByTagIDs(r.db, tagIDs)
+}
+
+func (r gormRepository) GetTagRelationshipsByClubID(clubID string) ([]ClubTagRelationship, error) {
+	return GetTagRelationshipsByClubID(r.db, clubID)
+}
+
+func (r gormRepository) GetAllTagRelationships() ([]ClubTagRelationship, error) {
+	return GetAllTagRelationships(r.db)
+}
+
+func (r gormRepository) InsertPicture(picture *AccountPicture) error {
+	return picture.Insert(r.db)
+}
+
+func (r gormRepository) GetPictureById(pictureID string) (*AccountPicture, error) {
+	return GetPictureById(r.db, pictureID)
+}
+
+func (r gormRepository) GetClubPicture(clubID, pictureID string) (*AccountPicture, error) {
+	return GetClubPicture(r.db, clubID, pictureID)
+}
+
+func (r gormRepository) GetClubPictures(clubID string) ([]AccountPicture, error) {
+	return GetClubPictures(r.db, clubID)
+}
+
+func (r gormRepository) GetClubPictureIDs(clubID string) ([]AccountPicture, error) {
+	return GetClubPictureIDs(r.db, clubID)
+}
+
+func (r gormRepository) UpdatePictureContentHash(pictureID, contentHash string) error {
+	return UpdatePictureContentHash(r.db, pictureID, contentHash)
+}
+
+func (r gormRepository) UpdatePictureText(picture *AccountPicture) error {
+	return picture.UpdateText(r.db)
+}
+
+func (r gormRepository) DeleteUnusedPicture(pictureID string) (bool, error) {
+	return DeleteUnusedPicture(r.db, pictureID)
+}
+
+func (r gormRepository) GetUnusedPicturesCreatedBefore(before time.Time) ([]AccountPicture, error) {
+	return GetUnusedPicturesCreatedBefore(r.db, before)
+}
+
+func (r gormRepository) GetAppUserByUid(loopUID string) (*UserList, error) {
+	return GetAppUserByUid(r.db, loopUID)
+}
+
+func (r gormRepository) InsertAppUser(user *UserList) error {
+	return user.Insert(r.db)
+}
+
+func (r gormRepository) UpdateAppUser(user *UpdateUser) error {
+	return user.Update(r.db)
+}
+
+func (r gormRepository) RevokeToken(token *RevokedToken) error {
+	return token.Insert(r.db)
+}
+
+func (r gormRepository) IsTokenRevoked(tokenID string) (bool, error) {
+	return IsTokenRevoked(r.db, tokenID)
+}
+
+func (r gormRepository) DeleteExpiredRevokedTokens(now time.Time) error {
+	return DeleteExpiredRevokedTokens(r.db, now)
+}
+
+func (r gormRepository) SetFavourite(loopUID, clubID string, favourite bool) error {
+	txDb := r.db.Begin()
+	err := SetFavourite(txDb, loopUID, clubID, favourite)
+	if err != nil {
+		txDb.Rollback()
+		return err
+	}
+	return txDb.Commit().Error
+}
+
+func (r gormRepository) GetUserFavouritesByUID(loopUID string) ([]UserFavourite, error) {
+	return GetUserFavouritesByUID(r.db, loopUID)
+}
+
+func (r gormRepository) GetAllUserFavourites() ([]UserFavourite, error) {
+	return GetAllUserFavourites(r.db)
+}
+
+func (r gormRepository) GetAllPublishedFavouriteClubInfo(loopUID string) ([]FavouriteClubInfo, error) {
+	return GetAllPublishedFavouriteClubInfo(r.db, loopUID)
+}
+
+func (r gormRepository) GetAllPublishedFavouriteClubInfoByClubIDs(loopUID string, clubIDs []string) ([]FavouriteClubInfo, error) {
+	return GetAllPublishedFavouriteClubInfoByClubIDs(r.db, loopUID, clubIDs)
+}
+
+func (r gormRepository) GetUnreadPublishedFavouriteClubInfo(loopUID, viewListID string) ([]FavouriteClubInfo, error) {
+	return GetUnreadPublishedFavouriteClubInfo(r.db, loopUID, viewListID)
+}
+
+func (r gormRepository) GetLatestViewListByUID(loopUID string) (*ViewList, error) {
+	return GetLatestViewListByUID(r.db, loopUID)
+}
+
+func (r gormRepository) InsertViewList(viewList *ViewList) error {
+	return viewList.Insert(r.db)
+}
+
+func (r gormRepository) UpsertViewListLog(viewListLog *ViewListLog) error {
+	return viewListLog.Upsert(r.db)
+}
+
+func (r gormRepository) GetViewListClubs(viewListID string) ([]ViewListClub, error) {
+	return GetViewListClubs(r.db, viewListID)
+}
+
+func (r gormRepository) InsertViewListClubs(viewListID string, clubIDs []string) error {
+	return InsertViewListClubs(r.db, viewListID, clubIDs)
+}
+
+func (r gormRepository) GetSwipeAction(viewListID, clubID string) (*SwipeAction, error) {
+	return GetSwipeAction(r.db, viewListID, clubID)
+}
+
+func (r gormRepository) SaveSwipeAction(swipe *SwipeAction) error {
+	saved := *swipe
+	err := r.saveSwipeAction(&saved)
+	if IsDuplicateKeyError(err) {
+		// The same club was swiped concurrently for the first time, saving again replaces that swipe
+		saved = *swipe
+		err = r.saveSwipeAction(&saved)
+	}
+	if err != nil {
+		return err
+	}
+	*swipe = saved
+	return nil
+}
+
+func (r gormRepository) saveSwipeAction(swipe *SwipeAction) error {
+	txDb := r.db.Begin()
+	err := SaveSwipeAction(txDb, swipe)
+	if err != nil {
+		txDb.Rollback()
+		return err
+	}
+	return txDb.Commit().Error
+}
+
+func (r gormRepository) GetPassedClubIDs(loopUID string, since time.Time) ([]string, error) {
+	return GetPassedClubIDs(r.db, loopUID, since)
+}
+
+func (r gormRepository) GetSwipeBatch(loopUID, clientEventID string) (*SwipeBatch, error) {
+	return GetSwipeBatch(r.db, loopUID, clientEventID)
+}
+
+func (r gormRepository) GetSwipeEventIDs(loopUID string, clientEventIDs []string) ([]string, error) {
+	return GetSwipeEventIDs(r.db, loopUID, clientEventIDs)
+}
+
+func (r gormRepository) SaveSwipeBatch(batch *SwipeBatch, events []SwipeEvent, reads []ViewListLog, swipes []SwipeAction) error {
+	// The batch and its events are inserted first, so a concurrent replay fails on their unique indexes before
+	// writing anything
+	txDb := r.db.Begin()
+	err := batch.Insert(txDb)
+	if err != nil {
+		txDb.Rollback()
+		return err
+	}
+	for i := range events {
+		err = events[i].Insert(txDb)
+		if err != nil {
+			txDb.Rollback()
+			return err
+		}
+	}
+	for i := range reads {
+		err = reads[i].Upsert(txDb)
+		if err != nil {
+			txDb.Rollback()
+			return err
+		}
+	}
+	for i := range swipes {
+		err = SaveSwipeAction(txDb, &swipes[i])
+		if err != nil {
+			txDb.Rollback()
+			return err
+		}
+	}
+	return txDb.Commit().Error
+}
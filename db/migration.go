@@ -0,0 +1,230 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Layout of migration versions, the time the migration was created
+const MIGRATION_VERSION_LAYOUT = "20060102150405"
+
+var ErrIrreversibleMigration = errors.New("migration can not be rolled back")
+
+// Migration is one versioned step of the database schema.
+// Up and Down run in a transaction together with recording the version. MySQL commits schema changes
+// implicitly, so a failing migration may be left half applied there.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(txDb *gorm.DB) error
+	// Nil when the migration can not be rolled back
+	Down func(txDb *gorm.DB) error
+}
+
+// Versions of the applied migrations
+type SchemaMigration struct {
+	Version   int64  `gorm:"primary_key;auto_increment:false"`
+	Name      string `gorm:"type:varchar(100)"`
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// State of a migration, AppliedAt is nil when it is pending.
+// Migrations applied by a newer version of the server are listed with an empty name.
+type MigrationState struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+var migrations []Migration
+
+// Adds a migration, called from the init function of the file defining it.
+func registerMigration(migration Migration) {
+	migrations = append(migrations, migration)
+}
+
+// Returns the known migrations ordered by version.
+func sortedMigrations() []Migration {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return sorted
+}
+
+func getAppliedMigrations() (map[int64]SchemaMigration, error) {
+	if err := DB.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+		return nil, err
+	}
+	applied := make([]SchemaMigration, 0)
+	if err := DB.Order("version").Find(&applied).Error; err != nil {
+		return nil, err
+	}
+	appliedByVersion := make(map[int64]SchemaMigration)
+	for _, migration := range applied {
+		appliedByVersion[migration.Version] = migration
+	}
+	return appliedByVersion, nil
+}
+
+// Returns the migrations not applied yet, ordered by version.
+func GetPendingMigrations() ([]Migration, error) {
+	applied, err := getAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]Migration, 0)
+	for _, migration := range sortedMigrations() {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Returns the state of every known and applied migration, ordered by version.
+func GetMigrationStates() ([]MigrationState, error) {
+	applied, err := getAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	states := make([]MigrationState, 0)
+	for _, migration := range sortedMigrations() {
+		state := MigrationState{Version: migration.Version, Name: migration.Name}
+		if appliedMigration, ok := applied[migration.Version]; ok {
+			appliedAt := appliedMigration.AppliedAt
+			state.AppliedAt = &appliedAt
+			delete(applied, migration.Version)
+		}
+		states = append(states, state)
+	}
+	for _, appliedMigration := range applied {
+		appliedAt := appliedMigration.AppliedAt
+		states = append(states, MigrationState{Version: appliedMigration.Version, AppliedAt: &appliedAt})
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Version < states[j].Version
+	})
+	return states, nil
+}
+
+// Applies all pending migrations in order and returns how many were applied.
+func MigrateUp() (int, error) {
+	pending, err := GetPendingMigrations()
+	if err != nil {
+		return 0, err
+	}
+	for idx, migration := range pending {
+		txDb := DB.Begin()
+		err := migration.Up(txDb)
+		if err == nil {
+			err = txDb.Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		}
+		if err != nil {
+			txDb.Rollback()
+			return idx, fmt.Errorf("migration %d %s failed: %v", migration.Version, migration.Name, err)
+		}
+		if err := txDb.Commit().Error; err != nil {
+			return idx, err
+		}
+		log.Printf("Applied migration %d %s", migration.Version, migration.Name)
+	}
+	return len(pending), nil
+}
+
+// Rolls back the last steps applied migrations, newest first, and returns how many were rolled back.
+func MigrateDown(steps int) (int, error) {
+	applied, err := getAppliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+	known := make(map[int64]Migration)
+	for _, migration := range migrations {
+		known[migration.Version] = migration
+	}
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] > versions[j]
+	})
+
+	for idx, version := range versions {
+		if idx == steps {
+			return idx, nil
+		}
+		migration, ok := known[version]
+		if !ok {
+			return idx, fmt.Errorf("migration %d is unknown to this version", version)
+		}
+		if migration.Down == nil {
+			return idx, fmt.Errorf("migration %d %s: %v", migration.Version, migration.Name, ErrIrreversibleMigration)
+		}
+		txDb := DB.Begin()
+		err := migration.Down(txDb)
+		if err == nil {
+			err = txDb.Delete(&SchemaMigration{Version: migration.Version}).Error
+		}
+		if err != nil {
+			txDb.Rollback()
+			return idx, fmt.Errorf("rolling back migration %d %s failed: %v", migration.Version, migration.Name, err)
+		}
+		if err := txDb.Commit().Error; err != nil {
+			return idx, err
+		}
+		log.Printf("Rolled back migration %d %s", migration.Version, migration.Name)
+	}
+	return len(versions), nil
+}
+
+var migrationNameRegexp = regexp.MustCompile(`[^a-z0-9]+`)
+
+const migrationTemplate = `package db
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	registerMigration(Migration{
+		Version: %s,
+		Name:    %q,
+		Up: func(txDb *gorm.DB) error {
+			return nil
+		},
+		Down: func(txDb *gorm.DB) error {
+			return nil
+		},
+	})
+}
+`
+
+// Writes an empty migration named after name to dir and returns the path of the file.
+func CreateMigration(dir string, name string, now time.Time) (string, error) {
+	name = strings.Trim(migrationNameRegexp.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return "", errors.New("migration name is empty")
+	}
+	version := now.UTC().Format(MIGRATION_VERSION_LAYOUT)
+	path := filepath.Join(dir, "migration_"+version+"_"+name+".go")
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("%s already exists", path)
+	}
+	content := fmt.Sprintf(migrationTemplate, version, name)
+	return path, ioutil.WriteFile(path, []byte(content), 0644)
+}
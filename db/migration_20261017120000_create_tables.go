@@ -0,0 +1,221 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+// Tables as they were when versioned migrations were introduced. Databases from before that were created by
+// AutoMigrate at startup and have some of them, creating them again adds the missing tables, columns and indexes.
+// Rolling this migration back would drop all data, so it can not be.
+func init() {
+	registerMigration(Migration{
+		Version: 20261017120000,
+		Name:    "create_tables",
+		Up: func(txDb *gorm.DB) error {
+			for _, model := range baseModels() {
+				if err := txDb.AutoMigrate(model).Error; err != nil {
+					return err
+				}
+			}
+			// User names may contain emoji
+			if txDb.Dialect().GetName() == "mysql" {
+				return txDb.Set("gorm:table_options", "CHARSET=utf8mb4").AutoMigrate(&userList120000{}).Error
+			}
+			return txDb.AutoMigrate(&userList120000{}).Error
+		},
+	})
+}
+
+// The models are copied as they were at that time, so changing a model later does not change what this
+// migration creates. They already have the tables and columns added before migrations existed, such as sessions,
+// memberships, roles and picture metadata, so the migrations following this one only move data of older databases
+// into them. Later changes of the schema are made by migrations of their own.
+func baseModels() []interface{} {
+	return []interface{}{
+		&adminAccount120000{}, &loginHistory120000{}, &adminSession120000{}, &loginChallenge120000{},
+		&clubInfo120000{}, &clubPicture120000{}, &clubMembership120000{}, &clubInvite120000{},
+		&revokedToken120000{}, &clubTags120000{}, &clubTagRelationship120000{}, &accountPicture120000{},
+		&userFavourite120000{}, &userFavouriteLog120000{}, &viewList120000{}, &viewListLog120000{},
+	}
+}
+
+type adminAccount120000 struct {
+	gorm.Model
+	AccountID  string `gorm:"type:varchar(40);unique_index"`
+	AuthString string `gorm:"type:varchar(256);"`
+	AuthHash   string `gorm:"type:varchar(64);index"`
+	ClubID     string `gorm:"type:varchar(40);"`
+	Email      string `gorm:"type:varchar(100);"`
+	PhoneNum   string `gorm:"type:varchar(20);"`
+	Note       string `gorm:"type:varchar(200);"`
+	IsAdmin    bool
+	Role       string `gorm:"type:varchar(20);"`
+	Suspended  bool
+}
+
+func (adminAccount120000) TableName() string { return "admin_account" }
+
+type loginHistory120000 struct {
+	gorm.Model
+	Username      string `gorm:"not null;index:username"`
+	IP            string `gorm:"index"`
+	AttemptResult string `gorm:"not null;"`
+	HeaderDump    string `gorm:"type:varchar(1000);"`
+}
+
+func (loginHistory120000) TableName() string { return "login_history" }
+
+type adminSession120000 struct {
+	gorm.Model
+	SessionID    string    `gorm:"type:varchar(64);unique_index"`
+	AccountID    string    `gorm:"type:varchar(40);index"`
+	Data         []byte    `gorm:"size:65535"`
+	ExpiresAt    time.Time `gorm:"index"`
+	LastActiveAt time.Time
+}
+
+func (adminSession120000) TableName() string { return "admin_session" }
+
+type loginChallenge120000 struct {
+	gorm.Model
+	ChallengeID string    `gorm:"type:varchar(40);unique_index"`
+	PollHash    string    `gorm:"type:varchar(64)"`
+	Status      string    `gorm:"type:varchar(20)"`
+	ApprovedBy  string    `gorm:"type:varchar(40)"`
+	ExpiresAt   time.Time `gorm:"index"`
+}
+
+func (loginChallenge120000) TableName() string { return "login_challenge" }
+
+type clubInfo120000 struct {
+	gorm.Model
+	ClubID      string `gorm:"type:varchar(40);unique_index"`
+	Name        string `gorm:"not null;type:varchar(1000);"`
+	Website     string `gorm:"type:varchar(500);"`
+	Email       string `gorm:"type:varchar(500);"`
+	GroupLink   string `gorm:"type:varchar(500);"`
+	VideoLink   string `gorm:"type:varchar(500);"`
+	Published   bool   `gorm:"index"`
+	Description string `gorm:"type:varchar(4000);"`
+	LogoID      string `gorm:"type:varchar(40)"`
+}
+
+func (clubInfo120000) TableName() string { return "club_info" }
+
+type clubPicture120000 struct {
+	gorm.Model
+	ClubID    string `gorm:"type:varchar(40);unique_index:uni_club_picture"`
+	PictureID string `gorm:"type:varchar(40);unique_index:uni_club_picture;index"`
+	Position  int
+	Caption   string `gorm:"type:varchar(200)"`
+	Cover     bool
+}
+
+func (clubPicture120000) TableName() string { return "club_picture" }
+
+type clubMembership120000 struct {
+	gorm.Model
+	ClubID    string `gorm:"type:varchar(40);unique_index:uni_member"`
+	AccountID string `gorm:"type:varchar(40);unique_index:uni_member"`
+	Role      string `gorm:"type:varchar(20)"`
+}
+
+func (clubMembership120000) TableName() string { return "club_membership" }
+
+type clubInvite120000 struct {
+	gorm.Model
+	InviteHash string `gorm:"type:varchar(64);unique_index"`
+	ClubID     string `gorm:"type:varchar(40);index"`
+	Email      string `gorm:"type:varchar(100)"`
+	InvitedBy  string `gorm:"type:varchar(40)"`
+	ExpiresAt  time.Time
+	AcceptedBy string `gorm:"type:varchar(40)"`
+}
+
+func (clubInvite120000) TableName() string { return "club_invite" }
+
+type revokedToken120000 struct {
+	gorm.Model
+	TokenID   string    `gorm:"type:varchar(40);unique_index"`
+	LoopUID   string    `gorm:"type:varchar(70);index"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+func (revokedToken120000) TableName() string { return "revoked_token" }
+
+type clubTags120000 struct {
+	gorm.Model
+	TagID string `gorm:"type:varchar(40);unique_index:uni_tag"`
+	Tag   string `gorm:"type:varchar(40);unique_index:uni_tag"`
+}
+
+func (clubTags120000) TableName() string { return "club_tags" }
+
+type clubTagRelationship120000 struct {
+	gorm.Model
+	ClubID string `gorm:"type:varchar(40);index"`
+	TagID  string `gorm:"type:varchar(40);index"`
+}
+
+func (clubTagRelationship120000) TableName() string { return "club_tag_relationship" }
+
+type accountPicture120000 struct {
+	gorm.Model
+	AccountID   string `gorm:"type:varchar(40);index"`
+	ClubID      string `gorm:"type:varchar(40);index"`
+	PictureID   string `gorm:"type:varchar(40);unique_index"`
+	PictureName string `gorm:"type:varchar(60)"`
+	MimeType    string `gorm:"type:varchar(30)"`
+	ContentHash string `gorm:"type:varchar(64)"`
+	Caption     string `gorm:"type:varchar(200)"`
+	AltText     string `gorm:"type:varchar(300)"`
+	Archived    bool
+}
+
+func (accountPicture120000) TableName() string { return "account_picture" }
+
+type userFavourite120000 struct {
+	gorm.Model
+	LoopUID   string `gorm:"type:varchar(70);index"`
+	ClubID    string `gorm:"type:varchar(40);index"`
+	Favourite bool
+}
+
+func (userFavourite120000) TableName() string { return "user_favourite" }
+
+type userFavouriteLog120000 struct {
+	gorm.Model
+	LoopUID string `gorm:"type:varchar(70);index"`
+	ClubID  string `gorm:"type:varchar(40);index"`
+	Action  string `gorm:"type:varchar(20)"`
+}
+
+func (userFavouriteLog120000) TableName() string { return "user_favourite_log" }
+
+type viewList120000 struct {
+	gorm.Model
+	LoopUID    string `gorm:"type:varchar(70);unique_index:uni_view"`
+	ViewListID string `gorm:"type:varchar(40);unique_index:uni_view"`
+}
+
+func (viewList120000) TableName() string { return "view_list" }
+
+// Clubs read several times in a view list were logged every time
+type viewListLog120000 struct {
+	gorm.Model
+	ViewListID string `gorm:"type:varchar(40);index"`
+	LoopUID    string `gorm:"type:varchar(70);index"`
+	ClubID     string `gorm:"type:varchar(40);index"`
+}
+
+func (viewListLog120000) TableName() string { return "view_list_log" }
+
+type userList120000 struct {
+	gorm.Model
+	LoopUID      string `gorm:"type:varchar(70);index"`
+	LoopUserName string `gorm:"type:varchar(50)"`
+	JoinTime     time.Time
+}
+
+func (userList120000) TableName() string { return "user_list" }
@@ -0,0 +1,27 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Gives accounts created before roles existed a role matching their admin flag.
+func init() {
+	registerMigration(Migration{
+		Version: 20261017120100,
+		Name:    "account_roles",
+		Up: func(txDb *gorm.DB) error {
+			err := txDb.Table("admin_account").Where("(role = '' OR role IS NULL) AND is_admin = ?", true).
+				Update("role", ROLE_SUPER_ADMIN).Error
+			if err != nil {
+				return err
+			}
+			err = txDb.Table("admin_account").Where("(role = '' OR role IS NULL) AND is_admin = ?", false).
+				Update("role", ROLE_CLUB_MANAGER).Error
+			return err
+		},
+		// Roles are kept, is_admin still matches them
+		Down: func(txDb *gorm.DB) error {
+			return nil
+		},
+	})
+}
@@ -0,0 +1,38 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Makes club accounts created before clubs could have several managers the owner of their club.
+func init() {
+	registerMigration(Migration{
+		Version: 20261017120200,
+		Name:    "club_memberships",
+		Up: func(txDb *gorm.DB) error {
+			accounts := make([]adminAccount120000, 0)
+			err := txDb.Where("club_id <> '' AND account_id NOT IN (SELECT account_id FROM club_membership)").
+				Find(&accounts).Error
+			if err != nil {
+				return err
+			}
+
+			for _, account := range accounts {
+				membership := clubMembership120000{
+					ClubID:    account.ClubID,
+					AccountID: account.AccountID,
+					Role:      MEMBER_OWNER,
+				}
+				err := txDb.Create(&membership).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		// Memberships are kept, the club of an account is still stored with the account
+		Down: func(txDb *gorm.DB) error {
+			return nil
+		},
+	})
+}
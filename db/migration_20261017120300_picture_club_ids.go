@@ -0,0 +1,22 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Sets the club of pictures uploaded before pictures belonged to clubs.
+func init() {
+	registerMigration(Migration{
+		Version: 20261017120300,
+		Name:    "picture_club_ids",
+		Up: func(txDb *gorm.DB) error {
+			err := txDb.Exec("UPDATE account_picture SET club_id = " +
+				"(SELECT a.club_id FROM admin_account a WHERE a.account_id = account_picture.account_id) " +
+				"WHERE club_id IS NULL OR club_id = ''").Error
+			return err
+		},
+		Down: func(txDb *gorm.DB) error {
+			return nil
+		},
+	})
+}
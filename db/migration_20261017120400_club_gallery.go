@@ -0,0 +1,130 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+	"strings"
+)
+
+// Legacy gallery columns of club_info, replaced by club_picture
+var legacyGalleryColumns = []string{"pic1_id", "pic2_id", "pic3_id", "pic4_id", "pic5_id", "pic6_id"}
+
+// Moves club galleries from the legacy pic1_id..pic6_id columns of club_info to club_picture.
+func init() {
+	registerMigration(Migration{
+		Version: 20261017120400,
+		Name:    "club_gallery",
+		Up: func(txDb *gorm.DB) error {
+			if err := migrateClubGallery(txDb); err != nil {
+				return err
+			}
+			// SQLite before 3.35 can not drop columns, the emptied columns are left there
+			if txDb.Dialect().GetName() == "sqlite3" {
+				return nil
+			}
+			for _, column := range legacyGalleryColumns {
+				if txDb.Dialect().HasColumn("club_info", column) {
+					if err := txDb.Table("club_info").DropColumn(column).Error; err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+		Down: restoreClubGallery,
+	})
+}
+
+// Copies club_picture to the legacy gallery columns, keeping their order. The first pictures fill
+// the columns, the rest is dropped.
+func restoreClubGallery(txDb *gorm.DB) error {
+	for _, column := range legacyGalleryColumns {
+		if !txDb.Dialect().HasColumn("club_info", column) {
+			err := txDb.Exec("ALTER TABLE club_info ADD COLUMN " + column + " varchar(500) DEFAULT ''").Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	clubPictures := make([]clubPicture120000, 0)
+	if err := txDb.Order("club_id, position").Find(&clubPictures).Error; err != nil {
+		return err
+	}
+	galleries := make(map[string]map[string]interface{})
+	for _, clubPicture := range clubPictures {
+		columns, ok := galleries[clubPicture.ClubID]
+		if !ok {
+			columns = make(map[string]interface{})
+			galleries[clubPicture.ClubID] = columns
+		}
+		if len(columns) < len(legacyGalleryColumns) {
+			columns[legacyGalleryColumns[len(columns)]] = clubPicture.PictureID
+		}
+	}
+	for clubID, columns := range galleries {
+		if err := txDb.Table("club_info").Where("club_id = ?", clubID).Updates(columns).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Moves gallery pictures from the legacy gallery columns of club_info to club_picture, keeping their order.
+// The first picture becomes the cover. Migrated columns are emptied, so running it again does nothing.
+func migrateClubGallery(txDb *gorm.DB) error {
+	for _, column := range legacyGalleryColumns {
+		if !txDb.Dialect().HasColumn("club_info", column) {
+			return nil
+		}
+	}
+
+	rows, err := txDb.Table("club_info").Select("club_id, " + strings.Join(legacyGalleryColumns, ", ")).
+		Where(strings.Join(legacyGalleryColumns, " <> '' OR ") + " <> ''").Rows()
+	if err != nil {
+		return err
+	}
+	galleries := make(map[string][]clubPicture120000)
+	for rows.Next() {
+		var clubID string
+		var pictureIDs [6]*string
+		if err := rows.Scan(&clubID, &pictureIDs[0], &pictureIDs[1], &pictureIDs[2], &pictureIDs[3], &pictureIDs[4], &pictureIDs[5]); err != nil {
+			rows.Close()
+			return err
+		}
+		// Empty slots in between are skipped instead of ending the gallery. Picture IDs were not checked for
+		// duplicates, a picture in several slots is kept at the first.
+		gallery := make([]clubPicture120000, 0)
+		added := make(map[string]bool)
+		for _, pictureID := range pictureIDs {
+			if pictureID != nil && *pictureID != "" && !added[*pictureID] {
+				added[*pictureID] = true
+				gallery = append(gallery, clubPicture120000{PictureID: *pictureID, Position: len(gallery) + 1, Cover: len(gallery) == 0})
+			}
+		}
+		galleries[clubID] = gallery
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	cleared := make(map[string]interface{})
+	for _, column := range legacyGalleryColumns {
+		cleared[column] = ""
+	}
+	for clubID, gallery := range galleries {
+		if err := txDb.Unscoped().Where("club_id = ?", clubID).Delete(&clubPicture120000{}).Error; err != nil {
+			return err
+		}
+		for i := range gallery {
+			gallery[i].ClubID = clubID
+			if err := txDb.Create(&gallery[i]).Error; err != nil {
+				return err
+			}
+		}
+		if err := txDb.Table("club_info").Where("club_id = ?", clubID).Updates(cleared).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
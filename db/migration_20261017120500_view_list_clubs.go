@@ -0,0 +1,28 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Keeps the order of the clubs of a view list, so its pages do not overlap.
+func init() {
+	registerMigration(Migration{
+		Version: 20261017120500,
+		Name:    "view_list_clubs",
+		Up: func(txDb *gorm.DB) error {
+			return txDb.AutoMigrate(&viewListClub120500{}).Error
+		},
+		Down: func(txDb *gorm.DB) error {
+			return txDb.DropTableIfExists(&viewListClub120500{}).Error
+		},
+	})
+}
+
+type viewListClub120500 struct {
+	gorm.Model
+	ViewListID string `gorm:"type:varchar(40);unique_index:uni_view_list_club"`
+	ClubID     string `gorm:"type:varchar(40);unique_index:uni_view_list_club"`
+	Position   int
+}
+
+func (viewListClub120500) TableName() string { return "view_list_club" }
@@ -0,0 +1,29 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Records like, superlike, pass and skip swipes per view list.
+func init() {
+	registerMigration(Migration{
+		Version: 20261017120600,
+		Name:    "swipe_actions",
+		Up: func(txDb *gorm.DB) error {
+			return txDb.AutoMigrate(&swipeAction120600{}).Error
+		},
+		Down: func(txDb *gorm.DB) error {
+			return txDb.DropTableIfExists(&swipeAction120600{}).Error
+		},
+	})
+}
+
+type swipeAction120600 struct {
+	gorm.Model
+	ViewListID string `gorm:"type:varchar(40);unique_index:uni_swipe_action"`
+	LoopUID    string `gorm:"type:varchar(70);index"`
+	ClubID     string `gorm:"type:varchar(40);unique_index:uni_swipe_action"`
+	Action     string `gorm:"type:varchar(20)"`
+}
+
+func (swipeAction120600) TableName() string { return "swipe_action" }
@@ -0,0 +1,28 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Remembers batches of view list events by their client event ID, so replays are not applied twice.
+func init() {
+	registerMigration(Migration{
+		Version: 20261017120700,
+		Name:    "swipe_batches",
+		Up: func(txDb *gorm.DB) error {
+			return txDb.AutoMigrate(&swipeBatch120700{}).Error
+		},
+		Down: func(txDb *gorm.DB) error {
+			return txDb.DropTableIfExists(&swipeBatch120700{}).Error
+		},
+	})
+}
+
+type swipeBatch120700 struct {
+	gorm.Model
+	LoopUID       string `gorm:"type:varchar(70);unique_index:uni_swipe_batch"`
+	ClientEventID string `gorm:"type:varchar(64);unique_index:uni_swipe_batch"`
+	Results       string `gorm:"type:text"`
+}
+
+func (swipeBatch120700) TableName() string { return "swipe_batch" }
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Collapses the logs of clubs read several times in a view list into the first one, then makes them unique.
+func init() {
+	registerMigration(Migration{
+		Version: 20261017120800,
+		Name:    "unique_view_list_logs",
+		Up: func(txDb *gorm.DB) error {
+			// MySQL can not select from the table it deletes from, unless the select is materialized
+			err := txDb.Exec("DELETE FROM view_list_log WHERE id NOT IN " +
+				"(SELECT id FROM (SELECT MIN(id) AS id FROM view_list_log GROUP BY view_list_id, loop_uid, club_id) kept)").Error
+			if err != nil {
+				return err
+			}
+			return txDb.AutoMigrate(&viewListLog120800{}).Error
+		},
+		// Collapsed duplicates are not restored
+		Down: func(txDb *gorm.DB) error {
+			return txDb.Model(&viewListLog120800{}).RemoveIndex("uni_view_list_log").Error
+		},
+	})
+}
+
+type viewListLog120800 struct {
+	gorm.Model
+	ViewListID string `gorm:"type:varchar(40);index;unique_index:uni_view_list_log"`
+	LoopUID    string `gorm:"type:varchar(70);index;unique_index:uni_view_list_log"`
+	ClubID     string `gorm:"type:varchar(40);index;unique_index:uni_view_list_log"`
+}
+
+func (viewListLog120800) TableName() string { return "view_list_log" }
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Marks the view lists whose clubs are ordered, so view lists without clubs are not ranked on every request.
+func init() {
+	registerMigration(Migration{
+		Version: 20261017120900,
+		Name:    "view_list_clubs_ordered",
+		Up: func(txDb *gorm.DB) error {
+			err := txDb.AutoMigrate(&viewList120900{}).Error
+			if err != nil {
+				return err
+			}
+			err = txDb.Exec("UPDATE view_list SET clubs_ordered = ? WHERE view_list_id IN "+
+				"(SELECT view_list_id FROM view_list_club)", true).Error
+			return err
+		},
+		// The column is kept, older versions ignore it
+		Down: func(txDb *gorm.DB) error {
+			return nil
+		},
+	})
+}
+
+type viewList120900 struct {
+	gorm.Model
+	LoopUID      string `gorm:"type:varchar(70);unique_index:uni_view"`
+	ViewListID   string `gorm:"type:varchar(40);unique_index:uni_view"`
+	ClubsOrdered bool
+}
+
+func (viewList120900) TableName() string { return "view_list" }
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+// Remembers the events of batches by their client event ID and keeps when swipes were made, so events sent again
+// are not applied twice and late swipes do not replace later ones.
+func init() {
+	registerMigration(Migration{
+		Version: 20261017121000,
+		Name:    "swipe_events",
+		Up: func(txDb *gorm.DB) error {
+			err := txDb.AutoMigrate(&swipeAction121000{}).Error
+			if err != nil {
+				return err
+			}
+			return txDb.AutoMigrate(&swipeEvent121000{}).Error
+		},
+		// The client timestamps of swipes are kept, older versions ignore them
+		Down: func(txDb *gorm.DB) error {
+			return txDb.DropTableIfExists(&swipeEvent121000{}).Error
+		},
+	})
+}
+
+type swipeAction121000 struct {
+	gorm.Model
+	ViewListID      string `gorm:"type:varchar(40);unique_index:uni_swipe_action"`
+	LoopUID         string `gorm:"type:varchar(70);index"`
+	ClubID          string `gorm:"type:varchar(40);unique_index:uni_swipe_action"`
+	Action          string `gorm:"type:varchar(20)"`
+	ClientTimestamp *time.Time
+}
+
+func (swipeAction121000) TableName() string { return "swipe_action" }
+
+type swipeEvent121000 struct {
+	gorm.Model
+	LoopUID         string `gorm:"type:varchar(70);unique_index:uni_swipe_event"`
+	ClientEventID   string `gorm:"type:varchar(64);unique_index:uni_swipe_event"`
+	ViewListID      string `gorm:"type:varchar(40)"`
+	ClubID          string `gorm:"type:varchar(40)"`
+	Action          string `gorm:"type:varchar(20)"`
+	ClientTimestamp time.Time
+}
+
+func (swipeEvent121000) TableName() string { return "swipe_event" }
@@ -0,0 +1,32 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+// Adds the secret app users prove themselves with on login. Users registered before have none and can not log in,
+// drop_app_users_without_secret removes them.
+func init() {
+	registerMigration(Migration{
+		Version: 20261017121100,
+		Name:    "app_user_secrets",
+		Up: func(txDb *gorm.DB) error {
+			return txDb.AutoMigrate(&userList121100{}).Error
+		},
+		// The column is kept, older versions ignore it
+		Down: func(txDb *gorm.DB) error {
+			return nil
+		},
+	})
+}
+
+type userList121100 struct {
+	gorm.Model
+	LoopUID      string `gorm:"type:varchar(70);index"`
+	LoopUserName string `gorm:"type:varchar(50)"`
+	JoinTime     time.Time
+	SecretHash   string `gorm:"type:varchar(64)"`
+}
+
+func (userList121100) TableName() string { return "user_list" }
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Removes the users registered before secrets were issued. Nothing proves who they are, so they register again,
+// which gets them a secret. Their favourites, view lists and swipes are removed with them, whoever registers their
+// LoopUID next must not get them.
+func init() {
+	registerMigration(Migration{
+		Version: 20261017121200,
+		Name:    "drop_app_users_without_secret",
+		Up: func(txDb *gorm.DB) error {
+			err := txDb.Exec("DELETE FROM user_list WHERE secret_hash IS NULL OR secret_hash = ''").Error
+			if err != nil {
+				return err
+			}
+			// Also drops what is left under LoopUIDs no user is registered with, e.g. the uid a user was moved from
+			registered := "SELECT loop_uid FROM user_list WHERE loop_uid IS NOT NULL"
+			err = txDb.Exec("DELETE FROM view_list_club WHERE view_list_id IN " +
+				"(SELECT view_list_id FROM view_list WHERE loop_uid NOT IN (" + registered + "))").Error
+			if err != nil {
+				return err
+			}
+			tables := []string{"user_favourite", "user_favourite_log", "view_list", "view_list_log", "swipe_action",
+				"swipe_batch", "swipe_event"}
+			for _, table := range tables {
+				err = txDb.Exec("DELETE FROM " + table + " WHERE loop_uid NOT IN (" + registered + ")").Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		// Removed users and their data are not restored
+		Down: func(txDb *gorm.DB) error {
+			return nil
+		},
+	})
+}
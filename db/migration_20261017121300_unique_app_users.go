@@ -0,0 +1,46 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+// Makes the LoopUID of app users unique, so concurrent registrations of a LoopUID can not both succeed. Users
+// registered twice already keep the first registration.
+func init() {
+	registerMigration(Migration{
+		Version: 20261017121300,
+		Name:    "unique_app_users",
+		Up: func(txDb *gorm.DB) error {
+			// MySQL can not select from the table it deletes from, unless the select is materialized
+			err := txDb.Exec("DELETE FROM user_list WHERE id NOT IN " +
+				"(SELECT id FROM (SELECT MIN(id) AS id FROM user_list GROUP BY loop_uid) kept)").Error
+			if err != nil {
+				return err
+			}
+			err = txDb.AutoMigrate(&userList121300{}).Error
+			if err != nil {
+				return err
+			}
+			return txDb.Model(&userList121300{}).RemoveIndex("idx_user_list_loop_uid").Error
+		},
+		// Removed registrations are not restored
+		Down: func(txDb *gorm.DB) error {
+			err := txDb.Model(&userList121300{}).AddIndex("idx_user_list_loop_uid", "loop_uid").Error
+			if err != nil {
+				return err
+			}
+			return txDb.Model(&userList121300{}).RemoveIndex("uix_user_list_loop_uid").Error
+		},
+	})
+}
+
+type userList121300 struct {
+	gorm.Model
+	LoopUID      string `gorm:"type:varchar(70);unique_index"`
+	LoopUserName string `gorm:"type:varchar(50)"`
+	JoinTime     time.Time
+	SecretHash   string `gorm:"type:varchar(64)"`
+}
+
+func (userList121300) TableName() string { return "user_list" }
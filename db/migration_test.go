@@ -0,0 +1,353 @@
+package db
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrateUpDown(t *testing.T) {
+	initTestDB(t)
+	pending, err := GetPendingMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("no migrations should be pending, got %+v", pending)
+	}
+
+	club := ClubInfo{ClubID: "club-1", Name: "Chess Club"}
+	if err := club.Insert(DB); err != nil {
+		t.Fatal(err)
+	}
+	gallery := []ClubPicture{{PictureID: "a", Position: 1, Cover: true}, {PictureID: "b", Position: 2}}
+	if err := ReplaceClubGallery(DB, "club-1", gallery); err != nil {
+		t.Fatal(err)
+	}
+
+	// Roll back up to and including club_gallery
+	states, err := GetMigrationStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := 0
+	for idx, state := range states {
+		if state.Name == "club_gallery" {
+			steps = len(states) - idx
+		}
+	}
+	rolledBack, err := MigrateDown(steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rolledBack != steps {
+		t.Fatalf("expected %d migrations rolled back, got %d", steps, rolledBack)
+	}
+	var columns struct {
+		Pic1ID string
+		Pic2ID string
+		Pic3ID string
+	}
+	if err := DB.Table("club_info").Select("pic1_id, pic2_id, pic3_id").Where("club_id = ?", "club-1").Scan(&columns).Error; err != nil {
+		t.Fatal(err)
+	}
+	if columns.Pic1ID != "a" || columns.Pic2ID != "b" || columns.Pic3ID != "" {
+		t.Errorf("gallery should be restored to the legacy columns, got %+v", columns)
+	}
+
+	states, err = GetMigrationStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	galleryState := states[len(states)-steps]
+	if galleryState.Name != "club_gallery" || galleryState.AppliedAt != nil {
+		t.Errorf("club_gallery should be pending, got %+v", galleryState)
+	}
+
+	applied, err := MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != steps {
+		t.Fatalf("expected %d migrations applied, got %d", steps, applied)
+	}
+	restored, err := GetClubGallery(DB, "club-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 2 || restored[0].PictureID != "a" || !restored[0].Cover || restored[1].PictureID != "b" {
+		t.Errorf("unexpected gallery after migrating up again: %+v", restored)
+	}
+
+	rolledBack, err = MigrateDown(len(states))
+	if err == nil || !strings.Contains(err.Error(), ErrIrreversibleMigration.Error()) || rolledBack != len(states)-1 {
+		t.Fatalf("all migrations but the first should be rolled back, got %d %v", rolledBack, err)
+	}
+	if !DB.HasTable(&ClubInfo{}) {
+		t.Error("tables of the first migration should be kept")
+	}
+	pending, err = GetPendingMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(states)-1 {
+		t.Errorf("%d migrations should be pending, got %d", len(states)-1, len(pending))
+	}
+}
+
+// Databases from before versioned migrations may have logged clubs several times in a view list.
+func TestUniqueViewListLogsMigration(t *testing.T) {
+	initTestDB(t)
+	dropTestTables(t)
+	// The tables AutoMigrate created at startup back then
+	if err := DB.AutoMigrate(append(baseModels(), &userList120000{})...).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Double taps
+	for _, clubID := range []string{"club-1", "club-1", "club-2", "club-1"} {
+		viewListLog := viewListLog120000{ViewListID: "view", LoopUID: "user", ClubID: clubID}
+		if err := DB.Create(&viewListLog).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Later migrations remove the logs of users registered before secrets were issued
+	migrateUpTo(t, 20261017120800)
+	logs, err := GetViewedListByID(DB, "user", "view")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 || logs[0].ID != 1 || logs[1].ClubID != "club-2" {
+		t.Errorf("duplicates should be collapsed into the first log, got %+v", logs)
+	}
+
+	viewListLog := ViewListLog{ViewListID: "view", LoopUID: "user", ClubID: "club-2"}
+	if err := viewListLog.Upsert(DB); err != nil {
+		t.Fatal(err)
+	}
+	viewListLog = ViewListLog{ViewListID: "view", LoopUID: "user", ClubID: "club-2"}
+	if err := viewListLog.Insert(DB); err == nil {
+		t.Error("a club should be logged once per view list")
+	}
+	logs, err = GetViewedListByID(DB, "user", "view")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 {
+		t.Errorf("reading a club again should not add a log, got %+v", logs)
+	}
+	if _, err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Applies the pending migrations up to and including version.
+func migrateUpTo(t *testing.T, version int64) {
+	all := migrations
+	defer func() { migrations = all }()
+	migrations = nil
+	for _, migration := range all {
+		if migration.Version <= version {
+			migrations = append(migrations, migration)
+		}
+	}
+	if _, err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Galleries in the legacy columns of club_info could hold a picture in several slots.
+func TestClubGalleryMigrationWithDuplicatedPicture(t *testing.T) {
+	initTestDB(t)
+	dropTestTables(t)
+	// The tables AutoMigrate created at startup back then
+	if err := DB.AutoMigrate(append(baseModels(), &userList120000{})...).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, column := range legacyGalleryColumns {
+		if err := DB.Exec("ALTER TABLE club_info ADD COLUMN " + column + " varchar(500) DEFAULT ''").Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := DB.Create(&clubInfo120000{ClubID: "club-1", Name: "Chess Club"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	err := DB.Exec("UPDATE club_info SET pic1_id = 'a', pic2_id = 'b', pic3_id = 'a', pic4_id = 'c' WHERE club_id = 'club-1'").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	gallery, err := GetClubGallery(DB, "club-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"a", "b", "c"}
+	if len(gallery) != len(expected) {
+		t.Fatalf("the picture should be kept once, got %+v", gallery)
+	}
+	for idx, clubPicture := range gallery {
+		if clubPicture.PictureID != expected[idx] || clubPicture.Position != idx+1 || clubPicture.Cover != (idx == 0) {
+			t.Errorf("unexpected gallery picture %d: %+v", idx, clubPicture)
+		}
+	}
+}
+
+// Users registered before secrets were issued can not prove who they are, they register again instead and find
+// nothing of the removed user.
+func TestDropAppUsersWithoutSecretMigration(t *testing.T) {
+	initTestDB(t)
+	// Rolls back unique_app_users as well
+	if _, err := MigrateDown(2); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []UserList{{LoopUID: "legacy"}, {LoopUID: "user", SecretHash: "issued"}} {
+		if err := user.Insert(DB); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// "moved" is what is left under the uid a user was moved from
+	for _, uid := range []string{"legacy", "user", "moved"} {
+		rows := []interface{}{
+			&UserFavourite{LoopUID: uid, ClubID: "club-1", Favourite: true},
+			&UserFavouriteLog{LoopUID: uid, ClubID: "club-1", Action: FAVORITE_ACTION},
+			&ViewList{LoopUID: uid, ViewListID: "view-" + uid},
+			&ViewListClub{ViewListID: "view-" + uid, ClubID: "club-1", Position: 1},
+			&ViewListLog{ViewListID: "view-" + uid, LoopUID: uid, ClubID: "club-1"},
+			&SwipeAction{ViewListID: "view-" + uid, LoopUID: uid, ClubID: "club-1", Action: SWIPE_LIKE},
+			&SwipeBatch{LoopUID: uid, ClientEventID: "batch"},
+			&SwipeEvent{LoopUID: uid, ClientEventID: "event", ViewListID: "view-" + uid, ClubID: "club-1", Action: SWIPE_LIKE},
+		}
+		for _, row := range rows {
+			if err := DB.Create(row).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetAppUserByUid(DB, "legacy"); err == nil {
+		t.Error("users without a secret should be removed")
+	}
+	user, err := GetAppUserByUid(DB, "user")
+	if err != nil || user.SecretHash != "issued" {
+		t.Errorf("users with a secret should be kept, got %+v %v", user, err)
+	}
+	for _, model := range []interface{}{&UserFavourite{}, &UserFavouriteLog{}, &ViewList{}, &ViewListLog{}, &SwipeAction{},
+		&SwipeBatch{}, &SwipeEvent{}} {
+		var uids []string
+		if err := DB.Model(model).Pluck("loop_uid", &uids).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(uids) != 1 || uids[0] != "user" {
+			t.Errorf("only rows of registered users should be kept in %T, got %v", model, uids)
+		}
+	}
+	clubs, err := GetViewListClubs(DB, "view-legacy")
+	if err != nil || len(clubs) != 0 {
+		t.Errorf("view lists of removed users should be removed with their clubs, got %+v %v", clubs, err)
+	}
+	clubs, err = GetViewListClubs(DB, "view-user")
+	if err != nil || len(clubs) != 1 {
+		t.Errorf("view lists of registered users should be kept, got %+v %v", clubs, err)
+	}
+}
+
+// Concurrent registrations could store a LoopUID twice before it was unique.
+func TestUniqueAppUsersMigration(t *testing.T) {
+	initTestDB(t)
+	if _, err := MigrateDown(1); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"first", "second"} {
+		user := UserList{LoopUID: "user", LoopUserName: name, SecretHash: name}
+		if err := user.Insert(DB); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	var users []UserList
+	if err := DB.Where("loop_uid = ?", "user").Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].LoopUserName != "first" {
+		t.Errorf("the first registration should be kept, got %+v", users)
+	}
+	user := UserList{LoopUID: "user", LoopUserName: "third", SecretHash: "third"}
+	if err := user.Insert(DB); !IsDuplicateKeyError(err) {
+		t.Errorf("registering the LoopUID again should fail with a duplicate key error, got %v", err)
+	}
+}
+
+// Migrations create their tables from copies of the models, so changing a model needs a migration of its own.
+func TestMigrationsCreateCurrentModels(t *testing.T) {
+	initTestDB(t)
+	models := []interface{}{
+		&AdminAccount{}, &LoginHistory{}, &AdminSession{}, &LoginChallenge{}, &ClubInfo{}, &ClubPicture{}, &ClubMembership{},
+		&ClubInvite{}, &RevokedToken{}, &ClubTags{}, &ClubTagRelationship{}, &AccountPicture{}, &UserFavourite{},
+		&UserFavouriteLog{}, &ViewList{}, &ViewListLog{}, &UserList{}, &ViewListClub{}, &SwipeAction{}, &SwipeBatch{},
+	}
+	for _, model := range models {
+		scope := DB.NewScope(model)
+		tableName := scope.TableName()
+		for _, field := range scope.GetModelStruct().StructFields {
+			if field.IsIgnored || !field.IsNormal {
+				continue
+			}
+			if !DB.Dialect().HasColumn(tableName, field.DBName) {
+				t.Errorf("column %s.%s is not created by any migration", tableName, field.DBName)
+			}
+			if name, ok := field.TagSettingsGet("UNIQUE_INDEX"); ok && name != "UNIQUE_INDEX" && name != "" {
+				if !DB.Dialect().HasIndex(tableName, name) {
+					t.Errorf("index %s of %s is not created by any migration", name, tableName)
+				}
+			}
+		}
+	}
+}
+
+func TestCreateMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2026, 10, 17, 13, 4, 5, 0, time.UTC)
+	path, err := CreateMigration(dir, "Add club rating!", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "migration_20261017130405_add_club_rating.go" {
+		t.Errorf("unexpected migration file %s", path)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), path, content, 0); err != nil {
+		t.Fatalf("created migration does not parse: %v", err)
+	}
+	if !strings.Contains(string(content), "Version: 20261017130405,") {
+		t.Errorf("created migration has the wrong version:\n%s", content)
+	}
+
+	if _, err := CreateMigration(dir, "add club rating", now); err == nil {
+		t.Error("creating the same migration twice should fail")
+	}
+	if _, err := CreateMigration(dir, "!!", now); err == nil {
+		t.Error("a migration without a name should be refused")
+	}
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+	"testing"
+	"time"
+)
+
+func TestGetUnusedPicturesCreatedBefore(t *testing.T) {
+	initTestDB(t)
+	club := ClubInfo{ClubID: "club-1", Name: "Chess Club", LogoID: "logo"}
+	if err := club.Insert(DB); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReplaceClubGallery(DB, "club-1", []ClubPicture{{PictureID: "gallery", Position: 1, Cover: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-40 * 24 * time.Hour)
+	pictures := []AccountPicture{
+		{Model: gorm.Model{CreatedAt: old}, ClubID: "club-1", PictureID: "logo"},
+		{Model: gorm.Model{CreatedAt: old}, ClubID: "club-1", PictureID: "gallery"},
+		{Model: gorm.Model{CreatedAt: old}, ClubID: "club-1", PictureID: "unused-old"},
+		{Model: gorm.Model{CreatedAt: old}, ClubID: "club-2", PictureID: "archived", Archived: true},
+		{ClubID: "club-1", PictureID: "unused-new"},
+	}
+	for i := range pictures {
+		if err := pictures[i].Insert(DB); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	unused, err := GetUnusedPicturesCreatedBefore(DB, time.Now().Add(-30*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unused) != 1 || unused[0].PictureID != "unused-old" {
+		t.Errorf("expected only unused-old, got %+v", unused)
+	}
+
+	for _, pictureID := range []string{"logo", "gallery", "archived"} {
+		if deleted, err := DeleteUnusedPicture(DB, pictureID); err != nil || deleted {
+			t.Errorf("%s should be kept, got %v %v", pictureID, deleted, err)
+		}
+	}
+	if deleted, err := DeleteUnusedPicture(DB, "unused-old"); err != nil || !deleted {
+		t.Fatalf("unused-old should be deleted, got %v %v", deleted, err)
+	}
+	if _, err := GetClubPicture(DB, "club-1", "unused-old"); !gorm.IsRecordNotFoundError(err) {
+		t.Errorf("deleted picture should be gone, err: %v", err)
+	}
+	if _, err := GetClubPicture(DB, "club-1", "logo"); err != nil {
+		t.Errorf("used picture should be kept, err: %v", err)
+	}
+}
+
+func TestMigrateClubGallery(t *testing.T) {
+	initTestDB(t)
+	// Columns of the gallery before club_picture existed
+	for _, column := range legacyGalleryColumns {
+		if err := DB.Exec("ALTER TABLE club_info ADD COLUMN " + column + " varchar(500)").Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	club := ClubInfo{ClubID: "club-1", Name: "Chess Club"}
+	if err := club.Insert(DB); err != nil {
+		t.Fatal(err)
+	}
+	// The empty second slot used to end the gallery
+	err := DB.Exec("UPDATE club_info SET pic1_id = 'a', pic2_id = '', pic3_id = 'c', pic6_id = 'f' WHERE club_id = 'club-1'").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := migrateClubGallery(DB); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gallery, err := GetClubGallery(DB, "club-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"a", "c", "f"}
+	if len(gallery) != len(expected) {
+		t.Fatalf("expected %d gallery pictures, got %+v", len(expected), gallery)
+	}
+	for idx, clubPicture := range gallery {
+		if clubPicture.PictureID != expected[idx] || clubPicture.Position != idx+1 || clubPicture.Cover != (idx == 0) {
+			t.Errorf("unexpected gallery picture %d: %+v", idx, clubPicture)
+		}
+	}
+
+	if err := ReorderClubGallery(DB, "club-1", []string{"f", "a", "c"}); err != nil {
+		t.Fatal(err)
+	}
+	gallery, err = GetClubGallery(DB, "club-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gallery[0].PictureID != "f" || gallery[2].PictureID != "c" {
+		t.Errorf("unexpected order after reordering: %+v", gallery)
+	}
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"time"
+)
+
+// Repositories used by the HTTP handlers. Records that are not found are reported with gorm.ErrRecordNotFound
+// by every implementation, together with a pointer to an empty record where a pointer is returned.
+type Repositories struct {
+	Accounts   AccountRepository
+	Logins     LoginRepository
+	Clubs      ClubRepository
+	Pictures   PictureRepository
+	Users      UserRepository
+	Favourites FavouriteRepository
+	ViewLists  ViewListRepository
+}
+
+// Admin accounts, the clubs they manage and invites for new managers
+type AccountRepository interface {
+	GetAccountByUserId(accountID string) (*AdminAccount, error)
+	GetAccountByAuthHash(authHash string) (*AdminAccount, error)
+	GetAllAccountInfoByCondition(condition *AccountInfoCondition) ([]AccountInfo, error)
+	GetTotalAccountNum() (int64, error)
+	// Updates email, phone number and note
+	UpdateAccount(account *AdminAccount) error
+	UpdateAccountRole(accountID, role string) error
+	UpdateAccountSuspended(accountID string, suspended bool) error
+	UpdateAccountAuthHash(accountID, authHash string) error
+	DeleteAccountCascade(accountID string) error
+	// Creates the account together with its empty club, which the account owns
+	CreateClubAccount(account *AdminAccount) error
+	GetClubMembership(clubID, accountID string) (*ClubMembership, error)
+	GetClubManagers(clubID string) ([]ClubManager, error)
+	InsertClubInvite(invite *ClubInvite) error
+	GetClubInviteByHash(inviteHash string) (*ClubInvite, error)
+	// Accepts an open, unexpired invite by creating the account as editor of the club of the invite.
+	// Returns false when the invite was used or expired meanwhile.
+	AcceptClubInvite(inviteHash string, account *AdminAccount, now time.Time) (bool, error)
+}
+
+// Login history and QR code login challenges
+type LoginRepository interface {
+	InsertLoginHistory(history *LoginHistory) error
+	GetLoginHistoryByCondition(condition *LoginHistoryCondition) ([]LoginHistory, error)
+	GetLoginHistoryNumByCondition(condition *LoginHistoryCondition) (int64, error)
+	InsertLoginChallenge(challenge *LoginChallenge) error
+	GetLoginChallengeByID(challengeID string) (*LoginChallenge, error)
+	ApproveLoginChallenge(challengeID, accountID string, now time.Time) (bool, error)
+	ConsumeLoginChallenge(challengeID string, now time.Time) (bool, error)
+	DeleteExpiredLoginChallenges(now time.Time) error
+}
+
+// Club infos, their galleries and tags
+type ClubRepository interface {
+	GetClubInfoByClubId(clubID string) (*ClubInfo, error)
+	GetClubInfoCountByClubId(clubID string) (ClubInfoCount, error)
+	GetClubInfoCountsByCondition(condition *ClubInfoCondition) ([]ClubInfoCount, error)
+	GetClubInfoNumByCondition(condition *ClubInfoCondition) (int64, error)
+	GetPublishedClubInfosByClubIds(clubIDs []string) ([]ClubInfo, error)
+	UpdateClubPublishedOrNot(clubID string, published bool) error
+	// Updates the club info and replaces its gallery in one transaction. The tags are replaced too,
+	// unless tagIDs is empty.
+	UpdateClubInfo(clubInfo *ClubInfo, tagIDs []string, gallery []ClubPicture) error
+	GetClubGallery(clubID string) ([]ClubPicture, error)
+	ReorderClubGallery(clubID string, pictureIDs []string) error
+	GetAllClubTags() ([]ClubTags, error)
+	GetClubTagsByTagIds(tagIDs []string) ([]ClubTags, error)
+	GetTagRelationshipsByTagIDs(tagIDs []string) ([]ClubTagRelationship, error)
+	GetTagRelationshipsByClubID(clubID string) ([]ClubTagRelationship, error)
+	GetAllTagRelationships() ([]ClubTagRelationship, error)
+}
+
+// Pictures uploaded by club managers
+type PictureRepository interface {
+	InsertPicture(picture *AccountPicture) error
+	GetPictureById(pictureID string) (*AccountPicture, error)
+	GetClubPicture(clubID, pictureID string) (*AccountPicture, error)
+	GetClubPictures(clubID string) ([]AccountPicture, error)
+	GetClubPictureIDs(clubID string) ([]AccountPicture, error)
+	UpdatePictureContentHash(pictureID, contentHash string) error
+	// Updates caption and alt text
+	UpdatePictureText(picture *AccountPicture) error
+	// Deletes the picture unless it is archived or a club uses it, false when it was kept
+	DeleteUnusedPicture(pictureID string) (bool, error)
+	GetUnusedPicturesCreatedBefore(before time.Time) ([]AccountPicture, error)
+}
+
+// Mini-app users and their revoked tokens
+type UserRepository interface {
+	GetAppUserByUid(loopUID string) (*UserList, error)
+	// Fails with a duplicate key error when the LoopUID is registered already
+	InsertAppUser(user *UserList) error
+	// Moves the user registered with SrcLoopUID to the new LoopUID and name, fails with a duplicate key error when
+	// another user is registered with the new LoopUID
+	UpdateAppUser(user *UpdateUser) error
+	// Fails with a duplicate key error when the token is revoked already, so revoking a token once is a check too
+	RevokeToken(token *RevokedToken) error
+	IsTokenRevoked(tokenID string) (bool, error)
+	DeleteExpiredRevokedTokens(now time.Time) error
+}
+
+// Favourite clubs of mini-app users
+type FavouriteRepository interface {
+	// Sets the favourite state and logs the action in one transaction
+	SetFavourite(loopUID, clubID string, favourite bool) error
+	GetUserFavouritesByUID(loopUID string) ([]UserFavourite, error)
+	// Current favourites of all users, only LoopUID and ClubID are filled in
+	GetAllUserFavourites() ([]UserFavourite, error)
+	GetAllPublishedFavouriteClubInfo(loopUID string) ([]FavouriteClubInfo, error)
+	GetAllPublishedFavouriteClubInfoByClubIDs(loopUID string, clubIDs []string) ([]FavouriteClubInfo, error)
+	GetUnreadPublishedFavouriteClubInfo(loopUID, viewListID string) ([]FavouriteClubInfo, error)
+}
+
+// View lists of mini-app users and the clubs read in them
+type ViewListRepository interface {
+	GetLatestViewListByUID(loopUID string) (*ViewList, error)
+	InsertViewList(viewList *ViewList) error
+	// Logs the club read in the view list, a club read again in the same view list is logged once
+	UpsertViewListLog(viewListLog *ViewListLog) error
+	GetViewListClubs(viewListID string) ([]ViewListClub, error)
+	// Marks the view list ordered. Fails with a duplicate key error when one of the clubs is in the view list already
+	InsertViewListClubs(viewListID string, clubIDs []string) error
+	GetSwipeAction(viewListID, clubID string) (*SwipeAction, error)
+	// Replaces the earlier swipe on the club in the view list, marks the club read and updates the favourite.
+	// Swipes saved concurrently are stored once.
+	SaveSwipeAction(swipe *SwipeAction) error
+	GetPassedClubIDs(loopUID string, since time.Time) ([]string, error)
+	GetSwipeBatch(loopUID, clientEventID string) (*SwipeBatch, error)
+	// Returns which of the client event IDs of the user are stored already
+	GetSwipeEventIDs(loopUID string, clientEventIDs []string) ([]string, error)
+	// Stores the batch and its events, marks the clubs read and saves the swipes in order, all or nothing. Fails
+	// with a duplicate key error when the batch or one of the events is stored already.
+	SaveSwipeBatch(batch *SwipeBatch, events []SwipeEvent, reads []ViewListLog, swipes []SwipeAction) error
+}
@@ -0,0 +1,589 @@
+package db_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"tinder-for-clubs-backend/db"
+	"tinder-for-clubs-backend/db/dbtest"
+)
+
+// Every case runs on the database and on the in-memory repositories, so the fake used by handler tests
+// keeps the semantics of the queries.
+var repositoryCases = []struct {
+	method string
+	test   func(t *testing.T, repos db.Repositories)
+}{
+	{"CreateClubAccount", testCreateClubAccount},
+	{"AcceptClubInvite", testAcceptClubInvite},
+	{"GetClubGallery", testGetClubGallery},
+	{"GetTagRelationshipsByTagIDs", testGetTagRelationshipsByTagIDs},
+	{"GetAllTagRelationships", testGetAllTagRelationships},
+	{"GetAllUserFavourites", testGetAllUserFavourites},
+	{"GetAllPublishedFavouriteClubInfo", testGetAllPublishedFavouriteClubInfo},
+	{"GetUnreadPublishedFavouriteClubInfo", testGetUnreadPublishedFavouriteClubInfo},
+	{"GetViewListClubs", testGetViewListClubs},
+	{"SaveSwipeAction", testSaveSwipeAction},
+	{"SaveSwipeActionConcurrently", testSaveSwipeActionConcurrently},
+	{"SaveSwipeActionKeepsLaterSwipe", testSaveSwipeActionKeepsLaterSwipe},
+	{"GetPassedClubIDs", testGetPassedClubIDs},
+	{"SaveSwipeBatch", testSaveSwipeBatch},
+	{"GetClubInfoCountByClubId", testGetClubInfoCountByClubId},
+	{"RevokeTokenConcurrently", testRevokeTokenConcurrently},
+	{"InsertAppUserConcurrently", testInsertAppUserConcurrently},
+	{"UpdateAppUser", testUpdateAppUser},
+	{"GetUnusedPicturesCreatedBefore", testGetUnusedPicturesCreatedBefore},
+	{"DeleteUnusedPicture", testDeleteUnusedPicture},
+	{"DeleteAccountCascade", testDeleteAccountCascade},
+	{"GetAllAccountInfoByCondition", testGetAllAccountInfoByCondition},
+}
+
+func TestRepositories(t *testing.T) {
+	implementations := map[string]func(t *testing.T) db.Repositories{
+		"gorm": func(t *testing.T) db.Repositories {
+			db.InitTestDB(t)
+			return db.NewGormRepositories(db.DB)
+		},
+		"memory": func(t *testing.T) db.Repositories {
+			return dbtest.NewMemoryRepository().Repositories()
+		},
+	}
+	for _, repositoryCase := range repositoryCases {
+		repositoryCase := repositoryCase
+		t.Run(repositoryCase.method, func(t *testing.T) {
+			for name, newRepositories := range implementations {
+				newRepositories := newRepositories
+				t.Run(name, func(t *testing.T) {
+					repositoryCase.test(t, newRepositories(t))
+				})
+			}
+		})
+	}
+}
+
+// Creates the account owning clubID, and publishes the club with the tags given.
+func createPublishedClub(t *testing.T, repos db.Repositories, accountID, clubID string, tagIDs ...string) {
+	account := db.AdminAccount{AccountID: accountID, ClubID: clubID, AuthHash: "hash-" + accountID, Role: db.ROLE_CLUB_MANAGER}
+	if err := repos.Accounts.CreateClubAccount(&account); err != nil {
+		t.Fatal(err)
+	}
+	club := db.ClubInfo{ClubID: clubID, Name: "Chess Club", Published: true, LogoID: "logo"}
+	if err := repos.Clubs.UpdateClubInfo(&club, tagIDs, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testCreateClubAccount(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1")
+	membership, err := repos.Accounts.GetClubMembership("club-1", "owner")
+	if err != nil || membership.Role != db.MEMBER_OWNER {
+		t.Errorf("account should own the club, got %+v %v", membership, err)
+	}
+	if _, err := repos.Accounts.GetAccountByUserId("owner"); err != nil {
+		t.Error(err)
+	}
+}
+
+func testAcceptClubInvite(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1")
+	invite := db.ClubInvite{InviteHash: "invite", ClubID: "club-1", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := repos.Accounts.InsertClubInvite(&invite); err != nil {
+		t.Fatal(err)
+	}
+	editor := db.AdminAccount{AccountID: "editor", ClubID: "club-1", AuthHash: "hash-editor", Role: db.ROLE_CLUB_MANAGER}
+	accepted, err := repos.Accounts.AcceptClubInvite("invite", &editor, time.Now())
+	if err != nil || !accepted {
+		t.Fatalf("invite should be accepted, got %v %v", accepted, err)
+	}
+	membership, err := repos.Accounts.GetClubMembership("club-1", "editor")
+	if err != nil || membership.Role != db.MEMBER_EDITOR {
+		t.Errorf("invitee should manage the club, got %+v %v", membership, err)
+	}
+	second := db.AdminAccount{AccountID: "second", ClubID: "club-1"}
+	if accepted, _ := repos.Accounts.AcceptClubInvite("invite", &second, time.Now()); accepted {
+		t.Error("an invite should only be accepted once")
+	}
+}
+
+func testGetClubGallery(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1")
+	club := db.ClubInfo{ClubID: "club-1", Name: "Chess Club", Published: true}
+	gallery := []db.ClubPicture{{PictureID: "b", Position: 2}, {PictureID: "a", Position: 1, Cover: true}}
+	if err := repos.Clubs.UpdateClubInfo(&club, nil, gallery); err != nil {
+		t.Fatal(err)
+	}
+	storedGallery, err := repos.Clubs.GetClubGallery("club-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(storedGallery) != 2 || storedGallery[0].PictureID != "a" {
+		t.Errorf("gallery should be ordered by position, got %+v", storedGallery)
+	}
+}
+
+func testGetTagRelationshipsByTagIDs(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1", "tag-1", "tag-2")
+	relations, err := repos.Clubs.GetTagRelationshipsByTagIDs([]string{"tag-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(relations) != 1 || relations[0].ClubID != "club-1" {
+		t.Errorf("unexpected tag relationships %+v", relations)
+	}
+}
+
+func testGetAllTagRelationships(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1", "tag-1", "tag-2")
+	createPublishedClub(t, repos, "other", "club-2", "tag-1")
+	relations, err := repos.Clubs.GetAllTagRelationships()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(relations) != 3 {
+		t.Errorf("expected all tag relationships, got %+v", relations)
+	}
+}
+
+func testGetAllUserFavourites(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1")
+	if err := repos.Favourites.SetFavourite("user", "club-1", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Favourites.SetFavourite("other", "club-1", false); err != nil {
+		t.Fatal(err)
+	}
+	favourites, err := repos.Favourites.GetAllUserFavourites()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(favourites) != 1 || favourites[0].LoopUID != "user" {
+		t.Errorf("only current favourites should be returned, got %+v", favourites)
+	}
+}
+
+func testGetAllPublishedFavouriteClubInfo(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1")
+	if err := repos.Favourites.SetFavourite("user", "club-1", true); err != nil {
+		t.Fatal(err)
+	}
+	clubs, err := repos.Favourites.GetAllPublishedFavouriteClubInfo("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clubs) != 1 || !clubs[0].Favourite {
+		t.Errorf("club should be favourite, got %+v", clubs)
+	}
+}
+
+func testGetUnreadPublishedFavouriteClubInfo(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1")
+	createPublishedClub(t, repos, "other", "club-2")
+	for i := 0; i < 2; i++ {
+		viewListLog := db.ViewListLog{ViewListID: "view", LoopUID: "user", ClubID: "club-1"}
+		if err := repos.ViewLists.UpsertViewListLog(&viewListLog); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unread, err := repos.Favourites.GetUnreadPublishedFavouriteClubInfo("user", "view")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unread) != 1 || unread[0].ClubID != "club-2" {
+		t.Errorf("only the club not read should be unread, got %+v", unread)
+	}
+}
+
+func testGetViewListClubs(t *testing.T, repos db.Repositories) {
+	if err := repos.ViewLists.InsertViewListClubs("view", []string{"club-2", "club-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.ViewLists.InsertViewListClubs("view", []string{"club-1"}); err == nil {
+		t.Error("a club should be in a view list once")
+	}
+	clubs, err := repos.ViewLists.GetViewListClubs("view")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clubs) != 2 || clubs[0].ClubID != "club-2" || clubs[1].Position != 2 {
+		t.Errorf("view list clubs should keep their order, got %+v", clubs)
+	}
+}
+
+func testSaveSwipeAction(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1")
+	swipe := db.SwipeAction{ViewListID: "view", LoopUID: "user", ClubID: "club-1", Action: db.SWIPE_LIKE}
+	if err := repos.ViewLists.SaveSwipeAction(&swipe); err != nil {
+		t.Fatal(err)
+	}
+	swipe = db.SwipeAction{ViewListID: "view", LoopUID: "user", ClubID: "club-1", Action: db.SWIPE_PASS}
+	for i := 0; i < 2; i++ {
+		if err := repos.ViewLists.SaveSwipeAction(&swipe); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stored, err := repos.ViewLists.GetSwipeAction("view", "club-1")
+	if err != nil || stored.Action != db.SWIPE_PASS {
+		t.Errorf("the swipe should be replaced, got %+v %v", stored, err)
+	}
+	favourites, err := repos.Favourites.GetUserFavouritesByUID("user")
+	if err != nil || len(favourites) != 0 {
+		t.Errorf("passing should unfavour the liked club, got %+v %v", favourites, err)
+	}
+	unread, err := repos.Favourites.GetUnreadPublishedFavouriteClubInfo("user", "view")
+	if err != nil || len(unread) != 0 {
+		t.Errorf("swiped club should be read, got %+v %v", unread, err)
+	}
+}
+
+func testSaveSwipeActionConcurrently(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1")
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			swipe := db.SwipeAction{ViewListID: "view", LoopUID: "user", ClubID: "club-1", Action: db.SWIPE_LIKE}
+			errs <- repos.ViewLists.SaveSwipeAction(&swipe)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("repeated first swipes should all succeed, got %v", err)
+		}
+	}
+	favourites, err := repos.Favourites.GetUserFavouritesByUID("user")
+	if err != nil || len(favourites) != 1 {
+		t.Errorf("club should be favoured once, got %+v %v", favourites, err)
+	}
+	count, err := repos.Clubs.GetClubInfoCountByClubId("club-1")
+	if err != nil || count.ViewNum != 1 {
+		t.Errorf("club should be read once, got %+v %v", count, err)
+	}
+}
+
+func testSaveSwipeActionKeepsLaterSwipe(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1")
+	later := time.Now()
+	earlier := later.Add(-time.Minute)
+	swipe := db.SwipeAction{ViewListID: "view", LoopUID: "user", ClubID: "club-1", Action: db.SWIPE_LIKE, ClientTimestamp: &later}
+	if err := repos.ViewLists.SaveSwipeAction(&swipe); err != nil {
+		t.Fatal(err)
+	}
+	late := db.SwipeAction{ViewListID: "view", LoopUID: "user", ClubID: "club-1", Action: db.SWIPE_PASS, ClientTimestamp: &earlier}
+	if err := repos.ViewLists.SaveSwipeAction(&late); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := repos.ViewLists.GetSwipeAction("view", "club-1")
+	if err != nil || stored.Action != db.SWIPE_LIKE {
+		t.Errorf("a swipe made earlier should not replace the stored one, got %+v %v", stored, err)
+	}
+	favourites, err := repos.Favourites.GetUserFavouritesByUID("user")
+	if err != nil || len(favourites) != 1 {
+		t.Errorf("the club should stay favoured, got %+v %v", favourites, err)
+	}
+}
+
+func testGetPassedClubIDs(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1")
+	swipes := []db.SwipeAction{
+		{ViewListID: "view", LoopUID: "user", ClubID: "club-1", Action: db.SWIPE_PASS},
+		{ViewListID: "view", LoopUID: "user", ClubID: "club-2", Action: db.SWIPE_LIKE},
+		{ViewListID: "other view", LoopUID: "other", ClubID: "club-2", Action: db.SWIPE_PASS},
+	}
+	for i := range swipes {
+		if err := repos.ViewLists.SaveSwipeAction(&swipes[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	passed, err := repos.ViewLists.GetPassedClubIDs("user", time.Now().Add(-time.Minute))
+	if err != nil || len(passed) != 1 || passed[0] != "club-1" {
+		t.Errorf("only the club the user passed should be returned, got %v %v", passed, err)
+	}
+	passed, err = repos.ViewLists.GetPassedClubIDs("user", time.Now().Add(time.Minute))
+	if err != nil || len(passed) != 0 {
+		t.Errorf("passes before the cooldown should be left out, got %v %v", passed, err)
+	}
+}
+
+func testSaveSwipeBatch(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1")
+	batch := db.SwipeBatch{LoopUID: "user", ClientEventID: "batch", Results: "[]"}
+	events := []db.SwipeEvent{
+		{LoopUID: "user", ClientEventID: "read", ViewListID: "view", ClubID: "club-1", Action: "READ", ClientTimestamp: time.Now()},
+		{LoopUID: "user", ClientEventID: "skip", ViewListID: "view", ClubID: "club-1", Action: db.SWIPE_SKIP, ClientTimestamp: time.Now()},
+	}
+	reads := []db.ViewListLog{
+		{ViewListID: "view", LoopUID: "user", ClubID: "club-1"},
+		{ViewListID: "view", LoopUID: "user", ClubID: "club-1"},
+	}
+	swipes := []db.SwipeAction{{ViewListID: "view", LoopUID: "user", ClubID: "club-1", Action: db.SWIPE_SKIP}}
+	if err := repos.ViewLists.SaveSwipeBatch(&batch, events, reads, swipes); err != nil {
+		t.Fatal(err)
+	}
+	replay := db.SwipeBatch{LoopUID: "user", ClientEventID: "batch", Results: "[]"}
+	if err := repos.ViewLists.SaveSwipeBatch(&replay, nil, reads, nil); !db.IsDuplicateKeyError(err) {
+		t.Errorf("a batch should be stored once, got %v", err)
+	}
+	rebatched := db.SwipeBatch{LoopUID: "user", ClientEventID: "another batch", Results: "[]"}
+	if err := repos.ViewLists.SaveSwipeBatch(&rebatched, events[1:], nil, swipes); !db.IsDuplicateKeyError(err) {
+		t.Errorf("an event should be stored once, got %v", err)
+	}
+	if _, err := repos.ViewLists.GetSwipeBatch("user", "another batch"); err == nil {
+		t.Error("a batch with a stored event should not be stored")
+	}
+	stored, err := repos.ViewLists.GetSwipeBatch("user", "batch")
+	if err != nil || stored.Results != "[]" {
+		t.Errorf("batch should be found, got %+v %v", stored, err)
+	}
+	if _, err := repos.ViewLists.GetSwipeBatch("someone else", "batch"); err == nil {
+		t.Error("client event IDs should be per user")
+	}
+	eventIDs, err := repos.ViewLists.GetSwipeEventIDs("user", []string{"skip", "unknown"})
+	if err != nil || len(eventIDs) != 1 || eventIDs[0] != "skip" {
+		t.Errorf("only the stored event should be found, got %v %v", eventIDs, err)
+	}
+	eventIDs, err = repos.ViewLists.GetSwipeEventIDs("someone else", []string{"skip"})
+	if err != nil || len(eventIDs) != 0 {
+		t.Errorf("client event IDs should be per user, got %v %v", eventIDs, err)
+	}
+	count, err := repos.Clubs.GetClubInfoCountByClubId("club-1")
+	if err != nil || count.ViewNum != 1 {
+		t.Errorf("the batch should read the club once, got %+v %v", count, err)
+	}
+}
+
+func testGetClubInfoCountByClubId(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1")
+	for _, uid := range []string{"user", "other"} {
+		if err := repos.Favourites.SetFavourite(uid, "club-1", true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reads := []db.ViewListLog{
+		{ViewListID: "view-1", LoopUID: "user", ClubID: "club-1"},
+		{ViewListID: "view-1", LoopUID: "user", ClubID: "club-1"},
+		{ViewListID: "view-2", LoopUID: "user", ClubID: "club-1"},
+		{ViewListID: "view-3", LoopUID: "other", ClubID: "club-1"},
+	}
+	for i := range reads {
+		if err := repos.ViewLists.UpsertViewListLog(&reads[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count, err := repos.Clubs.GetClubInfoCountByClubId("club-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.FavouriteNum != 2 || count.ViewNum != 3 || count.UniqueViewerNum != 2 {
+		t.Errorf("unexpected counts %+v", count)
+	}
+}
+
+func testRevokeTokenConcurrently(t *testing.T, repos db.Repositories) {
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token := db.RevokedToken{TokenID: "token", LoopUID: "user", ExpiresAt: time.Now().Add(time.Hour)}
+			errs <- repos.Users.RevokeToken(&token)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	revoked := 0
+	for err := range errs {
+		if err == nil {
+			revoked++
+		} else if !db.IsDuplicateKeyError(err) {
+			t.Errorf("revoking a revoked token should fail with a duplicate key error, got %v", err)
+		}
+	}
+	if revoked != 1 {
+		t.Errorf("the token should be revoked by exactly one call, got %d", revoked)
+	}
+	if isRevoked, err := repos.Users.IsTokenRevoked("token"); err != nil || !isRevoked {
+		t.Errorf("the token should be revoked, got %v %v", isRevoked, err)
+	}
+}
+
+func testInsertAppUserConcurrently(t *testing.T, repos db.Repositories) {
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- repos.Users.InsertAppUser(&db.UserList{LoopUID: "user", LoopUserName: "tester", SecretHash: "hash"})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	registered := 0
+	for err := range errs {
+		if err == nil {
+			registered++
+		} else if !db.IsDuplicateKeyError(err) {
+			t.Errorf("registering a registered LoopUID should fail with a duplicate key error, got %v", err)
+		}
+	}
+	if registered != 1 {
+		t.Errorf("the LoopUID should be registered by exactly one call, got %d", registered)
+	}
+}
+
+func testUpdateAppUser(t *testing.T, repos db.Repositories) {
+	for _, uid := range []string{"broken", "taken"} {
+		if err := repos.Users.InsertAppUser(&db.UserList{LoopUID: uid, LoopUserName: uid}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := repos.Users.UpdateAppUser(&db.UpdateUser{UserList: db.UserList{LoopUID: "taken", LoopUserName: "moved"}, SrcLoopUID: "broken"})
+	if !db.IsDuplicateKeyError(err) {
+		t.Errorf("moving onto a registered LoopUID should fail with a duplicate key error, got %v", err)
+	}
+	err = repos.Users.UpdateAppUser(&db.UpdateUser{UserList: db.UserList{LoopUID: "fixed", LoopUserName: "moved"}, SrcLoopUID: "broken"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := repos.Users.GetAppUserByUid("fixed")
+	if err != nil || user.LoopUserName != "moved" {
+		t.Errorf("the user should be moved to the new LoopUID, got %+v %v", user, err)
+	}
+	user, err = repos.Users.GetAppUserByUid("taken")
+	if err != nil || user.LoopUserName != "taken" {
+		t.Errorf("the user registered with the taken LoopUID should be kept, got %+v %v", user, err)
+	}
+}
+
+func testDeleteUnusedPicture(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1")
+	club := db.ClubInfo{ClubID: "club-1", Name: "Chess Club", Published: true, LogoID: "logo"}
+	if err := repos.Clubs.UpdateClubInfo(&club, nil, []db.ClubPicture{{PictureID: "a", Position: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, picture := range []db.AccountPicture{
+		{PictureID: "logo"}, {PictureID: "a"}, {PictureID: "archived", Archived: true}, {PictureID: "unused"},
+	} {
+		picture.AccountID, picture.ClubID = "owner", "club-1"
+		if err := repos.Pictures.InsertPicture(&picture); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, pictureID := range []string{"logo", "a", "archived", "missing"} {
+		if deleted, err := repos.Pictures.DeleteUnusedPicture(pictureID); err != nil || deleted {
+			t.Errorf("%s should not be deleted, got %v %v", pictureID, deleted, err)
+		}
+	}
+	if deleted, err := repos.Pictures.DeleteUnusedPicture("unused"); err != nil || !deleted {
+		t.Errorf("the unused picture should be deleted, got %v %v", deleted, err)
+	}
+	pictures, err := repos.Pictures.GetClubPictures("club-1")
+	if err != nil || len(pictures) != 2 {
+		t.Errorf("the used pictures should be kept, got %+v %v", pictures, err)
+	}
+}
+
+func testGetUnusedPicturesCreatedBefore(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1")
+	club := db.ClubInfo{ClubID: "club-1", Name: "Chess Club", Published: true, LogoID: "logo"}
+	if err := repos.Clubs.UpdateClubInfo(&club, nil, []db.ClubPicture{{PictureID: "a", Position: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, pictureID := range []string{"logo", "a", "unused"} {
+		picture := db.AccountPicture{PictureID: pictureID, AccountID: "owner", ClubID: "club-1"}
+		if err := repos.Pictures.InsertPicture(&picture); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unused, err := repos.Pictures.GetUnusedPicturesCreatedBefore(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unused) != 1 || unused[0].PictureID != "unused" {
+		t.Errorf("only the unused picture should be found, got %+v", unused)
+	}
+}
+
+func testDeleteAccountCascade(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1")
+	picture := db.AccountPicture{PictureID: "logo", AccountID: "owner", ClubID: "club-1"}
+	if err := repos.Pictures.InsertPicture(&picture); err != nil {
+		t.Fatal(err)
+	}
+	invite := db.ClubInvite{InviteHash: "invite", ClubID: "club-1", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := repos.Accounts.InsertClubInvite(&invite); err != nil {
+		t.Fatal(err)
+	}
+	editor := db.AdminAccount{AccountID: "editor", ClubID: "club-1", AuthHash: "hash-editor", Role: db.ROLE_CLUB_MANAGER}
+	if _, err := repos.Accounts.AcceptClubInvite("invite", &editor, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for inviteHash, invitedBy := range map[string]string{"from-owner": "owner", "from-admin": "admin"} {
+		invite := db.ClubInvite{InviteHash: inviteHash, ClubID: "club-1", InvitedBy: invitedBy, ExpiresAt: time.Now().Add(time.Hour)}
+		if err := repos.Accounts.InsertClubInvite(&invite); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := repos.Accounts.DeleteAccountCascade("owner"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repos.Accounts.GetClubInviteByHash("from-owner"); err == nil {
+		t.Error("open invites of the deleted account should be deleted")
+	}
+	if _, err := repos.Accounts.GetClubInviteByHash("from-admin"); err != nil {
+		t.Errorf("open invites of others should be kept while the club has managers, got %v", err)
+	}
+	membership, err := repos.Accounts.GetClubMembership("club-1", "editor")
+	if err != nil || membership.Role != db.MEMBER_OWNER {
+		t.Errorf("editor should become owner, got %+v %v", membership, err)
+	}
+	if _, err := repos.Accounts.GetAccountByUserId("owner"); err == nil {
+		t.Error("deleted account should not be found")
+	}
+	if err := repos.Accounts.DeleteAccountCascade("editor"); err != nil {
+		t.Fatal(err)
+	}
+	club, err := repos.Clubs.GetClubInfoByClubId("club-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if club.Published {
+		t.Error("club of the last manager should be unpublished")
+	}
+	if _, err := repos.Pictures.GetPictureById("logo"); err == nil {
+		t.Error("pictures of the club should be archived")
+	}
+	if _, err := repos.Accounts.GetClubInviteByHash("from-admin"); err == nil {
+		t.Error("open invites of a club without managers should be deleted")
+	}
+	if _, err := repos.Accounts.GetClubInviteByHash("invite"); err != nil {
+		t.Errorf("accepted invites should be kept, got %v", err)
+	}
+}
+
+func testGetAllAccountInfoByCondition(t *testing.T, repos db.Repositories) {
+	createPublishedClub(t, repos, "owner", "club-1")
+	createPublishedClub(t, repos, "deleted", "club-2")
+	if err := repos.Accounts.DeleteAccountCascade("deleted"); err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, err := repos.Accounts.GetAllAccountInfoByCondition(&db.AccountInfoCondition{PageRequest: db.PageRequest{Limit: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].AccountID != "owner" || accounts[0].ClubName == "" {
+		t.Errorf("only the account not deleted should be listed, got %+v", accounts)
+	}
+	total, err := repos.Accounts.GetTotalAccountNum()
+	if err != nil || total != 1 {
+		t.Errorf("deleted accounts should not be counted, got %d %v", total, err)
+	}
+}
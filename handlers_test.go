@@ -0,0 +1,1411 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"tinder-for-clubs-backend/auth"
+	"tinder-for-clubs-backend/cache"
+	"tinder-for-clubs-backend/config"
+	"tinder-for-clubs-backend/db"
+	"tinder-for-clubs-backend/db/dbtest"
+	"tinder-for-clubs-backend/httpserver"
+	"tinder-for-clubs-backend/mail"
+	"tinder-for-clubs-backend/picture"
+	"tinder-for-clubs-backend/storage"
+)
+
+// A server with in-memory repositories and sessions, no database is needed.
+type testServer struct {
+	*server
+	router *gin.Engine
+	memory *dbtest.MemoryRepository
+}
+
+func newTestServer(t *testing.T) *testServer {
+	gin.SetMode(gin.TestMode)
+	ts := &testServer{server: &server{}, memory: dbtest.NewMemoryRepository()}
+	ts.config.AdminAuth.AuthHashKey = "test auth hash key, at least 32 characters"
+	ts.config.Session = config.Session{Backend: "memory", SecretKey: "test session secret, at least 32 characters"}
+	ts.config.AppAuth.TokenSecret = "test token secret, at least 32 characters"
+
+	ts.repos = ts.memory.Repositories()
+	ts.mailer = mail.NewMailer(ts.config.Mail)
+	ts.loginThrottle = newLoginThrottle(ts.config.AdminAuth)
+	ts.clubRanker = newClubRanker(ts.config.Ranking)
+	ts.rankingSignals = newRankingSignalsCache(ts.config.Ranking)
+	ts.tokenIssuer = newTokenIssuer(ts.config.AppAuth)
+
+	gob.Register(db.AdminAccount{})
+	ts.sessionStore = newSessionStore(ts.config.Session)
+	ts.router = gin.New()
+	ts.router.Use(sessions.Sessions("AdminSession", ts.sessionStore))
+	ts.initRouter(ts.router)
+	return ts
+}
+
+type testResponse struct {
+	Code    int             `json:"code"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Sends a request to the router, with the cookies and headers given, and decodes the response.
+func (ts *testServer) doRequest(t *testing.T, method, path string, body interface{}, headers map[string]string) (*httptest.ResponseRecorder, testResponse) {
+	var reader *bytes.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(content)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	recorder := httptest.NewRecorder()
+	ts.router.ServeHTTP(recorder, req)
+
+	var response testResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("%s %s: undecodable response %q", method, path, recorder.Body.String())
+	}
+	return recorder, response
+}
+
+// Creates a club account, which has the given role, and returns its auth string.
+func (ts *testServer) seedAccount(t *testing.T, role string) (db.AdminAccount, string) {
+	authString := genAuthString()
+	account := db.AdminAccount{
+		AccountID: uuid.New().String(),
+		AuthHash:  ts.hashAuthString(authString),
+		ClubID:    uuid.New().String(),
+		Role:      db.ROLE_CLUB_MANAGER,
+	}
+	if err := ts.repos.Accounts.CreateClubAccount(&account); err != nil {
+		t.Fatal(err)
+	}
+	if role != db.ROLE_CLUB_MANAGER {
+		if err := ts.repos.Accounts.UpdateAccountRole(account.AccountID, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return account, authString
+}
+
+// Logs in with the auth string and returns the session cookie.
+func (ts *testServer) loginAdmin(t *testing.T, authString string) map[string]string {
+	recorder, response := ts.doRequest(t, http.MethodPost, "/login", LoginPost{AuthToken: authString}, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("login failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if response.Code != 2000 {
+		t.Fatalf("unexpected login response %+v", response)
+	}
+	return map[string]string{"Cookie": recorder.Header().Get("Set-Cookie")}
+}
+
+// Registers a mini-app user and returns the authorization header of its access token.
+func (ts *testServer) registerAppUserForTest(t *testing.T, loopUID string) map[string]string {
+	recorder, response := ts.doRequest(t, http.MethodPost, "/app/register", UserPost{LoopUID: loopUID, LoopUserName: "tester"}, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("register failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(response.Payload, &tokens); err != nil {
+		t.Fatal(err)
+	}
+	return map[string]string{"Authorization": "Bearer " + tokens.AccessToken}
+}
+
+// Logs in a mini-app user and returns the tokens.
+func (ts *testServer) loginAppUserForTest(t *testing.T, loginPost AppLoginPost) AppUserTokens {
+	recorder, response := ts.doRequest(t, http.MethodPost, "/app/login", loginPost, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("app login failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var tokens AppUserTokens
+	if err := json.Unmarshal(response.Payload, &tokens); err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+// Publishes a club managed by a new account for each name, tagged with its tag unless empty, and returns the club
+// IDs by name.
+func (ts *testServer) seedPublishedClubs(t *testing.T, clubTags map[string]string) map[string]string {
+	clubIDs := make(map[string]string, len(clubTags))
+	for name, tag := range clubTags {
+		account, _ := ts.seedAccount(t, db.ROLE_CLUB_MANAGER)
+		var tags []string
+		if tag != "" {
+			tags = []string{tag}
+		}
+		club := db.ClubInfo{ClubID: account.ClubID, Name: name, Published: true}
+		if err := ts.memory.UpdateClubInfo(&club, tags, nil); err != nil {
+			t.Fatal(err)
+		}
+		clubIDs[name] = account.ClubID
+	}
+	return clubIDs
+}
+
+// Gets a page of the unread clubs in the current view list of the user.
+func (ts *testServer) getUnreadPage(t *testing.T, userHeader map[string]string, query string) UnreadViewListPage {
+	recorder, response := ts.doRequest(t, http.MethodGet, "/app/viewlist/unreadlist"+query, nil, userHeader)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("unread list failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var page UnreadViewListPage
+	if err := json.Unmarshal(response.Payload, &page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestAdminCreatesClubManagedByNewAccount(t *testing.T) {
+	ts := newTestServer(t)
+	_, adminAuthString := ts.seedAccount(t, db.ROLE_SUPER_ADMIN)
+	adminCookie := ts.loginAdmin(t, adminAuthString)
+
+	recorder, response := ts.doRequest(t, http.MethodPost, "/admin/account/create",
+		NewClubAccountPost{Email: "chess@example.com", PhoneNum: "12345", Note: "Chess Club"}, adminCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("creating account failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var created AccountAuthResponse
+	if err := json.Unmarshal(response.Payload, &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.AuthString == "" || created.ClubID == "" {
+		t.Fatalf("auth string and club should be returned, got %+v", created)
+	}
+	membership, err := ts.memory.GetClubMembership(created.ClubID, created.AccountID)
+	if err != nil || membership.Role != db.MEMBER_OWNER {
+		t.Fatalf("new account should own its club, got %+v %v", membership, err)
+	}
+
+	managerCookie := ts.loginAdmin(t, created.AuthString)
+	recorder, _ = ts.doRequest(t, http.MethodPost, "/club/info",
+		ClubInfoPost{ClubID: "another club", Name: "Chess Club"}, managerCookie)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("updating another club should be forbidden, got %d", recorder.Code)
+	}
+	recorder, _ = ts.doRequest(t, http.MethodPost, "/club/info",
+		ClubInfoPost{ClubID: created.ClubID, Name: "Chess Club", Published: true}, managerCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("updating club failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	clubInfo, err := ts.memory.GetClubInfoByClubId(created.ClubID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clubInfo.Name != "Chess Club" || !clubInfo.Published {
+		t.Errorf("club should be updated, got %+v", clubInfo)
+	}
+
+	recorder, _ = ts.doRequest(t, http.MethodGet, "/admin/account/all", nil, managerCookie)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("club managers should not list accounts, got %d", recorder.Code)
+	}
+}
+
+func TestRotateAuthString(t *testing.T) {
+	ts := newTestServer(t)
+	_, adminAuthString := ts.seedAccount(t, db.ROLE_SUPER_ADMIN)
+	adminCookie := ts.loginAdmin(t, adminAuthString)
+	account, oldAuthString := ts.seedAccount(t, db.ROLE_CLUB_MANAGER)
+	managerCookie := ts.loginAdmin(t, oldAuthString)
+
+	recorder, response := ts.doRequest(t, http.MethodPut, "/admin/account/"+account.AccountID+"/rotate-auth", nil, adminCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("rotating failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var rotated AccountAuthResponse
+	if err := json.Unmarshal(response.Payload, &rotated); err != nil {
+		t.Fatal(err)
+	}
+	if rotated.AuthString == "" || rotated.AuthString == oldAuthString {
+		t.Fatalf("a new auth string should be returned, got %q", rotated.AuthString)
+	}
+	stored, err := ts.memory.GetAccountByUserId(account.AccountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.AuthHash == account.AuthHash || stored.AuthHash != ts.hashAuthString(rotated.AuthString) {
+		t.Errorf("only the hash of the new auth string should be stored, got %q", stored.AuthHash)
+	}
+
+	recorder, _ = ts.doRequest(t, http.MethodGet, "/authorized", nil, managerCookie)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("sessions opened with the old auth string should be revoked, got %d", recorder.Code)
+	}
+	recorder, _ = ts.doRequest(t, http.MethodPost, "/login", LoginPost{AuthToken: oldAuthString}, nil)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("the old auth string should be refused, got %d", recorder.Code)
+	}
+	managerCookie = ts.loginAdmin(t, rotated.AuthString)
+	recorder, _ = ts.doRequest(t, http.MethodGet, "/authorized", nil, managerCookie)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("the new auth string should log in, got %d", recorder.Code)
+	}
+	recorder, _ = ts.doRequest(t, http.MethodGet, "/authorized", nil, adminCookie)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("sessions of other accounts should be kept, got %d", recorder.Code)
+	}
+}
+
+func TestLoginWithWrongAuthStringIsRecorded(t *testing.T) {
+	ts := newTestServer(t)
+	recorder, _ := ts.doRequest(t, http.MethodPost, "/login", LoginPost{AuthToken: "wrong"}, nil)
+	if recorder.Code == http.StatusOK {
+		t.Fatal("login with a wrong auth string should fail")
+	}
+	num, err := ts.memory.GetLoginHistoryNumByCondition(&db.LoginHistoryCondition{AttemptResult: db.LOGIN_FAILED})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if num != 1 {
+		t.Errorf("failed login should be recorded once, got %d", num)
+	}
+}
+
+// Any client can send X-Forwarded-For and X-Real-Ip, made up addresses must not get it a throttle bucket of its own.
+func TestLoginThrottleIgnoresSpoofedClientIP(t *testing.T) {
+	ts := newTestServer(t)
+	for i := 0; i < 6; i++ {
+		spoofed := fmt.Sprintf("203.0.113.%d", i)
+		recorder, _ := ts.doRequest(t, http.MethodPost, "/login", LoginPost{AuthToken: "wrong"},
+			map[string]string{"X-Forwarded-For": spoofed, "X-Real-Ip": spoofed})
+		if i < 5 && recorder.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d should be answered auth failed, got %d", i, recorder.Code)
+		}
+		if i == 5 && recorder.Code != http.StatusTooManyRequests {
+			t.Fatalf("spoofed addresses should share one throttle bucket, got %d", recorder.Code)
+		}
+	}
+
+	// httptest requests come from 192.0.2.1
+	num, err := ts.memory.GetLoginHistoryNumByCondition(&db.LoginHistoryCondition{IP: "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if num != 6 {
+		t.Errorf("attempts should be recorded with the connection address, got %d of 6", num)
+	}
+}
+
+// While all clients are throttled, clients that did not log in before are refused before their credentials are
+// checked, so a distributed guesser learns nothing.
+func TestGlobalLoginThrottleRefusesBeforeCheckingCredentials(t *testing.T) {
+	ts := newTestServer(t)
+	ts.loginThrottle = newLoginThrottle(config.AdminAuth{MaxFailuresPerIP: 100, MaxFailuresGlobal: 3})
+	// Behind a trusted proxy every forwarded address is a client of its own
+	ts.trustedProxies, _ = auth.ParseTrustedProxies([]string{"192.0.2.1"})
+	_, authString := ts.seedAccount(t, db.ROLE_CLUB_MANAGER)
+	knownClient := map[string]string{"X-Forwarded-For": "203.0.113.50"}
+	if recorder, _ := ts.doRequest(t, http.MethodPost, "/login", LoginPost{AuthToken: authString}, knownClient); recorder.Code != http.StatusOK {
+		t.Fatalf("login failed with %d", recorder.Code)
+	}
+
+	for i := 0; i < 3; i++ {
+		client := map[string]string{"X-Forwarded-For": fmt.Sprintf("203.0.113.%d", i)}
+		recorder, _ := ts.doRequest(t, http.MethodPost, "/login", LoginPost{AuthToken: "wrong"}, client)
+		if recorder.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d should be answered auth failed, got %d", i, recorder.Code)
+		}
+	}
+	num, err := ts.memory.GetLoginHistoryNumByCondition(&db.LoginHistoryCondition{IP: "203.0.113.2"})
+	if err != nil || num != 1 {
+		t.Errorf("the forwarded address should be recorded, got %d %v", num, err)
+	}
+
+	newClient := map[string]string{"X-Forwarded-For": "203.0.113.99"}
+	for _, authToken := range []string{"wrong", authString} {
+		recorder, _ := ts.doRequest(t, http.MethodPost, "/login", LoginPost{AuthToken: authToken}, newClient)
+		if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") == "" {
+			t.Errorf("a new client should be throttled whatever it sends, got %d", recorder.Code)
+		}
+	}
+	recorder, _ := ts.doRequest(t, http.MethodPost, "/login", LoginPost{AuthToken: authString}, knownClient)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("a client that logged in before should log in while all clients are throttled, got %d", recorder.Code)
+	}
+}
+
+func TestAppLoginNeedsSecret(t *testing.T) {
+	ts := newTestServer(t)
+	loopUID := strings.Repeat("s", 64)
+	recorder, response := ts.doRequest(t, http.MethodPost, "/app/register", UserPost{LoopUID: loopUID, LoopUserName: "tester"}, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("register failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var registered AppUserTokens
+	if err := json.Unmarshal(response.Payload, &registered); err != nil {
+		t.Fatal(err)
+	}
+	if registered.AppSecret == "" || registered.AccessToken == "" {
+		t.Fatalf("register should issue tokens and a secret, got %+v", registered)
+	}
+
+	recorder, _ = ts.doRequest(t, http.MethodPost, "/app/login", AppLoginPost{LoopUID: loopUID}, nil)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("a bare LoopUID should be refused, got %d", recorder.Code)
+	}
+	recorder, _ = ts.doRequest(t, http.MethodPost, "/app/login", AppLoginPost{LoopUID: loopUID, AppSecret: "guessed"}, nil)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("a wrong secret should be refused, got %d", recorder.Code)
+	}
+	recorder, response = ts.doRequest(t, http.MethodPost, "/app/register", UserPost{LoopUID: loopUID, LoopUserName: "attacker"}, nil)
+	if recorder.Code != http.StatusBadRequest || response.Code != httpserver.USER_ALREADY_REGISTERED.Code {
+		t.Errorf("registering the LoopUID again should be refused, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	recorder, _ = ts.doRequest(t, http.MethodPost, "/app/login", AppLoginPost{LoopUID: loopUID, AppSecret: registered.AppSecret}, nil)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("login with the secret failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Users registered with a broken uid log in with it to move to the right one, keeping their secret
+	brokenUID := strings.Repeat("b", 32) + strings.Repeat("0", 32)
+	recorder, response = ts.doRequest(t, http.MethodPost, "/app/register", UserPost{LoopUID: brokenUID, LoopUserName: "broken"}, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("register failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var broken AppUserTokens
+	if err := json.Unmarshal(response.Payload, &broken); err != nil {
+		t.Fatal(err)
+	}
+	repairedUID := strings.Repeat("r", 64)
+	recorder, _ = ts.doRequest(t, http.MethodPut, "/app/register",
+		UpdateUserPost{SrcLoopUID: brokenUID, NewLoopUID: repairedUID, LoopUserName: "repaired"},
+		map[string]string{"Authorization": "Bearer " + broken.AccessToken})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("moving the broken uid failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	ts.loginAppUserForTest(t, AppLoginPost{LoopUID: repairedUID, AppSecret: broken.AppSecret})
+}
+
+// Users registered before secrets were issued have nothing but their LoopUID, which anyone may know.
+func TestAppLoginWithLoopUIDAloneIsRefused(t *testing.T) {
+	ts := newTestServer(t)
+	legacyUID := strings.Repeat("l", 64)
+	if err := ts.memory.InsertAppUser(&db.UserList{LoopUID: legacyUID, LoopUserName: "legacy"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, loginPost := range []AppLoginPost{{LoopUID: legacyUID}, {LoopUID: legacyUID, AppSecret: "guessed"}} {
+		recorder, response := ts.doRequest(t, http.MethodPost, "/app/login", loginPost, nil)
+		if recorder.Code != http.StatusUnauthorized || response.Code != httpserver.AUTH_FAILED.Code {
+			t.Errorf("login with %+v should be refused, got %d: %s", loginPost, recorder.Code, recorder.Body.String())
+		}
+	}
+	user, err := ts.memory.GetAppUserByUid(legacyUID)
+	if err != nil || user.SecretHash != "" {
+		t.Errorf("a refused login should not issue a secret, got %+v %v", user, err)
+	}
+}
+
+// Runs register after the user is looked up, like a registration of the same LoopUID meanwhile
+type racingAppUserRegister struct {
+	db.UserRepository
+	register func()
+}
+
+func (r *racingAppUserRegister) GetAppUserByUid(loopUID string) (*db.UserList, error) {
+	user, err := r.UserRepository.GetAppUserByUid(loopUID)
+	r.register()
+	return user, err
+}
+
+func TestConcurrentAppRegisterIsRefused(t *testing.T) {
+	ts := newTestServer(t)
+	loopUID := strings.Repeat("c", 64)
+	ts.repos.Users = &racingAppUserRegister{UserRepository: ts.repos.Users, register: func() {
+		if err := ts.memory.InsertAppUser(&db.UserList{LoopUID: loopUID, LoopUserName: "first", SecretHash: "first"}); err != nil {
+			t.Fatal(err)
+		}
+	}}
+
+	recorder, response := ts.doRequest(t, http.MethodPost, "/app/register", UserPost{LoopUID: loopUID, LoopUserName: "second"}, nil)
+	if recorder.Code != http.StatusBadRequest || response.Code != httpserver.USER_ALREADY_REGISTERED.Code {
+		t.Errorf("the second registration should be refused, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	user, err := ts.memory.GetAppUserByUid(loopUID)
+	if err != nil || user.LoopUserName != "first" {
+		t.Errorf("the first registration should be kept, got %+v %v", user, err)
+	}
+}
+
+func TestRefreshTokenIsSingleUse(t *testing.T) {
+	ts := newTestServer(t)
+	recorder, response := ts.doRequest(t, http.MethodPost, "/app/register", UserPost{LoopUID: strings.Repeat("u", 64), LoopUserName: "tester"}, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("register failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var registered AppUserTokens
+	if err := json.Unmarshal(response.Payload, &registered); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder, response = ts.doRequest(t, http.MethodPost, "/app/token/refresh", RefreshTokenPost{RefreshToken: registered.RefreshToken}, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("refresh failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var refreshed auth.TokenPair
+	if err := json.Unmarshal(response.Payload, &refreshed); err != nil {
+		t.Fatal(err)
+	}
+	recorder, response = ts.doRequest(t, http.MethodPost, "/app/token/refresh", RefreshTokenPost{RefreshToken: registered.RefreshToken}, nil)
+	if recorder.Code != http.StatusUnauthorized || response.Code != httpserver.NOT_AUTHORIZED.Code {
+		t.Errorf("a used refresh token should be refused, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Logging out with a refresh token used already still succeeds
+	recorder, _ = ts.doRequest(t, http.MethodDelete, "/app/logout", RefreshTokenPost{RefreshToken: registered.RefreshToken},
+		map[string]string{"Authorization": "Bearer " + refreshed.AccessToken})
+	if recorder.Code != http.StatusOK {
+		t.Errorf("logout failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestAppUserFavouritesClub(t *testing.T) {
+	ts := newTestServer(t)
+	clubID := ts.seedPublishedClubs(t, map[string]string{"Chess Club": ""})["Chess Club"]
+	userHeader := ts.registerAppUserForTest(t, strings.Repeat("a", 64))
+
+	recorder, _ := ts.doRequest(t, http.MethodPut, "/app/favourite/unknown", nil, userHeader)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("favouring an unknown club should fail, got %d", recorder.Code)
+	}
+	recorder, _ = ts.doRequest(t, http.MethodPut, "/app/favourite/"+clubID, nil, userHeader)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("favouring club failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	_, response := ts.doRequest(t, http.MethodGet, "/app/favourite", nil, userHeader)
+	var favourites []ClubInfoPost
+	if err := json.Unmarshal(response.Payload, &favourites); err != nil {
+		t.Fatal(err)
+	}
+	if len(favourites) != 1 || favourites[0].ClubID != clubID {
+		t.Fatalf("club should be listed as favourite, got %+v", favourites)
+	}
+
+	ts.doRequest(t, http.MethodPut, "/app/unfavourite/"+clubID, nil, userHeader)
+	_, response = ts.doRequest(t, http.MethodGet, "/app/favourite", nil, userHeader)
+	favourites = nil
+	if err := json.Unmarshal(response.Payload, &favourites); err != nil {
+		t.Fatal(err)
+	}
+	if len(favourites) != 0 {
+		t.Errorf("no club should be favourite after unfavouring, got %+v", favourites)
+	}
+
+	count, err := ts.memory.GetClubInfoCountByClubId(clubID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.FavouriteNum != 1 {
+		t.Errorf("favourite should be counted once, got %d", count.FavouriteNum)
+	}
+
+	recorder, _ = ts.doRequest(t, http.MethodGet, "/app/favourite", nil, nil)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("requests without token should be refused, got %d", recorder.Code)
+	}
+}
+
+func TestUnreadViewListIsRanked(t *testing.T) {
+	ts := newTestServer(t)
+	noExploration := 0.0
+	ts.clubRanker = newClubRanker(config.Ranking{ExplorationRatio: &noExploration})
+	clubIDs := ts.seedPublishedClubs(t, map[string]string{"debate": "speech", "football": "sports", "basketball": "sports"})
+	userHeader := ts.registerAppUserForTest(t, strings.Repeat("b", 64))
+	ts.doRequest(t, http.MethodPut, "/app/favourite/"+clubIDs["football"], nil, userHeader)
+
+	page := ts.getUnreadPage(t, userHeader, "")
+	names := make([]string, 0)
+	for _, club := range page.Clubs {
+		names = append(names, club.Name)
+	}
+	if strings.Join(names, ",") != "football,basketball,debate" {
+		t.Errorf("clubs of the favourite tag should come first, got %v", names)
+	}
+	if page.NextCursor != "" {
+		t.Errorf("the only page should have no cursor, got %q", page.NextCursor)
+	}
+}
+
+func TestUnreadViewListPages(t *testing.T) {
+	ts := newTestServer(t)
+	noExploration := 0.0
+	ts.clubRanker = newClubRanker(config.Ranking{ExplorationRatio: &noExploration})
+	ts.seedPublishedClubs(t, map[string]string{"chess": "chess", "debate": "debate", "drama": "drama", "football": "football", "rowing": "rowing"})
+	userHeader := ts.registerAppUserForTest(t, strings.Repeat("c", 64))
+
+	getPage := func(query string) ([]string, string) {
+		page := ts.getUnreadPage(t, userHeader, query)
+		ids := make([]string, 0)
+		for _, club := range page.Clubs {
+			ids = append(ids, club.ClubID)
+		}
+		return ids, page.NextCursor
+	}
+
+	first, cursor := getPage("?limit=2")
+	if len(first) != 2 || cursor == "" {
+		t.Fatalf("expected a full first page with a cursor, got %v %q", first, cursor)
+	}
+	// Neither favouring a club nor reading one reorders the rest of the view list
+	ts.doRequest(t, http.MethodPut, "/app/favourite/"+first[1], nil, userHeader)
+	all, _ := getPage("?limit=100")
+	ts.doRequest(t, http.MethodPut, "/app/viewlist/markread", ClubIDRequest{ClubId: all[3]}, userHeader)
+
+	seen := append([]string(nil), first...)
+	for cursor != "" {
+		var ids []string
+		ids, cursor = getPage("?limit=2&cursor=" + cursor)
+		seen = append(seen, ids...)
+	}
+	expected := append(append([]string(nil), all[:3]...), all[4:]...)
+	if strings.Join(seen, ",") != strings.Join(expected, ",") {
+		t.Errorf("pages should follow the order of the view list without the read club, expected %v, got %v", expected, seen)
+	}
+
+	recorder, _ := ts.doRequest(t, http.MethodGet, "/app/viewlist/unreadlist?cursor=broken", nil, userHeader)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("a broken cursor should be rejected, got %d", recorder.Code)
+	}
+	recorder, _ = ts.doRequest(t, http.MethodGet, "/app/viewlist/unreadlist?limit=0", nil, userHeader)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("a page size of zero should be rejected, got %d", recorder.Code)
+	}
+	recorder, _ = ts.doRequest(t, http.MethodGet, "/app/viewlist/unreadlist?cursor="+encodeViewListCursor("former", 2), nil, userHeader)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("a cursor of another view list should be rejected, got %d", recorder.Code)
+	}
+}
+
+// Fails storing the order of view lists, like a lost database connection
+type failingViewListClubs struct {
+	db.ViewListRepository
+}
+
+func (failingViewListClubs) InsertViewListClubs(viewListID string, clubIDs []string) error {
+	return errors.New("connection lost")
+}
+
+func TestViewListOrderIsStoredOnce(t *testing.T) {
+	ts := newTestServer(t)
+	userHeader := ts.registerAppUserForTest(t, strings.Repeat("e", 64))
+	countUnread := func() int {
+		return len(ts.getUnreadPage(t, userHeader, "").Clubs)
+	}
+
+	if unread := countUnread(); unread != 0 {
+		t.Fatalf("there are no clubs yet, got %d", unread)
+	}
+	ts.seedPublishedClubs(t, map[string]string{"chess": ""})
+	// An empty view list is ordered as well, it is not ranked again
+	if unread := countUnread(); unread != 0 {
+		t.Errorf("the order of the view list should be kept, got %d clubs", unread)
+	}
+	ts.doRequest(t, http.MethodGet, "/app/viewlist/new", nil, userHeader)
+	if unread := countUnread(); unread != 1 {
+		t.Errorf("a new view list should have the club, got %d clubs", unread)
+	}
+
+	ts.repos.ViewLists = failingViewListClubs{ts.repos.ViewLists}
+	ts.doRequest(t, http.MethodGet, "/app/viewlist/new", nil, userHeader)
+	recorder, _ := ts.doRequest(t, http.MethodGet, "/app/viewlist/unreadlist", nil, userHeader)
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("failing to store the order should fail the request, got %d", recorder.Code)
+	}
+}
+
+// Counts the loads of all tag relationships, which the ranking signals are built from
+type countingTagRelationships struct {
+	db.ClubRepository
+	loads int
+}
+
+func (c *countingTagRelationships) GetAllTagRelationships() ([]db.ClubTagRelationship, error) {
+	c.loads++
+	return c.ClubRepository.GetAllTagRelationships()
+}
+
+func TestRankingSignalsAreCached(t *testing.T) {
+	ts := newTestServer(t)
+	clubs := &countingTagRelationships{ClubRepository: ts.repos.Clubs}
+	ts.repos.Clubs = clubs
+	ts.seedPublishedClubs(t, map[string]string{"chess": "board games", "debate": "speech"})
+	for _, loopUID := range []string{strings.Repeat("g", 64), strings.Repeat("h", 64)} {
+		userHeader := ts.registerAppUserForTest(t, loopUID)
+		ts.getUnreadPage(t, userHeader, "")
+		ts.doRequest(t, http.MethodGet, "/app/viewlist/new", nil, userHeader)
+		ts.getUnreadPage(t, userHeader, "")
+	}
+	if clubs.loads != 1 {
+		t.Errorf("the signals should be loaded once for all view lists, got %d loads", clubs.loads)
+	}
+
+	ts.rankingSignals = newRankingSignalsCache(config.Ranking{SignalsTTL: time.Nanosecond})
+	userHeader := ts.registerAppUserForTest(t, strings.Repeat("i", 64))
+	ts.getUnreadPage(t, userHeader, "")
+	time.Sleep(time.Millisecond)
+	ts.doRequest(t, http.MethodGet, "/app/viewlist/new", nil, userHeader)
+	ts.getUnreadPage(t, userHeader, "")
+	if clubs.loads != 3 {
+		t.Errorf("expired signals should be loaded again, got %d loads", clubs.loads)
+	}
+}
+
+func TestSwipeActions(t *testing.T) {
+	ts := newTestServer(t)
+	clubIDs := ts.seedPublishedClubs(t, map[string]string{"chess": "", "debate": "", "drama": ""})
+	loopUID := strings.Repeat("d", 64)
+	userHeader := ts.registerAppUserForTest(t, loopUID)
+	unreadNames := func() string {
+		names := make([]string, 0)
+		for _, club := range ts.getUnreadPage(t, userHeader, "").Clubs {
+			names = append(names, club.Name)
+		}
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+	unreadNames()
+
+	recorder, _ := ts.doRequest(t, http.MethodPut, "/app/viewlist/swipe", SwipePost{ClubId: clubIDs["chess"], Action: "DISLIKE"}, userHeader)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("unknown actions should be rejected, got %d", recorder.Code)
+	}
+	// Repeating a swipe changes nothing
+	for i := 0; i < 2; i++ {
+		recorder, _ = ts.doRequest(t, http.MethodPut, "/app/viewlist/swipe", SwipePost{ClubId: clubIDs["chess"], Action: db.SWIPE_LIKE}, userHeader)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("swipe failed with %d: %s", recorder.Code, recorder.Body.String())
+		}
+	}
+	count, err := ts.memory.GetClubInfoCountByClubId(clubIDs["chess"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.FavouriteNum != 1 || count.ViewNum != 1 {
+		t.Errorf("a repeated like should count once, got %+v", count)
+	}
+	ts.doRequest(t, http.MethodPut, "/app/viewlist/swipe", SwipePost{ClubId: clubIDs["debate"], Action: db.SWIPE_PASS}, userHeader)
+	ts.doRequest(t, http.MethodPut, "/app/viewlist/swipe", SwipePost{ClubId: clubIDs["drama"], Action: db.SWIPE_SKIP}, userHeader)
+	if names := unreadNames(); names != "" {
+		t.Errorf("swiped clubs should be read, got %v", names)
+	}
+
+	// Passed clubs stay hidden in new view lists until the cooldown is over
+	ts.doRequest(t, http.MethodGet, "/app/viewlist/new", nil, userHeader)
+	if names := unreadNames(); names != "chess,drama" {
+		t.Errorf("the passed club should be hidden, got %v", names)
+	}
+	ts.config.Ranking.PassCooldown = time.Nanosecond
+	ts.doRequest(t, http.MethodGet, "/app/viewlist/new", nil, userHeader)
+	if names := unreadNames(); names != "chess,debate,drama" {
+		t.Errorf("the passed club should be back after the cooldown, got %v", names)
+	}
+	favourites, err := ts.memory.GetUserFavouritesByUID(loopUID)
+	if err != nil || len(favourites) != 1 || favourites[0].ClubID != clubIDs["chess"] {
+		t.Errorf("only the liked club should be favourite, got %+v %v", favourites, err)
+	}
+}
+
+func TestViewListEventBatch(t *testing.T) {
+	ts := newTestServer(t)
+	clubIDs := ts.seedPublishedClubs(t, map[string]string{"chess": "", "debate": ""})
+	loopUID := strings.Repeat("e", 64)
+	userHeader := ts.registerAppUserForTest(t, loopUID)
+	ts.getUnreadPage(t, userHeader, "")
+
+	now := time.Now()
+	batch := ViewListEventsPost{
+		ClientEventID: "batch-1",
+		Events: []ViewListEvent{
+			// Sent out of order, the pass is the latest swipe on chess
+			{ClientEventID: "e1", ClubID: clubIDs["chess"], Action: db.SWIPE_PASS, ClientTimestamp: now.Add(-time.Second)},
+			{ClientEventID: "e2", ClubID: clubIDs["chess"], Action: db.SWIPE_LIKE, ClientTimestamp: now.Add(-2 * time.Second)},
+			{ClientEventID: "e3", ClubID: clubIDs["debate"], Action: "READ", ClientTimestamp: now},
+			{ClientEventID: "e4", ClubID: clubIDs["debate"], Action: "DISLIKE", ClientTimestamp: now},
+			{ClientEventID: "e5", ClubID: "unknown", Action: db.SWIPE_LIKE, ClientTimestamp: now},
+			{ClientEventID: "e6", ClubID: clubIDs["debate"], Action: db.SWIPE_LIKE, ClientTimestamp: now.Add(time.Hour)},
+			{ClubID: clubIDs["debate"], Action: db.SWIPE_LIKE, ClientTimestamp: now},
+			{ClientEventID: "e3", ClubID: clubIDs["debate"], Action: "READ", ClientTimestamp: now},
+		},
+	}
+	var responses [2]ViewListEventsResponse
+	for i := range responses {
+		recorder, response := ts.doRequest(t, http.MethodPost, "/app/viewlist/events", batch, userHeader)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("batch failed with %d: %s", recorder.Code, recorder.Body.String())
+		}
+		if err := json.Unmarshal(response.Payload, &responses[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	statuses := make([]string, 0)
+	for _, result := range responses[0].Results {
+		statuses = append(statuses, result.Status)
+	}
+	if strings.Join(statuses, ",") != "OK,OK,OK,INVALID_ACTION,UNKNOWN_CLUB,INVALID_TIMESTAMP,INVALID_EVENT_ID,DUPLICATE" {
+		t.Errorf("unexpected results %v", statuses)
+	}
+	if responses[0].Replayed || !responses[1].Replayed || len(responses[1].Results) != len(statuses) {
+		t.Errorf("the second submission should replay the first, got %+v", responses[1])
+	}
+
+	// Events applied before are not applied again in another batch, nor is a swipe made before the stored one
+	recorder, response := ts.doRequest(t, http.MethodPost, "/app/viewlist/events", ViewListEventsPost{
+		ClientEventID: "batch-2",
+		Events: []ViewListEvent{
+			{ClientEventID: "e3", ClubID: clubIDs["debate"], Action: "READ", ClientTimestamp: now},
+			{ClientEventID: "e7", ClubID: clubIDs["chess"], Action: db.SWIPE_LIKE, ClientTimestamp: now.Add(-3 * time.Second)},
+		},
+	}, userHeader)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("batch failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var resent ViewListEventsResponse
+	if err := json.Unmarshal(response.Payload, &resent); err != nil {
+		t.Fatal(err)
+	}
+	if resent.Replayed || len(resent.Results) != 2 || resent.Results[0].Status != EVENT_RESULT_DUPLICATE ||
+		resent.Results[1].Status != EVENT_RESULT_OK {
+		t.Errorf("the resent event should be a duplicate, got %+v", resent)
+	}
+
+	viewList, err := ts.memory.GetLatestViewListByUID(loopUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swipe, err := ts.memory.GetSwipeAction(viewList.ViewListID, clubIDs["chess"])
+	if err != nil || swipe.Action != db.SWIPE_PASS {
+		t.Errorf("the latest swipe should win, got %+v %v", swipe, err)
+	}
+	for name, clubID := range clubIDs {
+		count, err := ts.memory.GetClubInfoCountByClubId(clubID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count.ViewNum != 1 {
+			t.Errorf("%s should be read once, got %d", name, count.ViewNum)
+		}
+	}
+
+	recorder, _ = ts.doRequest(t, http.MethodPost, "/app/viewlist/events", ViewListEventsPost{ClientEventID: "batch-3"}, userHeader)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("an empty batch should be rejected, got %d", recorder.Code)
+	}
+}
+
+func TestMarkReadCountsViewersAndImpressions(t *testing.T) {
+	ts := newTestServer(t)
+	_, adminAuthString := ts.seedAccount(t, db.ROLE_SUPER_ADMIN)
+	adminCookie := ts.loginAdmin(t, adminAuthString)
+	clubID := ts.seedPublishedClubs(t, map[string]string{"Chess Club": ""})["Chess Club"]
+	userHeader := ts.registerAppUserForTest(t, strings.Repeat("f", 64))
+
+	// A double tap is counted once, the club read in the next view list again
+	ts.getUnreadPage(t, userHeader, "")
+	for i := 0; i < 2; i++ {
+		recorder, _ := ts.doRequest(t, http.MethodPut, "/app/viewlist/markread", ClubIDRequest{ClubId: clubID}, userHeader)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("mark read failed with %d: %s", recorder.Code, recorder.Body.String())
+		}
+	}
+	ts.doRequest(t, http.MethodGet, "/app/viewlist/new", nil, userHeader)
+	ts.doRequest(t, http.MethodPut, "/app/viewlist/markread", ClubIDRequest{ClubId: clubID}, userHeader)
+
+	recorder, response := ts.doRequest(t, http.MethodGet, "/admin/clubinfo?club_id="+clubID, nil, adminCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("getting club failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var clubInfo ClubInfoCountPost
+	if err := json.Unmarshal(response.Payload, &clubInfo); err != nil {
+		t.Fatal(err)
+	}
+	if clubInfo.ViewNum != 2 || clubInfo.UniqueViewerNum != 1 {
+		t.Errorf("expected 2 impressions by 1 viewer, got %d and %d", clubInfo.ViewNum, clubInfo.UniqueViewerNum)
+	}
+}
+
+// Runs takeIntoUse after unused pictures are found, like a manager using one of them meanwhile
+type racingUnusedPictures struct {
+	db.PictureRepository
+	takeIntoUse func()
+}
+
+func (r *racingUnusedPictures) GetUnusedPicturesCreatedBefore(before time.Time) ([]db.AccountPicture, error) {
+	pictures, err := r.PictureRepository.GetUnusedPicturesCreatedBefore(before)
+	r.takeIntoUse()
+	return pictures, err
+}
+
+func TestCollectUnusedPicturesKeepsPicturesTakenIntoUse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pictures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ts := newTestServer(t)
+	ts.blobStore = storage.NewLocalStore(dir)
+	ts.pictureCache = cache.NewLRU(10, PICTURE_CACHE_TTL)
+	account, _ := ts.seedAccount(t, db.ROLE_CLUB_MANAGER)
+	for _, pictureID := range []string{"taken", "unused"} {
+		picture := db.AccountPicture{AccountID: account.AccountID, ClubID: account.ClubID, PictureID: pictureID,
+			PictureName: pictureID + ".png", MimeType: "image/png"}
+		if err := ts.memory.InsertPicture(&picture); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.blobStore.Put(picture.PictureName, []byte(pictureID), "image/png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts.repos.Pictures = &racingUnusedPictures{PictureRepository: ts.repos.Pictures, takeIntoUse: func() {
+		club := db.ClubInfo{ClubID: account.ClubID, Name: "Chess Club", LogoID: "taken"}
+		if err := ts.memory.UpdateClubInfo(&club, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}}
+
+	if err := ts.collectUnusedPictures(time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.memory.GetPictureById("taken"); err != nil {
+		t.Errorf("the picture taken into use should be kept, got %v", err)
+	}
+	if _, err := ts.blobStore.Stat("taken.png"); err != nil {
+		t.Errorf("the file of the picture taken into use should be kept, got %v", err)
+	}
+	if _, err := ts.memory.GetPictureById("unused"); err == nil {
+		t.Error("the unused picture should be deleted")
+	}
+	if _, err := ts.blobStore.Stat("unused.png"); err == nil {
+		t.Error("the file of the unused picture should be deleted")
+	}
+}
+
+func TestRemovedPicturesAreNotServedFromCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pictures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ts := newTestServer(t)
+	ts.blobStore = storage.NewLocalStore(dir)
+	ts.pictureCache = cache.NewLRU(10, PICTURE_CACHE_TTL)
+	_, adminAuthString := ts.seedAccount(t, db.ROLE_SUPER_ADMIN)
+	adminCookie := ts.loginAdmin(t, adminAuthString)
+	manager, managerAuthString := ts.seedAccount(t, db.ROLE_CLUB_MANAGER)
+	managerCookie := ts.loginAdmin(t, managerAuthString)
+	for _, pictureID := range []string{"deleted", "archived"} {
+		content := []byte(pictureID)
+		picture := db.AccountPicture{AccountID: manager.AccountID, ClubID: manager.ClubID, PictureID: pictureID,
+			PictureName: pictureID + ".png", MimeType: "image/png", ContentHash: hashPictureContent(content)}
+		if err := ts.memory.InsertPicture(&picture); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.blobStore.Put(picture.PictureName, content, "image/png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Pictures are not JSON, so they are requested from the router directly
+	getPicture := func(pictureID string) int {
+		recorder := httptest.NewRecorder()
+		ts.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/static/clubphoto/"+pictureID, nil))
+		return recorder.Code
+	}
+	for _, pictureID := range []string{"deleted", "archived"} {
+		if code := getPicture(pictureID); code != http.StatusOK {
+			t.Fatalf("%s should be served before, got %d", pictureID, code)
+		}
+	}
+
+	recorder, _ := ts.doRequest(t, http.MethodDelete, "/club/pictures/deleted", nil, managerCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("deleting the picture failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if code := getPicture("deleted"); code == http.StatusOK {
+		t.Error("a deleted picture should not be served")
+	}
+
+	// Deleting the last manager archives the pictures of the club
+	recorder, _ = ts.doRequest(t, http.MethodDelete, "/admin/account/"+manager.AccountID, nil, adminCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("deleting the account failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if code := getPicture("archived"); code == http.StatusOK {
+		t.Error("an archived picture should not be served")
+	}
+}
+
+func TestStaticPictureCacheHeaders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pictures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ts := newTestServer(t)
+	ts.blobStore = storage.NewLocalStore(dir)
+	ts.pictureCache = cache.NewLRU(10, PICTURE_CACHE_TTL)
+	content := []byte("picture content")
+	for _, picture := range []db.AccountPicture{
+		{PictureID: "stored", PictureName: "stored.png", MimeType: "image/png", ContentHash: hashPictureContent(content)},
+		{PictureID: "missing", PictureName: "missing.png", MimeType: "image/png", ContentHash: "lost"},
+	} {
+		picture := picture
+		if err := ts.memory.InsertPicture(&picture); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ts.blobStore.Put("stored.png", content, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pictures are not JSON, so they are requested from the router directly
+	getPicture := func(pictureID, ifNoneMatch string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/static/clubphoto/"+pictureID, nil)
+		if ifNoneMatch != "" {
+			request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		recorder := httptest.NewRecorder()
+		ts.router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := getPicture("missing", "")
+	if recorder.Code != http.StatusInternalServerError || recorder.Header().Get("Cache-Control") != "" {
+		t.Errorf("a failure should not be cached, got %d with %q", recorder.Code, recorder.Header().Get("Cache-Control"))
+	}
+
+	recorder = getPicture("stored", "")
+	etag := recorder.Header().Get("ETag")
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Cache-Control") != PICTURE_CACHE_CONTROL || etag == "" {
+		t.Fatalf("the picture should be cached, got %d with %v", recorder.Code, recorder.Header())
+	}
+	recorder = getPicture("stored", etag)
+	if recorder.Code != http.StatusNotModified || recorder.Header().Get("Cache-Control") != PICTURE_CACHE_CONTROL {
+		t.Errorf("a revalidation should keep the picture cached, got %d with %v", recorder.Code, recorder.Header())
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/static/clubphoto/stored", nil)
+	request.Header.Set("Range", "bytes=8-14")
+	recorder = httptest.NewRecorder()
+	ts.router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusPartialContent || recorder.Body.String() != "content" {
+		t.Errorf("expected the requested range, got %d %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+// Pictures uploaded before content hashes were stored get the hash of their original, whichever size is requested first.
+func TestLegacyPictureHashIsTheOriginals(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pictures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ts := newTestServer(t)
+	ts.blobStore = storage.NewLocalStore(dir)
+	ts.pictureCache = cache.NewLRU(10, PICTURE_CACHE_TTL)
+	ts.pictureVariants = picture.DefaultVariants
+	legacy := db.AccountPicture{PictureID: "legacy", PictureName: "legacy.png", MimeType: "image/png"}
+	if err := ts.memory.InsertPicture(&legacy); err != nil {
+		t.Fatal(err)
+	}
+	original, thumbnail := []byte("original content"), []byte("thumbnail content")
+	if err := ts.blobStore.Put("legacy.png", original, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	thumbnailName := picture.VariantFileName("legacy", "thumbnail", "image/png")
+	if err := ts.blobStore.Put(thumbnailName, thumbnail, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pictures are not JSON, so they are requested from the router directly
+	getPicture := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		ts.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder
+	}
+
+	recorder := getPicture("/static/clubphoto/legacy?size=thumbnail")
+	if recorder.Code != http.StatusOK || recorder.Body.String() != string(thumbnail) {
+		t.Fatalf("the thumbnail should be served, got %d %q", recorder.Code, recorder.Body.String())
+	}
+	if etag := recorder.Header().Get("ETag"); etag != `"`+hashPictureContent(thumbnail)+`"` {
+		t.Errorf("the thumbnail should be tagged with its own hash, got %s", etag)
+	}
+	stored, err := ts.memory.GetPictureById("legacy")
+	if err != nil || stored.ContentHash != "" {
+		t.Errorf("the hash of the thumbnail should not be stored as the picture's, got %+v %v", stored, err)
+	}
+
+	recorder = getPicture("/static/clubphoto/legacy")
+	if recorder.Code != http.StatusOK || recorder.Body.String() != string(original) {
+		t.Fatalf("the original should be served, got %d %q", recorder.Code, recorder.Body.String())
+	}
+	if etag := recorder.Header().Get("ETag"); etag != `"`+hashPictureContent(original)+`"` {
+		t.Errorf("the original should be tagged with its hash, got %s", etag)
+	}
+	stored, err = ts.memory.GetPictureById("legacy")
+	if err != nil || stored.ContentHash != hashPictureContent(original) {
+		t.Errorf("the hash of the original should be stored, got %+v %v", stored, err)
+	}
+}
+
+func TestClubInviteNeedsMail(t *testing.T) {
+	ts := newTestServer(t)
+	_, authString := ts.seedAccount(t, db.ROLE_CLUB_MANAGER)
+	cookie := ts.loginAdmin(t, authString)
+
+	recorder, response := ts.doRequest(t, http.MethodPost, "/club/managers/invite", InvitePost{Email: "invitee@example.com"}, cookie)
+	if recorder.Code != http.StatusServiceUnavailable || response.Code != httpserver.MAIL_NOT_CONFIGURED.Code {
+		t.Errorf("invites should be refused without SMTP, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestQRLogin(t *testing.T) {
+	ts := newTestServer(t)
+	account, authString := ts.seedAccount(t, db.ROLE_CLUB_MANAGER)
+	deviceCookie := ts.loginAdmin(t, authString)
+	createChallenge := func() QRChallengeResponse {
+		recorder, response := ts.doRequest(t, http.MethodPost, "/login/qr", nil, nil)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("creating challenge failed with %d: %s", recorder.Code, recorder.Body.String())
+		}
+		var challenge QRChallengeResponse
+		if err := json.Unmarshal(response.Payload, &challenge); err != nil {
+			t.Fatal(err)
+		}
+		return challenge
+	}
+	wait := func(challenge QRChallengeResponse, pollToken string) (*httptest.ResponseRecorder, testResponse) {
+		return ts.doRequest(t, http.MethodGet, "/login/qr/"+challenge.ChallengeID+"/wait", nil,
+			map[string]string{QR_POLL_TOKEN_HEADER: pollToken})
+	}
+
+	challenge := createChallenge()
+	recorder, _ := ts.doRequest(t, http.MethodPut, "/login/qr/"+challenge.ChallengeID+"/approve", nil, deviceCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("approving failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	recorder, _ = ts.doRequest(t, http.MethodGet, "/login/qr/"+challenge.ChallengeID+"/wait?poll_token="+challenge.PollToken, nil, nil)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("the poll token should only be accepted in the header, got %d", recorder.Code)
+	}
+	recorder, _ = wait(challenge, "guessed")
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("a wrong poll token should be refused, got %d", recorder.Code)
+	}
+
+	recorder, response := wait(challenge, challenge.PollToken)
+	if recorder.Code != http.StatusOK || response.Code != httpserver.SUCCESS.Code {
+		t.Fatalf("waiting for the approved challenge failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var loggedIn db.AdminAccount
+	if err := json.Unmarshal(response.Payload, &loggedIn); err != nil {
+		t.Fatal(err)
+	}
+	if loggedIn.AccountID != account.AccountID || recorder.Header().Get("Set-Cookie") == "" {
+		t.Errorf("the approving account should be logged in, got %+v", loggedIn)
+	}
+	browserCookie := map[string]string{"Cookie": recorder.Header().Get("Set-Cookie")}
+	recorder, _ = ts.doRequest(t, http.MethodGet, "/club/managers", nil, browserCookie)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("the browser session should be logged in, got %d", recorder.Code)
+	}
+
+	// A challenge logs in once
+	recorder, response = wait(challenge, challenge.PollToken)
+	if recorder.Code != http.StatusBadRequest || response.Code != httpserver.LOGIN_CHALLENGE_EXPIRED.Code {
+		t.Errorf("a consumed challenge should be refused, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	ts.config.AdminAuth.QRChallengeTTL = time.Nanosecond
+	expired := createChallenge()
+	time.Sleep(time.Millisecond)
+	recorder, response = ts.doRequest(t, http.MethodPut, "/login/qr/"+expired.ChallengeID+"/approve", nil, deviceCookie)
+	if recorder.Code != http.StatusBadRequest || response.Code != httpserver.LOGIN_CHALLENGE_EXPIRED.Code {
+		t.Errorf("an expired challenge should not be approved, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	recorder, response = wait(expired, expired.PollToken)
+	if recorder.Code != http.StatusBadRequest || response.Code != httpserver.LOGIN_CHALLENGE_EXPIRED.Code {
+		t.Errorf("an expired challenge should be refused, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	histories, err := ts.memory.GetLoginHistoryByCondition(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, history := range histories {
+		if strings.Contains(history.HeaderDump, challenge.PollToken) {
+			t.Errorf("the poll token should not be recorded, got %q", history.HeaderDump)
+		}
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	ts := newTestServer(t)
+	cookies := make(map[string]map[string]string)
+	for _, role := range []string{db.ROLE_SUPER_ADMIN, db.ROLE_MODERATOR, db.ROLE_AUDITOR, db.ROLE_CLUB_MANAGER} {
+		_, authString := ts.seedAccount(t, role)
+		cookies[role] = ts.loginAdmin(t, authString)
+	}
+
+	// Requests only get past the middleware with the permission, invalid parameters do not matter
+	routes := []struct {
+		method, path string
+		allowed      []string
+	}{
+		{http.MethodGet, "/admin/account/all", []string{db.ROLE_SUPER_ADMIN, db.ROLE_MODERATOR, db.ROLE_AUDITOR}},
+		{http.MethodPost, "/admin/account/create", []string{db.ROLE_SUPER_ADMIN}},
+		{http.MethodPut, "/admin/account/unknown/role", []string{db.ROLE_SUPER_ADMIN}},
+		{http.MethodGet, "/admin/loginhistory", []string{db.ROLE_SUPER_ADMIN, db.ROLE_AUDITOR}},
+		{http.MethodGet, "/admin/clubinfo/all", []string{db.ROLE_SUPER_ADMIN, db.ROLE_MODERATOR, db.ROLE_AUDITOR}},
+		{http.MethodPut, "/admin/clubinfo", []string{db.ROLE_SUPER_ADMIN, db.ROLE_MODERATOR}},
+		{http.MethodGet, "/club/pictures", []string{db.ROLE_CLUB_MANAGER}},
+	}
+	for _, route := range routes {
+		for role, cookie := range cookies {
+			allowed := false
+			for _, allowedRole := range route.allowed {
+				allowed = allowed || allowedRole == role
+			}
+			recorder, response := ts.doRequest(t, route.method, route.path, nil, cookie)
+			refused := recorder.Code == http.StatusForbidden && response.Code == httpserver.NO_PERMISSION.Code
+			if refused == allowed {
+				t.Errorf("%s %s as %s: expected allowed %v, got %d: %s", route.method, route.path, role, allowed,
+					recorder.Code, recorder.Body.String())
+			}
+		}
+
+		recorder, _ := ts.doRequest(t, route.method, route.path, nil, nil)
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without session: expected 401, got %d", route.method, route.path, recorder.Code)
+		}
+	}
+
+	// Accounts can not grant themselves permissions either
+	account, authString := ts.seedAccount(t, db.ROLE_AUDITOR)
+	cookie := ts.loginAdmin(t, authString)
+	recorder, _ := ts.doRequest(t, http.MethodPut, "/admin/account/"+account.AccountID+"/role", RolePost{Role: db.ROLE_SUPER_ADMIN}, cookie)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("an auditor should not assign itself a role, got %d", recorder.Code)
+	}
+}
+
+func TestClubOwnershipIsEnforced(t *testing.T) {
+	ts := newTestServer(t)
+	owner, ownerAuthString := ts.seedAccount(t, db.ROLE_CLUB_MANAGER)
+	ownerCookie := ts.loginAdmin(t, ownerAuthString)
+	other, _ := ts.seedAccount(t, db.ROLE_CLUB_MANAGER)
+	otherPicture := db.AccountPicture{AccountID: other.AccountID, ClubID: other.ClubID, PictureID: "other-picture"}
+	if err := ts.memory.InsertPicture(&otherPicture); err != nil {
+		t.Fatal(err)
+	}
+
+	// An editor invited to the club of the owner
+	inviteToken := genAuthString()
+	invite := db.ClubInvite{InviteHash: ts.hashAuthString(inviteToken), ClubID: owner.ClubID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := ts.memory.InsertClubInvite(&invite); err != nil {
+		t.Fatal(err)
+	}
+	editorAuthString := genAuthString()
+	editor := db.AdminAccount{
+		AccountID: uuid.New().String(),
+		AuthHash:  ts.hashAuthString(editorAuthString),
+		ClubID:    owner.ClubID,
+		Role:      db.ROLE_CLUB_MANAGER,
+	}
+	if accepted, err := ts.memory.AcceptClubInvite(invite.InviteHash, &editor, time.Now()); err != nil || !accepted {
+		t.Fatalf("accepting invite failed: %v %v", accepted, err)
+	}
+	editorCookie := ts.loginAdmin(t, editorAuthString)
+
+	expectForbidden := func(name string, recorder *httptest.ResponseRecorder, response testResponse) {
+		if recorder.Code != http.StatusForbidden || response.Code != httpserver.FORBIDDEN.Code {
+			t.Errorf("%s should be forbidden, got %d: %s", name, recorder.Code, recorder.Body.String())
+		}
+	}
+	recorder, response := ts.doRequest(t, http.MethodPost, "/club/info", ClubInfoPost{ClubID: other.ClubID, Name: "Taken over"}, ownerCookie)
+	expectForbidden("updating another club", recorder, response)
+	if clubInfo, err := ts.memory.GetClubInfoByClubId(other.ClubID); err == nil && clubInfo.Name == "Taken over" {
+		t.Error("the other club should be unchanged")
+	}
+	recorder, response = ts.doRequest(t, http.MethodPost, "/club/managers/invite", InvitePost{Email: "invitee@example.com"}, editorCookie)
+	expectForbidden("an editor inviting", recorder, response)
+	recorder, response = ts.doRequest(t, http.MethodDelete, "/club/managers/"+owner.AccountID, nil, editorCookie)
+	expectForbidden("an editor revoking", recorder, response)
+	recorder, response = ts.doRequest(t, http.MethodDelete, "/club/managers/"+owner.AccountID, nil, ownerCookie)
+	expectForbidden("revoking the owner", recorder, response)
+
+	// Pictures of another club can not be used, changed or deleted
+	for name, post := range map[string]ClubInfoPost{
+		"gallery": {ClubID: owner.ClubID, Name: "Chess Club", PictureIds: []string{otherPicture.PictureID}},
+		"logo":    {ClubID: owner.ClubID, Name: "Chess Club", LogoId: otherPicture.PictureID},
+	} {
+		recorder, _ = ts.doRequest(t, http.MethodPost, "/club/info", post, ownerCookie)
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("a picture of another club should not be used as %s, got %d", name, recorder.Code)
+		}
+	}
+	recorder, response = ts.doRequest(t, http.MethodPut, "/club/pictures/"+otherPicture.PictureID, ClubPictureTextPost{Caption: "mine"}, ownerCookie)
+	if recorder.Code != http.StatusBadRequest || response.Code != httpserver.INVALID_PICTURE_ID.Code {
+		t.Errorf("a picture of another club should not be changed, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	recorder, response = ts.doRequest(t, http.MethodDelete, "/club/pictures/"+otherPicture.PictureID, nil, ownerCookie)
+	if recorder.Code != http.StatusBadRequest || response.Code != httpserver.INVALID_PICTURE_ID.Code {
+		t.Errorf("a picture of another club should not be deleted, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if _, err := ts.memory.GetPictureById(otherPicture.PictureID); err != nil {
+		t.Errorf("the picture of the other club should be kept, got %v", err)
+	}
+
+	// The editor manages the club of the owner
+	recorder, _ = ts.doRequest(t, http.MethodPost, "/club/info", ClubInfoPost{ClubID: owner.ClubID, Name: "Chess Club"}, editorCookie)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("an editor should update the club, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestSuspendAndReactivateAccount(t *testing.T) {
+	ts := newTestServer(t)
+	_, adminAuthString := ts.seedAccount(t, db.ROLE_SUPER_ADMIN)
+	adminCookie := ts.loginAdmin(t, adminAuthString)
+	account, authString := ts.seedAccount(t, db.ROLE_CLUB_MANAGER)
+	managerCookie := ts.loginAdmin(t, authString)
+
+	recorder, _ := ts.doRequest(t, http.MethodPut, "/admin/account/"+account.AccountID+"/suspend", nil, adminCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("suspending failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	recorder, _ = ts.doRequest(t, http.MethodGet, "/authorized", nil, managerCookie)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("sessions of a suspended account should be revoked, got %d", recorder.Code)
+	}
+	recorder, response := ts.doRequest(t, http.MethodPost, "/login", LoginPost{AuthToken: authString}, nil)
+	if recorder.Code != http.StatusUnauthorized || response.Code != httpserver.ACCOUNT_SUSPENDED.Code {
+		t.Errorf("a suspended account should not log in, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder, _ = ts.doRequest(t, http.MethodPut, "/admin/account/"+account.AccountID+"/reactivate", nil, adminCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("reactivating failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	managerCookie = ts.loginAdmin(t, authString)
+	recorder, _ = ts.doRequest(t, http.MethodGet, "/authorized", nil, managerCookie)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("a reactivated account should log in, got %d", recorder.Code)
+	}
+}
+
+func TestAccountLifecycleNeedsSuperAdmin(t *testing.T) {
+	ts := newTestServer(t)
+	target, targetAuthString := ts.seedAccount(t, db.ROLE_CLUB_MANAGER)
+	for _, role := range []string{db.ROLE_MODERATOR, db.ROLE_AUDITOR, db.ROLE_CLUB_MANAGER} {
+		_, authString := ts.seedAccount(t, role)
+		cookie := ts.loginAdmin(t, authString)
+		for _, route := range []struct{ method, path string }{
+			{http.MethodPut, "/admin/account/" + target.AccountID + "/suspend"},
+			{http.MethodPut, "/admin/account/" + target.AccountID + "/reactivate"},
+			{http.MethodDelete, "/admin/account/" + target.AccountID},
+		} {
+			recorder, response := ts.doRequest(t, route.method, route.path, nil, cookie)
+			if recorder.Code != http.StatusForbidden || response.Code != httpserver.NO_PERMISSION.Code {
+				t.Errorf("%s %s as %s: expected 403, got %d: %s", route.method, route.path, role, recorder.Code,
+					recorder.Body.String())
+			}
+		}
+	}
+
+	// Refused requests change nothing
+	stored, err := ts.memory.GetAccountByUserId(target.AccountID)
+	if err != nil || stored.Suspended {
+		t.Fatalf("the account should be left as it was, got %+v %v", stored, err)
+	}
+	ts.loginAdmin(t, targetAuthString)
+}
+
+func TestDeleteAccountRemovesManagersAndInvites(t *testing.T) {
+	ts := newTestServer(t)
+	_, adminAuthString := ts.seedAccount(t, db.ROLE_SUPER_ADMIN)
+	adminCookie := ts.loginAdmin(t, adminAuthString)
+	owner, _ := ts.seedAccount(t, db.ROLE_CLUB_MANAGER)
+	club := db.ClubInfo{ClubID: owner.ClubID, Name: "Chess Club", Published: true}
+	if err := ts.memory.UpdateClubInfo(&club, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	accepted := db.ClubInvite{InviteHash: "accepted", ClubID: owner.ClubID, InvitedBy: owner.AccountID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := ts.memory.InsertClubInvite(&accepted); err != nil {
+		t.Fatal(err)
+	}
+	editor := db.AdminAccount{AccountID: uuid.New().String(), AuthHash: ts.hashAuthString(genAuthString()), ClubID: owner.ClubID, Role: db.ROLE_CLUB_MANAGER}
+	if ok, err := ts.memory.AcceptClubInvite("accepted", &editor, time.Now()); err != nil || !ok {
+		t.Fatalf("accepting the invite failed: %v %v", ok, err)
+	}
+	for inviteHash, invitedBy := range map[string]string{"from-owner": owner.AccountID, "from-editor": editor.AccountID} {
+		invite := db.ClubInvite{InviteHash: inviteHash, ClubID: owner.ClubID, InvitedBy: invitedBy, ExpiresAt: time.Now().Add(time.Hour)}
+		if err := ts.memory.InsertClubInvite(&invite); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorder, _ := ts.doRequest(t, http.MethodDelete, "/admin/account/"+editor.AccountID, nil, adminCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("deleting the editor failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	managers, err := ts.memory.GetClubManagers(owner.ClubID)
+	if err != nil || len(managers) != 1 || managers[0].AccountID != owner.AccountID {
+		t.Errorf("only the owner should be left, got %+v %v", managers, err)
+	}
+	if _, err := ts.memory.GetClubInviteByHash("from-editor"); err == nil {
+		t.Error("open invites of the deleted editor should be deleted")
+	}
+	if _, err := ts.memory.GetClubInviteByHash("from-owner"); err != nil {
+		t.Errorf("open invites of the owner should be kept, got %v", err)
+	}
+
+	recorder, _ = ts.doRequest(t, http.MethodDelete, "/admin/account/"+owner.AccountID, nil, adminCookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("deleting the owner failed with %d: %s", recorder.Code, recorder.Body.String())
+	}
+	managers, err = ts.memory.GetClubManagers(owner.ClubID)
+	if err != nil || len(managers) != 0 {
+		t.Errorf("the club should have no managers left, got %+v %v", managers, err)
+	}
+	if _, err := ts.memory.GetClubInviteByHash("from-owner"); err == nil {
+		t.Error("open invites of a club without managers should be deleted")
+	}
+	clubInfo, err := ts.memory.GetClubInfoByClubId(owner.ClubID)
+	if err != nil || clubInfo.Published {
+		t.Errorf("the club should be unpublished, got %+v %v", clubInfo, err)
+	}
+}
@@ -13,19 +13,26 @@ type Response struct {
 }
 
 var SUCCESS = ResponseCode{Code: 2000, Message: "Successful!"}
+var LOGIN_PENDING = ResponseCode{Code: 2001, Message: "Waiting for login approval!"}
 
 var NO_PERMISSION = ResponseCode{Code: 3000, Message: "No permission!"}
 var NOT_AUTHORIZED = ResponseCode{Code: 3001, Message: "Not authorized!"}
+var TOKEN_EXPIRED = ResponseCode{Code: 3002, Message: "Token expired!"}
+var TOO_MANY_ATTEMPTS = ResponseCode{Code: 3003, Message: "Too many attempts, try again later!"}
+var LOGIN_CHALLENGE_EXPIRED = ResponseCode{Code: 3004, Message: "Login challenge expired!"}
+var FORBIDDEN = ResponseCode{Code: 3005, Message: "Forbidden, not the owner of this club!"}
+var ACCOUNT_SUSPENDED = ResponseCode{Code: 3006, Message: "Account suspended!"}
 
 
 var SYSTEM_ERROR = ResponseCode{Code: 5000, Message: "Server internal error!"}
 var AUTH_FAILED = ResponseCode{Code: 5001, Message: "Authentication Failed!"}
 var NOT_FOUND = ResponseCode{Code: 5002, Message: "Not found!"}
+var MAIL_NOT_CONFIGURED = ResponseCode{Code: 5003, Message: "Sending emails is not configured!"}
 
 
 var INVALID_PARAMS = ResponseCode{Code: 4000, Message: "Invalid parameters!"}
 var USER_ALREADY_REGISTERED = ResponseCode{Code: 4001, Message: "User already registered!"}
-var UPLOAD_TYPE_NOT_SUPPORTED = ResponseCode{Code: 4002, Message: "Only support jpg or jpeg picture upload!"}
+var UPLOAD_TYPE_NOT_SUPPORTED = ResponseCode{Code: 4002, Message: "Only support jpeg, png, gif or webp picture upload!"}
 var CLUB_PIC_NUM_ABOVE_LIMIT = ResponseCode{Code: 4003, Message: "Club picture number above max limit!"}
 var CLUB_TAG_NUM_ABOVE_LIMIT = ResponseCode{Code: 4004, Message: "Club tag number above max limit!"}
 var INVALID_PICTURE_ID = ResponseCode{Code: 4005, Message: "Invalid picture id!"}
@@ -34,6 +41,10 @@ var WEB_SITE_TOO_LONG = ResponseCode{Code: 4007, Message: "Web site length above
 var EMAIL_TOO_LONG = ResponseCode{Code: 4008, Message: "Email length above max limit 100 char!"}
 var DESC_TOO_LONG = ResponseCode{Code: 4009, Message: "Description length above max limit 3000 char!"}
 var VIDEO_LINK_TOO_LONG = ResponseCode{Code: 4010, Message: "Video link length above max limit 300 char!"}
+var INVITE_INVALID = ResponseCode{Code: 4011, Message: "Invite invalid, used or expired!"}
+var PICTURE_IN_USE = ResponseCode{Code: 4012, Message: "Picture is used by the club, remove it from the club first!"}
+var PIC_DIMENSIONS_TOO_LARGE = ResponseCode{Code: 4013, Message: "Picture width or height above max limit!"}
+var PIC_ANIMATED = ResponseCode{Code: 4014, Message: "Animated pictures are not supported!"}
 
 
 func ConstructResponse(code ResponseCode, payload interface{}) Response {
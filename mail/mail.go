@@ -0,0 +1,49 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"tinder-for-clubs-backend/config"
+)
+
+// Returned by Send when no SMTP server is set up
+var ErrNotConfigured = errors.New("smtp not configured")
+
+// Mailer sends plain text emails through the configured SMTP server.
+type Mailer struct {
+	conf config.Mail
+}
+
+func NewMailer(conf config.Mail) *Mailer {
+	return &Mailer{conf: conf}
+}
+
+// Configured reports whether an SMTP server is set up.
+func (m *Mailer) Configured() bool {
+	return m.conf.SMTPHost != ""
+}
+
+func (m *Mailer) Send(to, subject, body string) error {
+	if !m.Configured() {
+		return ErrNotConfigured
+	}
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("invalid mail header")
+	}
+
+	msg := "From: " + m.conf.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body
+
+	var auth smtp.Auth
+	if m.conf.SMTPUser != "" {
+		auth = smtp.PlainAuth("", m.conf.SMTPUser, m.conf.SMTPPass, m.conf.SMTPHost)
+	}
+	addr := fmt.Sprintf("%v:%v", m.conf.SMTPHost, m.conf.SMTPPort)
+	return smtp.SendMail(addr, auth, m.conf.From, []string{to}, []byte(msg))
+}
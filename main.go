@@ -1,131 +1,347 @@
 package main
 
 import (
+	"crypto/sha256"
 	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/gob"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	set "github.com/deckarep/golang-set"
 	"github.com/gin-contrib/secure"
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/memstore"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	gsessions "github.com/gorilla/sessions"
 	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	log "github.com/sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+	"hash/fnv"
+	"image"
 	"io"
+	"io/ioutil"
 	"math/rand"
+	"mime/multipart"
+	"net"
 	"net/http"
-	"os"
-	"path"
-	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"tinder-for-clubs-backend/auth"
+	"tinder-for-clubs-backend/cache"
 	"tinder-for-clubs-backend/common"
 	"tinder-for-clubs-backend/config"
 	"tinder-for-clubs-backend/db"
 	"tinder-for-clubs-backend/httpserver"
+	"tinder-for-clubs-backend/mail"
+	"tinder-for-clubs-backend/picture"
+	"tinder-for-clubs-backend/ranking"
+	"tinder-for-clubs-backend/sessionstore"
+	"tinder-for-clubs-backend/storage"
 )
 
-var router *gin.Engine
-var globalConfig config.GlobalConfiguration
+// Dependencies of the handlers, set up by main. Tests set it up with in-memory repositories instead.
+type server struct {
+	config          config.GlobalConfiguration
+	sessionStore    *sessionstore.Store
+	tokenIssuer     *auth.TokenIssuer
+	loginThrottle   *auth.LoginThrottle
+	trustedProxies  []*net.IPNet
+	mailer          *mail.Mailer
+	pictureVariants []picture.Variant
+	blobStore       storage.BlobStore
+	pictureCache    *cache.LRU
+	clubRanker      *ranking.Ranker
+	rankingSignals  *cache.LRU
+	// Data access of the handlers
+	repos db.Repositories
+}
 
 const (
 	USER = "USER"
+	// Context keys set by the app user auth middleware
+	APP_USER         = "APP_USER"
+	APP_TOKEN_CLAIMS = "APP_TOKEN_CLAIMS"
 )
 
 func main() {
 	// Reading configuration file
-	globalConfig = config.ReadConfig()
+	s := &server{config: config.ReadConfig()}
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] != "migrate" {
+			log.Fatalf("unknown command %s", args[0])
+		}
+		s.runMigrateCommand(args[1:])
+		return
+	}
 
 	// Setting up database connection
-	db.Init(globalConfig.DBCredential)
+	db.Init(s.config.DBCredential)
+	if dbCred := s.config.DBCredential; dbCred.GetDriver() == "sqlite3" && dbCred.DBPath == ":memory:" {
+		// An in-memory database is empty on every start, there is nothing the migrate command could prepare
+		_, err := db.MigrateUp()
+		common.ErrFatalLog(err)
+	}
+	db.CheckSchema()
+	if s.config.AdminAuth.AuthHashKey == "" {
+		log.Fatal("admin-auth auth-hash-key is not configured")
+	}
+	// Needs the configured hash key, so it is not a migration
+	err := db.MigrateAuthStrings(db.DB, s.hashAuthString)
+	common.ErrFatalLog(err)
+	s.repos = db.NewGormRepositories(db.DB)
+	s.mailer = mail.NewMailer(s.config.Mail)
+	s.loginThrottle = newLoginThrottle(s.config.AdminAuth)
+	s.trustedProxies, err = auth.ParseTrustedProxies(s.config.AdminAuth.TrustedProxies)
+	common.ErrFatalLog(err)
+	s.clubRanker = newClubRanker(s.config.Ranking)
+	s.rankingSignals = newRankingSignalsCache(s.config.Ranking)
+	s.pictureVariants = newPictureVariants(s.config.General)
+	s.blobStore = newBlobStore(s.config)
+	pictureCacheSize := s.config.General.PictureCacheSize
+	if pictureCacheSize <= 0 {
+		pictureCacheSize = 10000
+	}
+	s.pictureCache = cache.NewLRU(pictureCacheSize, PICTURE_CACHE_TTL)
 
 	//Deferred Closed
 	defer db.Close()
 
 	// Initialise HTTP framework and Session Store
-	router = gin.Default()
-
-	// Initialise mem session storage
-	store := memstore.NewStore([]byte(uuid.New().String()))
-	router.Use(sessions.Sessions("AdminSession", store))
+	router := gin.Default()
 
+	// Initialise session storage
 	gob.Register(db.AdminAccount{})
+	s.sessionStore = newSessionStore(s.config.Session)
+	router.Use(sessions.Sessions("AdminSession", s.sessionStore))
 
-	initRouter(router)
-	err := router.Run() // listen and serve on 0.0.0.0:8080
+	// Initialise app user token issuer
+	s.tokenIssuer = newTokenIssuer(s.config.AppAuth)
+
+	go s.cleanupExpired()
+
+	s.initRouter(router)
+	err = router.Run() // listen and serve on 0.0.0.0:8080
 	common.ErrFatalLog(err)
 }
 
-func initRouter(engine *gin.Engine) {
+const MIGRATE_USAGE = "usage: migrate up | down [steps] | status | create <name>"
+
+// Runs the migrate command: up applies all pending migrations, down rolls back the last one or the given
+// number of migrations, status lists all migrations and create adds an empty migration to the db directory.
+func (s *server) runMigrateCommand(args []string) {
+	if len(args) == 0 {
+		log.Fatal(MIGRATE_USAGE)
+	}
+	if args[0] == "create" {
+		if len(args) != 2 {
+			log.Fatal(MIGRATE_USAGE)
+		}
+		path, err := db.CreateMigration("db", args[1], time.Now())
+		common.ErrFatalLog(err)
+		log.Printf("Created migration %s", path)
+		return
+	}
+
+	db.Init(s.config.DBCredential)
+	defer db.Close()
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp()
+		common.ErrFatalLog(err)
+		log.Printf("Applied %d migrations", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatal(MIGRATE_USAGE)
+			}
+		}
+		rolledBack, err := db.MigrateDown(steps)
+		common.ErrFatalLog(err)
+		log.Printf("Rolled back %d migrations", rolledBack)
+	case "status":
+		states, err := db.GetMigrationStates()
+		common.ErrFatalLog(err)
+		for _, state := range states {
+			appliedAt := "pending"
+			if state.AppliedAt != nil {
+				appliedAt = state.AppliedAt.Format(time.RFC3339)
+			}
+			name := state.Name
+			if name == "" {
+				name = "(unknown to this version)"
+			}
+			fmt.Printf("%d  %-25s  %s\n", state.Version, appliedAt, name)
+		}
+	default:
+		log.Fatal(MIGRATE_USAGE)
+	}
+}
+
+// Builds the session store from the configured backend.
+func newSessionStore(conf config.Session) *sessionstore.Store {
+	if conf.SecretKey == "" {
+		log.Fatal("session secret-key is not configured")
+	}
+	if conf.TTL <= 0 {
+		conf.TTL = 24 * time.Hour
+	}
+
+	var backend sessionstore.Backend
+	switch conf.Backend {
+	case "", "db":
+		backend = sessionstore.NewDBBackend(db.DB)
+	case "redis":
+		backend = sessionstore.NewRedisBackend(conf.RedisAddress, conf.RedisPassword, conf.RedisDB)
+	case "memory":
+		backend = sessionstore.NewMemoryBackend()
+	default:
+		log.Fatalf("unknown session backend %s", conf.Backend)
+	}
+	log.Printf("Using %s session backend", conf.Backend)
+
+	return sessionstore.NewStore(backend, []byte(conf.SecretKey), conf.TTL, conf.IdleTimeout, sessionOwner)
+}
+
+// Returns the account id of the admin logged in with the session.
+func sessionOwner(session *gsessions.Session) string {
+	account, ok := session.Values[USER].(db.AdminAccount)
+	if !ok {
+		return ""
+	}
+	return account.AccountID
+}
+
+func newTokenIssuer(conf config.AppAuth) *auth.TokenIssuer {
+	if conf.TokenSecret == "" {
+		log.Fatal("app-auth token-secret is not configured")
+	}
+	if conf.AccessTokenTTL <= 0 {
+		conf.AccessTokenTTL = time.Hour
+	}
+	if conf.RefreshTokenTTL <= 0 {
+		conf.RefreshTokenTTL = 30 * 24 * time.Hour
+	}
+	return auth.NewTokenIssuer([]byte(conf.TokenSecret), conf.AccessTokenTTL, conf.RefreshTokenTTL)
+}
+
+// Periodically removes expired sessions, revoked tokens that have expired anyway, stale login throttling and login challenges.
+func (s *server) cleanupExpired() {
+	for range time.Tick(time.Hour) {
+		if err := s.sessionStore.Cleanup(); err != nil {
+			log.Error(err)
+		}
+		if err := s.repos.Users.DeleteExpiredRevokedTokens(time.Now()); err != nil {
+			log.Error(err)
+		}
+		s.loginThrottle.Prune(time.Now())
+		if err := s.repos.Logins.DeleteExpiredLoginChallenges(time.Now()); err != nil {
+			log.Error(err)
+		}
+		if s.config.General.PictureGCDays > 0 {
+			maxAge := time.Duration(s.config.General.PictureGCDays) * 24 * time.Hour
+			if err := s.collectUnusedPictures(time.Now().Add(-maxAge)); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+}
+
+func (s *server) initRouter(router *gin.Engine) {
 	// Disable inline scripts
 	router.Use(secure.New(secure.Config{
 		ContentSecurityPolicy: "default-src 'self'",
 	}))
 
 	// Register Handler
-	initializeRoutes()
+	s.initializeRoutes(router)
 }
 
-func initializeRoutes() {
+func (s *server) initializeRoutes(router *gin.Engine) {
 	//Ping endpoint for testing
 	router.GET("/ping", Pong)
 
 	//For admin and club managers to login
-	router.POST("/login", Login)
-	router.DELETE("/logout", logout)
-	router.GET("/authorized", ifAuthorized)
-
-	// Admin only endpoints
-	router.POST("/admin/account/create", createNewClubAccount)
-	router.PUT("/admin/account", updateAccountInfo)
-	router.GET("/admin/account/all", listAllAccounts)
-	router.GET("/admin/account/user/:userId", getAccountByUserId)
-	router.GET("/admin/clubinfo/all", listAllClubs)
-	router.GET("/admin/clubinfo", getOneClubInfo)
-	router.PUT("/admin/clubinfo", unpublishClub)
-
-	// Club manager endpoints
-	router.GET("/account", getCurrUser)
-	router.POST("/club/uploadpicture", uploadSinglePicture)
-	router.POST("/club/info", updateClubInfo)
-	router.GET("/club/info", getSelfClubInfo)
-	router.GET("/club/tags", adminGetAllTags)
+	router.POST("/login", s.Login)
+	router.POST("/login/qr", s.createQRLoginChallenge)
+	router.GET("/login/qr/:challengeID/wait", s.waitQRLoginChallenge)
 
 	// MiniApp endpoints
-	router.GET("/static/clubphoto/:pictureID", serveStaticPicture)
-
-	// temporary bug repair api
-	router.PUT("/app/register", updateRegisterUser)
-
-	router.POST("/app/register", registerAppUser)
-	router.GET("/app/userinfo", getAppUserInfo)
-	router.GET("/app/favourite", getFavouriteClubList)
-	router.PUT("/app/favourite/:clubID", setFavouriteClub)
-	router.PUT("/app/unfavourite/:clubID", setUnfavouriteClub)
-	router.GET("/app/clubs/all", GetAllClubs)
-	router.GET("/app/tagfilter", getClubInfoOfGivenTags)
-	router.GET("/app/tages", appGetAllTags)
-	router.GET("/app/viewlist/unreadlist", getUnreadViewList)
-	router.GET("/app/viewlist/new", createNewViewList)
-	router.PUT("/app/viewlist/markread", markClubReadInViewList)
-}
-
-func unpublishClub(ctx *gin.Context) {
-	//check admin or not
-	account, err := getAdminUser(ctx)
-	if err != nil {
-		return
-	}
-	if !account.IsAdmin {
-		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.NO_PERMISSION, nil))
-		return
-	}
+	router.GET("/static/clubphoto/:pictureID", s.serveStaticPicture)
+
+	// MiniApp endpoints issuing tokens
+	router.POST("/app/register", s.registerAppUser)
+	router.POST("/app/login", s.loginAppUser)
+	router.POST("/app/token/refresh", s.refreshAppToken)
+
+	// Endpoints for any logged in account, club managers and platform staff
+	manager := router.Group("/", adminSessionAuth())
+	manager.PUT("/login/qr/:challengeID/approve", s.approveQRLoginChallenge)
+	manager.DELETE("/logout", logout)
+	manager.GET("/authorized", ifAuthorized)
+	manager.GET("/account", getCurrUser)
+	manager.GET("/club/info", s.getSelfClubInfo)
+	manager.GET("/club/tags", s.adminGetAllTags)
 
+	// Club manager endpoints
+	manager.POST("/club/uploadpicture", requirePermission(auth.PERM_CLUB_EDIT), s.uploadSinglePicture)
+	manager.GET("/club/pictures", requirePermission(auth.PERM_CLUB_EDIT), s.listClubPictures)
+	manager.PUT("/club/gallery", requirePermission(auth.PERM_CLUB_EDIT), s.reorderClubPictures)
+	manager.PUT("/club/pictures/:id", requirePermission(auth.PERM_CLUB_EDIT), s.updateClubPicture)
+	manager.DELETE("/club/pictures/:id", requirePermission(auth.PERM_CLUB_EDIT), s.deleteClubPicture)
+	manager.POST("/club/info", requirePermission(auth.PERM_CLUB_EDIT), s.updateClubInfo)
+	manager.GET("/club/managers", requirePermission(auth.PERM_CLUB_EDIT), s.listClubManagers)
+	manager.POST("/club/managers/invite", requirePermission(auth.PERM_CLUB_EDIT), s.inviteClubManager)
+	manager.DELETE("/club/managers/:accountID", requirePermission(auth.PERM_CLUB_EDIT), s.revokeClubManager)
+
+	// Invited club managers create their account
+	router.POST("/club/invite/accept", s.acceptClubInvite)
+
+	// Platform admin endpoints
+	admin := router.Group("/admin", adminSessionAuth())
+	admin.POST("/account/create", requirePermission(auth.PERM_ACCOUNT_WRITE), s.createNewClubAccount)
+	admin.PUT("/account", requirePermission(auth.PERM_ACCOUNT_WRITE), s.updateAccountInfo)
+	admin.GET("/account/all", requirePermission(auth.PERM_ACCOUNT_READ), s.listAllAccounts)
+	admin.GET("/account/user/:userId", requirePermission(auth.PERM_ACCOUNT_READ), s.getAccountByUserId)
+	admin.PUT("/account/:id/rotate-auth", requirePermission(auth.PERM_ACCOUNT_WRITE), s.rotateAccountAuthString)
+	admin.PUT("/account/:id/role", requirePermission(auth.PERM_ROLE_ASSIGN), s.setAccountRole)
+	admin.PUT("/account/:id/suspend", requirePermission(auth.PERM_ACCOUNT_WRITE), s.suspendAccount)
+	admin.PUT("/account/:id/reactivate", requirePermission(auth.PERM_ACCOUNT_WRITE), s.reactivateAccount)
+	admin.DELETE("/account/:id", requirePermission(auth.PERM_ACCOUNT_WRITE), s.deleteAccount)
+	admin.GET("/loginhistory", requirePermission(auth.PERM_LOGIN_HISTORY_READ), s.listLoginHistory)
+	admin.GET("/clubinfo/all", requirePermission(auth.PERM_CLUB_READ), s.listAllClubs)
+	admin.GET("/clubinfo", requirePermission(auth.PERM_CLUB_READ), s.getOneClubInfo)
+	admin.PUT("/clubinfo", requirePermission(auth.PERM_CLUB_MODERATE), s.unpublishClub)
+
+	// MiniApp endpoints requiring a bearer token
+	app := router.Group("/app", s.appUserAuth())
+	// temporary bug repair api, users registered with a broken uid log in with it first
+	app.PUT("/register", s.updateRegisterUser)
+
+	app.DELETE("/logout", s.logoutAppUser)
+	app.GET("/userinfo", getAppUserInfo)
+	app.GET("/favourite", s.getFavouriteClubList)
+	app.PUT("/favourite/:clubID", s.setFavouriteClub)
+	app.PUT("/unfavourite/:clubID", s.setUnfavouriteClub)
+	app.GET("/clubs/all", s.GetAllClubs)
+	app.GET("/tagfilter", s.getClubInfoOfGivenTags)
+	app.GET("/tages", s.appGetAllTags)
+	app.GET("/viewlist/unreadlist", s.getUnreadViewList)
+	app.GET("/viewlist/new", s.createNewViewList)
+	app.PUT("/viewlist/markread", s.markClubReadInViewList)
+	app.PUT("/viewlist/swipe", s.swipeClubInViewList)
+	app.POST("/viewlist/events", s.submitViewListEvents)
+}
+
+func (s *server) unpublishClub(ctx *gin.Context) {
 	var idReq ClubIDRequest
 	if err := ctx.ShouldBindJSON(&idReq);err != nil {
 		log.Error(err)
@@ -133,7 +349,7 @@ func unpublishClub(ctx *gin.Context) {
 		return
 	}
 
-	err = db.UpdateClubPublishedOrNot(idReq.ClubId, false)
+	err := s.repos.Clubs.UpdateClubPublishedOrNot(idReq.ClubId, false)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
@@ -143,17 +359,7 @@ func unpublishClub(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(nil))
 }
 
-func getOneClubInfo(ctx *gin.Context) {
-	//check admin or not
-	account, err := getAdminUser(ctx)
-	if err != nil {
-		return
-	}
-	if !account.IsAdmin {
-		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.NO_PERMISSION, nil))
-		return
-	}
-
+func (s *server) getOneClubInfo(ctx *gin.Context) {
 	//check club id
 	clubId := ctx.Query("club_id")
 	if clubId == "" {
@@ -162,7 +368,7 @@ func getOneClubInfo(ctx *gin.Context) {
 	}
 
 	//get response club info
-	clubInfo, err := db.GetClubInfoCountByClubId(clubId)
+	clubInfo, err := s.repos.Clubs.GetClubInfoCountByClubId(clubId)
 	if gorm.IsRecordNotFoundError(err) {
 		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
 		return
@@ -173,15 +379,14 @@ func getOneClubInfo(ctx *gin.Context) {
 		return
 	}
 
-	tagIds, pictureIds, err := getClubTagIdsAndPictureIds(clubInfo.ClubID,
-		clubInfo.Pic1ID, clubInfo.Pic2ID, clubInfo.Pic3ID, clubInfo.Pic4ID, clubInfo.Pic5ID, clubInfo.Pic6ID)
+	tagIds, gallery, err := s.getClubTagIdsAndGallery(clubInfo.ClubID)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
 
-	club := constructClubInfoCountPost(clubInfo, tagIds, pictureIds)
+	club := constructClubInfoCountPost(clubInfo, tagIds, gallery)
 	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(club))
 }
 
@@ -191,7 +396,7 @@ type UpdateUserPost struct {
 	SrcLoopUID string `json:"src_loop_uid"`
 }
 
-func updateRegisterUser(ctx *gin.Context) {
+func (s *server) updateRegisterUser(ctx *gin.Context) {
 	//check request param
 	var updateUserPost UpdateUserPost
 	if err := ctx.ShouldBindJSON(&updateUserPost); err != nil {
@@ -208,13 +413,22 @@ func updateRegisterUser(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, "Check source uid format"))
 		return
 	}
+	//only the owner of the source uid may move it
+	if getAppTokenClaims(ctx).Subject != updateUserPost.SrcLoopUID {
+		ctx.JSON(http.StatusForbidden, httpserver.ConstructResponse(httpserver.NO_PERMISSION, nil))
+		return
+	}
 
 	//update registered user, whether source user exists or not
 	var user db.UpdateUser
 	user.SrcLoopUID = updateUserPost.SrcLoopUID
 	user.LoopUID = updateUserPost.NewLoopUID
 	user.LoopUserName = updateUserPost.LoopUserName
-	err := user.Update()
+	err := s.repos.Users.UpdateAppUser(&user)
+	if db.IsDuplicateKeyError(err) {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.USER_ALREADY_REGISTERED, nil))
+		return
+	}
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
@@ -231,29 +445,19 @@ type AccountPost struct {
 	Note      string `json:"note"`
 }
 
-func updateAccountInfo(ctx *gin.Context) {
-	//check admin or not
-	account, err := getAdminUser(ctx)
-	if err != nil {
-		return
-	}
-	if !account.IsAdmin {
-		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.NO_PERMISSION, nil))
-		return
-	}
-
+func (s *server) updateAccountInfo(ctx *gin.Context) {
 	var accountReq AccountPost
 	if err := ctx.ShouldBindJSON(&accountReq);err!=nil {
 		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
 		log.Error(err)
 		return
 	}
-	if account.AccountID == "" {
+	if accountReq.AccountId == "" {
 		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
 		return
 	}
 
-	_, err = db.GetAccountByUserId(accountReq.AccountId)
+	_, err := s.repos.Accounts.GetAccountByUserId(accountReq.AccountId)
 	if gorm.IsRecordNotFoundError(err) {
 		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
 		return
@@ -270,7 +474,7 @@ func updateAccountInfo(ctx *gin.Context) {
 		PhoneNum: accountReq.PhoneNum,
 		Note: accountReq.Note,
 	}
-	err = adminAccount.Update()
+	err = s.repos.Accounts.UpdateAccount(&adminAccount)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
@@ -289,8 +493,9 @@ func logout(ctx *gin.Context) {
 		return
 	}
 
-	// delete the user in the session
-	session.Delete(USER)
+	// delete the session, server side included
+	session.Clear()
+	session.Options(sessions.Options{MaxAge: -1})
 	if err := session.Save(); err != nil {
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
@@ -299,7 +504,7 @@ func logout(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(nil))
 }
 
-func getUnreadViewList(ctx *gin.Context) {
+func (s *server) getUnreadViewList(ctx *gin.Context) {
 	//check user
 	user, err := getAppUser(ctx)
 	if err != nil {
@@ -308,14 +513,14 @@ func getUnreadViewList(ctx *gin.Context) {
 	}
 
 	//get user view list, create new view list when not found.
-	viewList, err := db.GetLatestViewListByUID(user.LoopUID)
+	viewList, err := s.repos.ViewLists.GetLatestViewListByUID(user.LoopUID)
 	if gorm.IsRecordNotFoundError(err) {
 		//try to create view list
 		viewList = &db.ViewList{
 			LoopUID:    user.LoopUID,
 			ViewListID: uuid.New().String(),
 		}
-		err = viewList.Insert()
+		err = s.repos.ViewLists.InsertViewList(viewList)
 		//fail to create view list
 		if err != nil {
 			log.Error("fail to create view list when register, error:", err)
@@ -329,28 +534,241 @@ func getUnreadViewList(ctx *gin.Context) {
 		return
 	}
 
+	//page through the clubs of the view list, the cursor is where the last page ended
+	pageSize, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(UNREAD_PAGE_SIZE)))
+	if err != nil || pageSize <= 0 || pageSize > UNREAD_PAGE_SIZE_MAX {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
+		return
+	}
+	position := 0
+	if cursor := ctx.Query("cursor"); cursor != "" {
+		var cursorViewListID string
+		cursorViewListID, position, err = decodeViewListCursor(cursor)
+		//cursors of former view lists are refused, the client has to start over
+		if err != nil || cursorViewListID != viewList.ViewListID {
+			ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, "invalid cursor"))
+			return
+		}
+	}
+
+	viewListClubs, err := s.getViewListClubs(viewList)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
 	//Get not read club infos attached with current user favourite or not
-	notReadClubInfos, err := db.GetUnreadPublishedFavouriteClubInfo(user.LoopUID, viewList.ViewListID)
+	notReadClubInfos, err := s.getUnreadClubInfos(user.LoopUID, viewList.ViewListID)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
+	notReadByID := make(map[string]db.FavouriteClubInfo, len(notReadClubInfos))
+	for _, clubInfo := range notReadClubInfos {
+		notReadByID[clubInfo.ClubID] = clubInfo
+	}
+
+	//clubs read or unpublished meanwhile are skipped, the positions stay the same
+	pageClubs := make([]db.FavouriteClubInfo, 0, pageSize)
+	nextCursor := ""
+	for _, viewListClub := range viewListClubs {
+		if viewListClub.Position <= position {
+			continue
+		}
+		clubInfo, ok := notReadByID[viewListClub.ClubID]
+		if !ok {
+			continue
+		}
+		if len(pageClubs) == pageSize {
+			nextCursor = encodeViewListCursor(viewList.ViewListID, position)
+			break
+		}
+		pageClubs = append(pageClubs, clubInfo)
+		position = viewListClub.Position
+	}
 
 	//construct response club info from DB query result
-	responseClubs, err := getResponseFromFavouriteClubInfos(notReadClubInfos)
+	responseClubs, err := s.getResponseFromFavouriteClubInfos(pageClubs)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
 
-	//shuffle response club infos
-	rand.Seed(time.Now().UnixNano())
-	rand.Shuffle(len(responseClubs), func(i, j int) { responseClubs[i], responseClubs[j] = responseClubs[j], responseClubs[i] })
-
 	//construct response unread view list
-	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(responseClubs))
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(UnreadViewListPage{Clubs: responseClubs, NextCursor: nextCursor}))
+}
+
+const (
+	UNREAD_PAGE_SIZE     = 20
+	UNREAD_PAGE_SIZE_MAX = 100
+)
+
+type UnreadViewListPage struct {
+	Clubs []FavouriteClubInfo `json:"clubs"`
+	// Pass as cursor to get the next page, empty on the last page
+	NextCursor string `json:"next_cursor"`
+}
+
+//Returns the clubs of the view list in their order. The order is fixed when the view list is fetched first:
+//the unread clubs ranked for the user, with a random source seeded by the view list id.
+func (s *server) getViewListClubs(viewList *db.ViewList) ([]db.ViewListClub, error) {
+	if viewList.ClubsOrdered {
+		return s.repos.ViewLists.GetViewListClubs(viewList.ViewListID)
+	}
+
+	notReadClubInfos, err := s.getUnreadClubInfos(viewList.LoopUID, viewList.ViewListID)
+	if err != nil {
+		return nil, err
+	}
+	seed := fnv.New64a()
+	seed.Write([]byte(viewList.ViewListID))
+	rankedClubInfos, err := s.rankClubs(viewList.LoopUID, notReadClubInfos, rand.New(rand.NewSource(int64(seed.Sum64()))))
+	if err != nil {
+		return nil, err
+	}
+	clubIDs := make([]string, 0, len(rankedClubInfos))
+	for _, clubInfo := range rankedClubInfos {
+		clubIDs = append(clubIDs, clubInfo.ClubID)
+	}
+
+	//a concurrent request may have stored the order first, then that one is used
+	err = s.repos.ViewLists.InsertViewListClubs(viewList.ViewListID, clubIDs)
+	if err != nil && !db.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+	return s.repos.ViewLists.GetViewListClubs(viewList.ViewListID)
+}
+
+//Returns the published clubs not read in the view list, without the clubs the user passed on lately.
+func (s *server) getUnreadClubInfos(loopUID, viewListID string) ([]db.FavouriteClubInfo, error) {
+	clubInfos, err := s.repos.Favourites.GetUnreadPublishedFavouriteClubInfo(loopUID, viewListID)
+	if err != nil {
+		return nil, err
+	}
+	passedClubIDs, err := s.repos.ViewLists.GetPassedClubIDs(loopUID, time.Now().Add(-s.passCooldown()))
+	if err != nil {
+		return nil, err
+	}
+	passed := make(map[string]bool, len(passedClubIDs))
+	for _, clubID := range passedClubIDs {
+		passed[clubID] = true
+	}
+	unread := make([]db.FavouriteClubInfo, 0, len(clubInfos))
+	for _, clubInfo := range clubInfos {
+		if !passed[clubInfo.ClubID] {
+			unread = append(unread, clubInfo)
+		}
+	}
+	return unread, nil
+}
+
+func (s *server) passCooldown() time.Duration {
+	if s.config.Ranking.PassCooldown > 0 {
+		return s.config.Ranking.PassCooldown
+	}
+	return 30 * 24 * time.Hour
+}
+
+//Cursors are opaque to clients, they carry the view list and the position of the last club delivered.
+func encodeViewListCursor(viewListID string, position int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(viewListID + ":" + strconv.Itoa(position)))
+}
+
+func decodeViewListCursor(cursor string) (string, int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", 0, err
+	}
+	separator := strings.LastIndex(string(decoded), ":")
+	if separator < 0 {
+		return "", 0, errors.New("cursor without position")
+	}
+	position, err := strconv.Atoi(string(decoded[separator+1:]))
+	if err != nil || position < 0 {
+		return "", 0, errors.New("invalid cursor position")
+	}
+	return string(decoded[:separator]), position, nil
+}
+
+func newClubRanker(conf config.Ranking) *ranking.Ranker {
+	weights := ranking.Weights{
+		Tags:         conf.TagWeight,
+		CoOccurrence: conf.CoOccurrenceWeight,
+		Popularity:   conf.PopularityWeight,
+	}
+	if weights == (ranking.Weights{}) {
+		weights = ranking.DefaultWeights
+	}
+	explorationRatio := 0.2
+	if conf.ExplorationRatio != nil {
+		explorationRatio = *conf.ExplorationRatio
+	}
+	return ranking.NewRanker(weights, explorationRatio)
+}
+
+const RANKING_SIGNALS_KEY = "signals"
+
+// The signals are built from the tags and favourites of all clubs, too costly to load for every view list. Only
+// one entry is cached, it is loaded again once the ttl is over.
+func newRankingSignalsCache(conf config.Ranking) *cache.LRU {
+	ttl := conf.SignalsTTL
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	return cache.NewLRU(1, ttl)
+}
+
+//Returns the signals clubs are ranked by, cached for all users.
+func (s *server) getRankingSignals() (*ranking.Signals, error) {
+	if cached, ok := s.rankingSignals.Get(RANKING_SIGNALS_KEY); ok {
+		return cached.(*ranking.Signals), nil
+	}
+
+	relationships, err := s.repos.Clubs.GetAllTagRelationships()
+	if err != nil {
+		return nil, err
+	}
+	clubTags := make(map[string][]string)
+	for _, relationship := range relationships {
+		clubTags[relationship.ClubID] = append(clubTags[relationship.ClubID], relationship.TagID)
+	}
+
+	userFavourites, err := s.repos.Favourites.GetAllUserFavourites()
+	if err != nil {
+		return nil, err
+	}
+	favourites := make(map[string][]string)
+	for _, favourite := range userFavourites {
+		favourites[favourite.LoopUID] = append(favourites[favourite.LoopUID], favourite.ClubID)
+	}
+
+	signals := ranking.NewSignals(clubTags, favourites)
+	s.rankingSignals.Add(RANKING_SIGNALS_KEY, signals)
+	return signals, nil
+}
+
+//Orders clubs for the user by their tags, tags favoured together by other users and popularity.
+func (s *server) rankClubs(loopUID string, clubs []db.FavouriteClubInfo, rnd *rand.Rand) ([]db.FavouriteClubInfo, error) {
+	signals, err := s.getRankingSignals()
+	if err != nil {
+		return nil, err
+	}
+
+	clubsByID := make(map[string]db.FavouriteClubInfo, len(clubs))
+	clubIDs := make([]string, 0, len(clubs))
+	for _, club := range clubs {
+		clubsByID[club.ClubID] = club
+		clubIDs = append(clubIDs, club.ClubID)
+	}
+
+	ranked := make([]db.FavouriteClubInfo, 0, len(clubs))
+	for _, clubID := range s.clubRanker.Rank(signals, loopUID, clubIDs, rnd) {
+		ranked = append(ranked, clubsByID[clubID])
+	}
+	return ranked, nil
 }
 
 type ClubIDRequest struct {
@@ -358,7 +776,7 @@ type ClubIDRequest struct {
 }
 
 //Marks club already read by user in current view list.
-func markClubReadInViewList(ctx *gin.Context) {
+func (s *server) markClubReadInViewList(ctx *gin.Context) {
 	//check user
 	user, err := getAppUser(ctx)
 	if err != nil {
@@ -375,7 +793,7 @@ func markClubReadInViewList(ctx *gin.Context) {
 	}
 
 	//check user and view list
-	viewList, err := db.GetLatestViewListByUID(user.LoopUID)
+	viewList, err := s.repos.ViewLists.GetLatestViewListByUID(user.LoopUID)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
@@ -383,7 +801,7 @@ func markClubReadInViewList(ctx *gin.Context) {
 	}
 
 	//check club
-	_, err = db.GetClubInfoByClubId(idReq.ClubId)
+	_, err = s.repos.Clubs.GetClubInfoByClubId(idReq.ClubId)
 	if gorm.IsRecordNotFoundError(err) {
 		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
 		return
@@ -400,7 +818,7 @@ func markClubReadInViewList(ctx *gin.Context) {
 		LoopUID:    user.LoopUID,
 		ClubID:     idReq.ClubId,
 	}
-	err = viewLog.Insert()
+	err = s.repos.ViewLists.UpsertViewListLog(&viewLog)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
@@ -410,9 +828,21 @@ func markClubReadInViewList(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(nil))
 }
 
-//Returns a new club view list and corresponding id.
-// Club view list is current all published clubs that sequence shuffled.
-func createNewViewList(ctx *gin.Context) {
+type SwipePost struct {
+	ClubId string `json:"club_id"`
+	// LIKE, SUPERLIKE, PASS or SKIP
+	Action string `json:"action"`
+}
+
+type SwipeResponse struct {
+	ViewListID string `json:"view_list_id"`
+	ClubID     string `json:"club_id"`
+	Action     string `json:"action"`
+}
+
+//Records the swipe of the user on a club in current view list and marks the club read.
+// Swiping the club again in the same view list replaces the action, repeating a swipe changes nothing.
+func (s *server) swipeClubInViewList(ctx *gin.Context) {
 	//check user
 	user, err := getAppUser(ctx)
 	if err != nil {
@@ -420,82 +850,114 @@ func createNewViewList(ctx *gin.Context) {
 		return
 	}
 
-	//create new view list info into
-	viewList := db.ViewList{
-		LoopUID:    user.LoopUID,
-		ViewListID: uuid.New().String(),
-	}
-	err = viewList.Insert()
-	if err != nil {
+	//get request params
+	var swipePost SwipePost
+	if err := ctx.ShouldBindJSON(&swipePost); err != nil {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
 		log.Error(err)
-		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
-
-	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(nil))
-}
-
-func getClubInfoOfGivenTags(ctx *gin.Context) {
-	//check user
-	user, err := getAppUser(ctx)
-	if err != nil {
-		log.Error(err)
+	if !db.IsValidSwipeAction(swipePost.Action) {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, "unknown action"))
 		return
 	}
 
-	//get request tags
-	tagStr := ctx.Query("tag_id")
-	if tagStr == "" {
-		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, "tags not specified"))
+	//swipes belong to current view list
+	viewList, err := s.repos.ViewLists.GetLatestViewListByUID(user.LoopUID)
+	if gorm.IsRecordNotFoundError(err) {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, "no view list"))
 		return
 	}
-	tagIDs := strings.Split(tagStr, ";")
-
-	//validate tags
-	tags, err := db.GetClubTagsByTagIds(tagIDs)
 	if err != nil {
+		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
-	if len(tags) != len(tagIDs) {
-		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, "invalid tag id"))
+
+	//check club
+	clubInfo, err := s.repos.Clubs.GetClubInfoByClubId(swipePost.ClubId)
+	if gorm.IsRecordNotFoundError(err) || (err == nil && !clubInfo.Published) {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
 		return
 	}
-
-	//get clubs having given tag id.
-	relationships, err := db.GetTagRelationshipsByTagIDs(tagIDs)
 	if err != nil {
+		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
-	//unite club ids from clubs
-	clubIDs := make([]string, 0)
-	for _, relation := range relationships {
-		clubIDs = append(clubIDs, relation.ClubID)
-	}
 
-	favouriteClubInfos, err := db.GetAllPublishedFavouriteClubInfoByClubIDs(user.LoopUID, clubIDs)
+	//swipes sent one at a time are taken as made when they arrive
+	now := time.Now()
+	swipe := db.SwipeAction{
+		ViewListID:      viewList.ViewListID,
+		LoopUID:         user.LoopUID,
+		ClubID:          swipePost.ClubId,
+		Action:          swipePost.Action,
+		ClientTimestamp: &now,
+	}
+	err = s.repos.ViewLists.SaveSwipeAction(&swipe)
 	if err != nil {
+		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
 
-	responseClubs, err := getResponseFromFavouriteClubInfos(favouriteClubInfos)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
-	}
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(SwipeResponse{
+		ViewListID: swipe.ViewListID,
+		ClubID:     swipe.ClubID,
+		Action:     swipe.Action,
+	}))
+}
 
-	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(responseClubs))
+const (
+	// Marks the club read without swiping, like markread
+	VIEW_LIST_EVENT_READ = "READ"
+
+	EVENT_RESULT_OK                = "OK"
+	EVENT_RESULT_INVALID_ACTION    = "INVALID_ACTION"
+	EVENT_RESULT_UNKNOWN_CLUB      = "UNKNOWN_CLUB"
+	EVENT_RESULT_INVALID_TIMESTAMP = "INVALID_TIMESTAMP"
+	EVENT_RESULT_INVALID_EVENT_ID  = "INVALID_EVENT_ID"
+	// The event was applied before, in this or an earlier batch
+	EVENT_RESULT_DUPLICATE = "DUPLICATE"
+
+	VIEW_LIST_EVENTS_MAX = 100
+	// Clocks of phones are allowed to be ahead of the server this much
+	CLIENT_CLOCK_SKEW = 5 * time.Minute
+)
+
+type ViewListEventsPost struct {
+	// Chosen by the app for each batch and sent again when the batch is retried
+	ClientEventID string          `json:"client_event_id"`
+	Events        []ViewListEvent `json:"events"`
 }
 
-//FavouriteClubInfo is a assist struct to response club info to app user.
-type FavouriteClubInfo struct {
-	ClubInfoPost
-	//is user favourite to this club
-	Favourite bool `json:"favourite"`
+type ViewListEvent struct {
+	// Chosen by the app for each event, an event is applied once even when sent again in another batch
+	ClientEventID string `json:"client_event_id"`
+	ClubID        string `json:"club_id"`
+	// READ, LIKE, SUPERLIKE, PASS or SKIP
+	Action          string    `json:"action"`
+	ClientTimestamp time.Time `json:"client_timestamp"`
 }
 
-func GetAllClubs(ctx *gin.Context) {
+type ViewListEventResult struct {
+	ClientEventID string `json:"client_event_id"`
+	ClubID        string `json:"club_id"`
+	Action        string `json:"action"`
+	Status        string `json:"status"`
+}
+
+type ViewListEventsResponse struct {
+	ClientEventID string `json:"client_event_id"`
+	// True when the batch was submitted before, the results are the ones of the first submission
+	Replayed bool                  `json:"replayed"`
+	Results  []ViewListEventResult `json:"results"`
+}
+
+//Applies a batch of read and swipe events to current view list in one transaction. Invalid events are reported
+// and left out, the others are applied in the order of their client timestamp.
+func (s *server) submitViewListEvents(ctx *gin.Context) {
 	//check user
 	user, err := getAppUser(ctx)
 	if err != nil {
@@ -503,15 +965,284 @@ func GetAllClubs(ctx *gin.Context) {
 		return
 	}
 
-	favouriteClubInfos, err := db.GetAllPublishedFavouriteClubInfo(user.LoopUID)
-	if err != nil {
+	//get request params
+	var eventsPost ViewListEventsPost
+	if err := ctx.ShouldBindJSON(&eventsPost); err != nil {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
 		log.Error(err)
-		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	if eventsPost.ClientEventID == "" || len(eventsPost.ClientEventID) > 64 ||
+		len(eventsPost.Events) == 0 || len(eventsPost.Events) > VIEW_LIST_EVENTS_MAX {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
 		return
 	}
 
-	responseInfo, err := getResponseFromFavouriteClubInfos(favouriteClubInfos)
-	if err != nil {
+	//a replayed batch gets the results of the first submission
+	batch, err := s.repos.ViewLists.GetSwipeBatch(user.LoopUID, eventsPost.ClientEventID)
+	if err == nil {
+		respondSwipeBatch(ctx, batch)
+		return
+	}
+	if !gorm.IsRecordNotFoundError(err) {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	//events belong to current view list
+	viewList, err := s.repos.ViewLists.GetLatestViewListByUID(user.LoopUID)
+	if gorm.IsRecordNotFoundError(err) {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, "no view list"))
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	//check all clubs at once
+	clubIDs := make([]string, 0, len(eventsPost.Events))
+	for _, event := range eventsPost.Events {
+		clubIDs = append(clubIDs, event.ClubID)
+	}
+	clubInfos, err := s.repos.Clubs.GetPublishedClubInfosByClubIds(clubIDs)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	published := make(map[string]bool, len(clubInfos))
+	for _, clubInfo := range clubInfos {
+		published[clubInfo.ClubID] = true
+	}
+
+	//events applied before are left out
+	eventIDs := make([]string, 0, len(eventsPost.Events))
+	for _, event := range eventsPost.Events {
+		eventIDs = append(eventIDs, event.ClientEventID)
+	}
+	storedEventIDs, err := s.repos.ViewLists.GetSwipeEventIDs(user.LoopUID, eventIDs)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	applied := make(map[string]bool, len(storedEventIDs))
+	for _, eventID := range storedEventIDs {
+		applied[eventID] = true
+	}
+
+	results := make([]ViewListEventResult, 0, len(eventsPost.Events))
+	validEvents := make([]ViewListEvent, 0, len(eventsPost.Events))
+	latest := time.Now().Add(CLIENT_CLOCK_SKEW)
+	for _, event := range eventsPost.Events {
+		result := ViewListEventResult{
+			ClientEventID: event.ClientEventID,
+			ClubID:        event.ClubID,
+			Action:        event.Action,
+			Status:        EVENT_RESULT_OK,
+		}
+		switch {
+		case event.ClientEventID == "" || len(event.ClientEventID) > 64:
+			result.Status = EVENT_RESULT_INVALID_EVENT_ID
+		case applied[event.ClientEventID]:
+			result.Status = EVENT_RESULT_DUPLICATE
+		case event.Action != VIEW_LIST_EVENT_READ && !db.IsValidSwipeAction(event.Action):
+			result.Status = EVENT_RESULT_INVALID_ACTION
+		case !published[event.ClubID]:
+			result.Status = EVENT_RESULT_UNKNOWN_CLUB
+		case event.ClientTimestamp.IsZero() || event.ClientTimestamp.After(latest):
+			result.Status = EVENT_RESULT_INVALID_TIMESTAMP
+		default:
+			applied[event.ClientEventID] = true
+			validEvents = append(validEvents, event)
+		}
+		results = append(results, result)
+	}
+
+	//later swipes on a club replace earlier ones, whatever order they were sent in
+	sort.SliceStable(validEvents, func(i, j int) bool {
+		return validEvents[i].ClientTimestamp.Before(validEvents[j].ClientTimestamp)
+	})
+	events := make([]db.SwipeEvent, 0, len(validEvents))
+	reads := make([]db.ViewListLog, 0)
+	swipes := make([]db.SwipeAction, 0)
+	for _, event := range validEvents {
+		events = append(events, db.SwipeEvent{
+			LoopUID:         user.LoopUID,
+			ClientEventID:   event.ClientEventID,
+			ViewListID:      viewList.ViewListID,
+			ClubID:          event.ClubID,
+			Action:          event.Action,
+			ClientTimestamp: event.ClientTimestamp,
+		})
+		if event.Action == VIEW_LIST_EVENT_READ {
+			reads = append(reads, db.ViewListLog{ViewListID: viewList.ViewListID, LoopUID: user.LoopUID, ClubID: event.ClubID})
+			continue
+		}
+		clientTimestamp := event.ClientTimestamp
+		swipes = append(swipes, db.SwipeAction{
+			ViewListID:      viewList.ViewListID,
+			LoopUID:         user.LoopUID,
+			ClubID:          event.ClubID,
+			Action:          event.Action,
+			ClientTimestamp: &clientTimestamp,
+		})
+	}
+
+	encodedResults, err := json.Marshal(results)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	batch = &db.SwipeBatch{
+		LoopUID:       user.LoopUID,
+		ClientEventID: eventsPost.ClientEventID,
+		Results:       string(encodedResults),
+	}
+	err = s.repos.ViewLists.SaveSwipeBatch(batch, events, reads, swipes)
+	if err != nil {
+		//the same batch may have been stored by a concurrent request meanwhile
+		storedBatch, getErr := s.repos.ViewLists.GetSwipeBatch(user.LoopUID, eventsPost.ClientEventID)
+		if getErr == nil {
+			respondSwipeBatch(ctx, storedBatch)
+			return
+		}
+		//or another batch with some of the events, retrying reports them as duplicates
+		if db.IsDuplicateKeyError(err) {
+			ctx.JSON(http.StatusConflict, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, "events stored concurrently, retry"))
+			return
+		}
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(ViewListEventsResponse{
+		ClientEventID: eventsPost.ClientEventID,
+		Results:       results,
+	}))
+}
+
+func respondSwipeBatch(ctx *gin.Context, batch *db.SwipeBatch) {
+	var results []ViewListEventResult
+	if err := json.Unmarshal([]byte(batch.Results), &results); err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(ViewListEventsResponse{
+		ClientEventID: batch.ClientEventID,
+		Replayed:      true,
+		Results:       results,
+	}))
+}
+
+//Returns a new club view list and corresponding id.
+// Club view list is current all published clubs that sequence shuffled.
+func (s *server) createNewViewList(ctx *gin.Context) {
+	//check user
+	user, err := getAppUser(ctx)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	//create new view list info into
+	viewList := db.ViewList{
+		LoopUID:    user.LoopUID,
+		ViewListID: uuid.New().String(),
+	}
+	err = s.repos.ViewLists.InsertViewList(&viewList)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(nil))
+}
+
+func (s *server) getClubInfoOfGivenTags(ctx *gin.Context) {
+	//check user
+	user, err := getAppUser(ctx)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	//get request tags
+	tagStr := ctx.Query("tag_id")
+	if tagStr == "" {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, "tags not specified"))
+		return
+	}
+	tagIDs := strings.Split(tagStr, ";")
+
+	//validate tags
+	tags, err := s.repos.Clubs.GetClubTagsByTagIds(tagIDs)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	if len(tags) != len(tagIDs) {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, "invalid tag id"))
+		return
+	}
+
+	//get clubs having given tag id.
+	relationships, err := s.repos.Clubs.GetTagRelationshipsByTagIDs(tagIDs)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	//unite club ids from clubs
+	clubIDs := make([]string, 0)
+	for _, relation := range relationships {
+		clubIDs = append(clubIDs, relation.ClubID)
+	}
+
+	favouriteClubInfos, err := s.repos.Favourites.GetAllPublishedFavouriteClubInfoByClubIDs(user.LoopUID, clubIDs)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	responseClubs, err := s.getResponseFromFavouriteClubInfos(favouriteClubInfos)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+	}
+
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(responseClubs))
+}
+
+//FavouriteClubInfo is a assist struct to response club info to app user.
+type FavouriteClubInfo struct {
+	ClubInfoPost
+	//is user favourite to this club
+	Favourite bool `json:"favourite"`
+}
+
+func (s *server) GetAllClubs(ctx *gin.Context) {
+	//check user
+	user, err := getAppUser(ctx)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	favouriteClubInfos, err := s.repos.Favourites.GetAllPublishedFavouriteClubInfo(user.LoopUID)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	responseInfo, err := s.getResponseFromFavouriteClubInfos(favouriteClubInfos)
+	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 	}
 
@@ -519,12 +1250,11 @@ func GetAllClubs(ctx *gin.Context) {
 }
 
 //Constructs club response info from DB query result
-func getResponseFromFavouriteClubInfos(favouriteClubInfos []db.FavouriteClubInfo) ([]FavouriteClubInfo, error) {
+func (s *server) getResponseFromFavouriteClubInfos(favouriteClubInfos []db.FavouriteClubInfo) ([]FavouriteClubInfo, error) {
 	clubInfos := make([]FavouriteClubInfo, 0)
 
 	for _, clubInfo := range favouriteClubInfos {
-		tagIDs, pictureIDs, err := getClubTagIdsAndPictureIds(clubInfo.ClubID,
-			clubInfo.Pic1ID, clubInfo.Pic2ID, clubInfo.Pic3ID, clubInfo.Pic4ID, clubInfo.Pic5ID, clubInfo.Pic6ID)
+		tagIDs, gallery, err := s.getClubTagIdsAndGallery(clubInfo.ClubID)
 		if err != nil {
 			return clubInfos, err
 		}
@@ -540,7 +1270,8 @@ func getResponseFromFavouriteClubInfos(favouriteClubInfos []db.FavouriteClubInfo
 			Description: clubInfo.Description,
 			LogoId:      clubInfo.LogoID,
 			TagIds:      tagIDs,
-			PictureIds:  pictureIDs,
+			PictureIds:  getGalleryPictureIDs(gallery),
+			Gallery:     constructGalleryPosts(gallery),
 		}
 		responseInfo := FavouriteClubInfo{
 			ClubInfoPost: infoPost,
@@ -553,17 +1284,17 @@ func getResponseFromFavouriteClubInfos(favouriteClubInfos []db.FavouriteClubInfo
 }
 
 //Sets club is unfavourite to user
-func setUnfavouriteClub(ctx *gin.Context) {
-	doFavouriteClub(ctx, false)
+func (s *server) setUnfavouriteClub(ctx *gin.Context) {
+	s.doFavouriteClub(ctx, false)
 }
 
 //Sets club is favourite to user
-func setFavouriteClub(ctx *gin.Context) {
-	doFavouriteClub(ctx, true)
+func (s *server) setFavouriteClub(ctx *gin.Context) {
+	s.doFavouriteClub(ctx, true)
 }
 
 //Sets club is whatever favourite or unfavourite to user
-func doFavouriteClub(ctx *gin.Context, favourite bool) {
+func (s *server) doFavouriteClub(ctx *gin.Context, favourite bool) {
 	//check user
 	user, err := getAppUser(ctx)
 	if err != nil {
@@ -572,7 +1303,7 @@ func doFavouriteClub(ctx *gin.Context, favourite bool) {
 	}
 	//check club id
 	clubID := ctx.Param("clubID")
-	_, err = db.GetClubInfoByClubId(clubID)
+	_, err = s.repos.Clubs.GetClubInfoByClubId(clubID)
 	if gorm.IsRecordNotFoundError(err) {
 		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
 		return
@@ -583,7 +1314,7 @@ func doFavouriteClub(ctx *gin.Context, favourite bool) {
 		return
 	}
 
-	err = setFavouriteStateAndLogIntoDB(user.LoopUID, clubID, favourite)
+	err = s.repos.Favourites.SetFavourite(user.LoopUID, clubID, favourite)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
@@ -593,47 +1324,8 @@ func doFavouriteClub(ctx *gin.Context, favourite bool) {
 	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(nil))
 }
 
-func setFavouriteStateAndLogIntoDB(loopUID, clubID string, like bool) error {
-	//insert favourite club, rolls back when error occurs
-	favourite := db.UserFavourite{
-		LoopUID:   loopUID,
-		ClubID:    clubID,
-		Favourite: like,
-	}
-
-	txDb := db.DB.Begin()
-	err := favourite.InsertOrUpdate(txDb)
-	if err != nil {
-		txDb.Rollback()
-		return err
-	}
-
-	//what action user is doing
-	var action string
-	if like {
-		action = db.FAVORITE_ACTION
-	} else {
-		action = db.UNFAVORITE_ACTION
-	}
-
-	//insert log, rolls back when error occurs
-	l := db.UserFavouriteLog{
-		LoopUID: loopUID,
-		ClubID:  clubID,
-		Action:  action,
-	}
-	err = l.Insert(txDb)
-	if err != nil {
-		txDb.Rollback()
-		return err
-	}
-
-	txDb.Commit()
-	return nil
-}
-
 //Returns the clubs that user favourite, whether published or not.
-func getFavouriteClubList(ctx *gin.Context) {
+func (s *server) getFavouriteClubList(ctx *gin.Context) {
 	user, err := getAppUser(ctx)
 	if err != nil {
 		log.Error(err)
@@ -641,25 +1333,24 @@ func getFavouriteClubList(ctx *gin.Context) {
 	}
 
 	//get favorite club ids
-	favourites, err := db.GetUserFavouritesByUID(user.LoopUID)
+	favourites, err := s.repos.Favourites.GetUserFavouritesByUID(user.LoopUID)
 	clubIds := make([]string, 0)
 	for _, favourite := range favourites {
 		clubIds = append(clubIds, favourite.ClubID)
 	}
 
 	//get club infos
-	clubInfos, err := db.GetPublishedClubInfosByClubIds(clubIds)
+	clubInfos, err := s.repos.Clubs.GetPublishedClubInfosByClubIds(clubIds)
 
 	//construct response info
 	clubInfoResponses := make([]ClubInfoPost, 0)
 	for _, clubInfo := range clubInfos {
-		tagIDs, pictureIDs, err := getClubTagIdsAndPictureIds(clubInfo.ClubID,
-			clubInfo.Pic1ID, clubInfo.Pic2ID, clubInfo.Pic3ID, clubInfo.Pic4ID, clubInfo.Pic5ID, clubInfo.Pic6ID)
+		tagIDs, gallery, err := s.getClubTagIdsAndGallery(clubInfo.ClubID)
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 			return
 		}
-		clubInfoResponse := constructClubInfoPost(&clubInfo, tagIDs, pictureIDs)
+		clubInfoResponse := constructClubInfoPost(&clubInfo, tagIDs, gallery)
 		clubInfoResponses = append(clubInfoResponses, *clubInfoResponse)
 	}
 
@@ -676,24 +1367,71 @@ func getAppUserInfo(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(user))
 }
 
-//Get user from DB by uid set in request header.
-func getAppUser(ctx *gin.Context) (*db.UserList, error) {
-	userId := ctx.GetHeader("user-id")
-	if userId == "" {
-		ctx.JSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.NOT_AUTHORIZED, nil))
-		return nil, errors.New("header not found")
+//Verifies the bearer access token and loads the app user it is bound to.
+func (s *server) appUserAuth() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		header := ctx.GetHeader("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.NOT_AUTHORIZED, nil))
+			return
+		}
+
+		claims, err := s.tokenIssuer.Verify(strings.TrimPrefix(header, "Bearer "), auth.ACCESS_TOKEN)
+		if err == auth.ErrTokenExpired {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.TOKEN_EXPIRED, nil))
+			return
+		}
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.NOT_AUTHORIZED, nil))
+			return
+		}
+
+		revoked, err := s.repos.Users.IsTokenRevoked(claims.TokenID)
+		if err != nil {
+			log.Error(err)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+			return
+		}
+		if revoked {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.NOT_AUTHORIZED, nil))
+			return
+		}
+
+		user, err := s.repos.Users.GetAppUserByUid(claims.Subject)
+		if gorm.IsRecordNotFoundError(err) {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.NOT_AUTHORIZED, nil))
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+			return
+		}
+
+		ctx.Set(APP_TOKEN_CLAIMS, claims)
+		ctx.Set(APP_USER, user)
+		ctx.Next()
 	}
-	user, err := db.GetAppUserByUid(userId)
-	if gorm.IsRecordNotFoundError(err) {
+}
+
+//Get user authenticated by the app user auth middleware.
+func getAppUser(ctx *gin.Context) (*db.UserList, error) {
+	user, ok := ctx.Get(APP_USER)
+	if !ok {
 		ctx.JSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.NOT_AUTHORIZED, nil))
 		return nil, errors.New("user not found")
 	}
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
-		return nil, err
-	}
 
-	return user, nil
+	return user.(*db.UserList), nil
+}
+
+//Get claims of the access token used for current request.
+func getAppTokenClaims(ctx *gin.Context) *auth.Claims {
+	claims, ok := ctx.Get(APP_TOKEN_CLAIMS)
+	if !ok {
+		return &auth.Claims{}
+	}
+	return claims.(*auth.Claims)
 }
 
 type UserPost struct {
@@ -701,8 +1439,14 @@ type UserPost struct {
 	LoopUserName string `json:"loop_user_name"`
 }
 
+type AppUserTokens struct {
+	auth.TokenPair
+	// Issued once, at register. The app keeps it to log in with, only its hash is stored.
+	AppSecret string `json:"app_secret,omitempty"`
+}
+
 //Used to register for LOOP user.
-func registerAppUser(ctx *gin.Context) {
+func (s *server) registerAppUser(ctx *gin.Context) {
 	userPost := new(UserPost)
 	if err := ctx.ShouldBindJSON(userPost); err != nil {
 		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
@@ -714,7 +1458,7 @@ func registerAppUser(ctx *gin.Context) {
 		return
 	}
 
-	foundUser, err := db.GetAppUserByUid(userPost.LoopUID)
+	foundUser, err := s.repos.Users.GetAppUserByUid(userPost.LoopUID)
 	if err != nil && !gorm.IsRecordNotFoundError(err) {
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		log.Print(err)
@@ -726,108 +1470,416 @@ func registerAppUser(ctx *gin.Context) {
 	}
 
 	//register new use
+	appSecret := genAuthString()
 	user := db.UserList{
 		LoopUID:      userPost.LoopUID,
 		LoopUserName: userPost.LoopUserName,
 		JoinTime:     time.Now(),
+		SecretHash:   s.hashAuthString(appSecret),
+	}
+	err = s.repos.Users.InsertAppUser(&user)
+	//registered concurrently since the check above
+	if db.IsDuplicateKeyError(err) {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.USER_ALREADY_REGISTERED, nil))
+		return
 	}
-	err = user.Insert()
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		log.Error(err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(nil))
-}
-
-func ifAuthorized(ctx *gin.Context) {
-	_, err := getAdminUser(ctx)
+	tokens, err := s.tokenIssuer.IssuePair(user.LoopUID)
 	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		log.Error(err)
 		return
 	}
-	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(true))
-}
 
-//Returns current login user
-func getCurrUser(ctx *gin.Context) {
-	account, err := getAdminUser(ctx)
-	if err != nil {
-		return
-	}
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(AppUserTokens{TokenPair: *tokens, AppSecret: appSecret}))
+}
 
-	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(account))
+type AppLoginPost struct {
+	LoopUID string `json:"loop_uid"`
+	// Secret returned by register, the LoopUID alone proves nothing
+	AppSecret string `json:"app_secret"`
 }
 
-func appGetAllTags(ctx *gin.Context) {
-	_, err := getAppUser(ctx)
-	if err != nil {
+//Logs in a registered LOOP user and returns new tokens.
+func (s *server) loginAppUser(ctx *gin.Context) {
+	var loginPost AppLoginPost
+	if err := ctx.ShouldBindJSON(&loginPost); err != nil {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
+		log.Error(err)
+		return
+	}
+	if len(loginPost.LoopUID) != 64 {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
 		return
 	}
 
-	tags, err := db.GetAllClubTags()
+	user, err := s.repos.Users.GetAppUserByUid(loginPost.LoopUID)
+	if gorm.IsRecordNotFoundError(err) {
+		ctx.JSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.AUTH_FAILED, nil))
+		return
+	}
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
-
-	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(tags))
-}
-
-func adminGetAllTags(ctx *gin.Context) {
-	_, err := getAdminUser(ctx)
-	if err != nil {
+	//users without a secret were registered before secrets were issued, nothing proves who they are
+	secretHash := s.hashAuthString(loginPost.AppSecret)
+	if user.SecretHash == "" || loginPost.AppSecret == "" ||
+		subtle.ConstantTimeCompare([]byte(secretHash), []byte(user.SecretHash)) != 1 {
+		ctx.JSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.AUTH_FAILED, nil))
 		return
 	}
 
-	tags, err := db.GetAllClubTags()
+	tokens, err := s.tokenIssuer.IssuePair(user.LoopUID)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
 
-	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(tags))
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(AppUserTokens{TokenPair: *tokens}))
 }
 
-func serveStaticPicture(ctx *gin.Context) {
-	pictureID := ctx.Param("pictureID")
-	if pictureID == "" {
-		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PICTURE_ID, nil))
+type RefreshTokenPost struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+//Exchanges a refresh token for a new token pair. The used refresh token is revoked.
+func (s *server) refreshAppToken(ctx *gin.Context) {
+	var refreshPost RefreshTokenPost
+	if err := ctx.ShouldBindJSON(&refreshPost); err != nil {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
+		log.Error(err)
 		return
 	}
 
-	fileName, err := db.GetPictureNameById(pictureID)
-	if gorm.IsRecordNotFoundError(err) {
-		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PICTURE_ID, nil))
+	claims, err := s.tokenIssuer.Verify(refreshPost.RefreshToken, auth.REFRESH_TOKEN)
+	if err == auth.ErrTokenExpired {
+		ctx.JSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.TOKEN_EXPIRED, nil))
 		return
 	}
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		ctx.JSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.NOT_AUTHORIZED, nil))
 		return
 	}
 
-	basePath := path.Join(globalConfig.General.PictureStoragePath, fileName)
-	img, err := os.Open(basePath)
-	if err != nil {
-		if strings.HasSuffix(err.Error(), "The system cannot find the file specified.") {
-			log.Error(err)
-			ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.NOT_FOUND, nil))
-			return
+	//refresh tokens are single use, only the request revoking it gets new tokens
+	err = s.revokeAppToken(claims)
+	if db.IsDuplicateKeyError(err) {
+		ctx.JSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.NOT_AUTHORIZED, nil))
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	tokens, err := s.tokenIssuer.IssuePair(claims.Subject)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(tokens))
+}
+
+//Revokes the access token of current request, and the refresh token if given.
+func (s *server) logoutAppUser(ctx *gin.Context) {
+	var refreshPost RefreshTokenPost
+	_ = ctx.ShouldBindJSON(&refreshPost)
+
+	//tokens revoked already, by a concurrent logout or refresh, stay revoked
+	err := s.revokeAppToken(getAppTokenClaims(ctx))
+	if err != nil && !db.IsDuplicateKeyError(err) {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	if refreshPost.RefreshToken != "" {
+		claims, err := s.tokenIssuer.Verify(refreshPost.RefreshToken, auth.REFRESH_TOKEN)
+		if err == nil && claims.Subject == getAppTokenClaims(ctx).Subject {
+			err = s.revokeAppToken(claims)
+			if err != nil && !db.IsDuplicateKeyError(err) {
+				log.Error(err)
+				ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+				return
+			}
 		}
+	}
+
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(nil))
+}
+
+func (s *server) revokeAppToken(claims *auth.Claims) error {
+	revokedToken := db.RevokedToken{
+		TokenID:   claims.TokenID,
+		LoopUID:   claims.Subject,
+		ExpiresAt: claims.ExpiryTime(),
+	}
+	return s.repos.Users.RevokeToken(&revokedToken)
+}
+
+func ifAuthorized(ctx *gin.Context) {
+	_, err := getAdminUser(ctx)
+	if err != nil {
+		return
+	}
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(true))
+}
+
+//Returns current login user
+func getCurrUser(ctx *gin.Context) {
+	account, err := getAdminUser(ctx)
+	if err != nil {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(account))
+}
+
+func (s *server) appGetAllTags(ctx *gin.Context) {
+	_, err := getAppUser(ctx)
+	if err != nil {
+		return
+	}
+
+	tags, err := s.repos.Clubs.GetAllClubTags()
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(tags))
+}
+
+func (s *server) adminGetAllTags(ctx *gin.Context) {
+	_, err := getAdminUser(ctx)
+	if err != nil {
+		return
+	}
+
+	tags, err := s.repos.Clubs.GetAllClubTags()
+	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
-	defer img.Close()
 
-	ctx.Writer.Header().Set("Content-Type", "image/jpeg")
-	_, err = io.Copy(ctx.Writer, img)
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(tags))
+}
+
+// Pictures never change once uploaded, a new picture gets a new ID
+const PICTURE_CACHE_CONTROL = "public, max-age=31536000, immutable"
+
+// How long picture lookups are cached, archived pictures stop being served within this time
+const PICTURE_CACHE_TTL = 10 * time.Minute
+
+func (s *server) serveStaticPicture(ctx *gin.Context) {
+	pictureID := ctx.Param("pictureID")
+	if pictureID == "" {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PICTURE_ID, nil))
+		return
+	}
+
+	accountPicture, err := s.getPicture(pictureID)
+	if gorm.IsRecordNotFoundError(err) {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PICTURE_ID, nil))
+		return
+	}
 	if err != nil {
+		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
+
+	// Pictures from before content sniffing are all jpeg
+	mimeType := accountPicture.MimeType
+	if mimeType == "" {
+		mimeType = "image/jpeg"
+	}
+	fileName := accountPicture.PictureName
+	etag := ""
+	if accountPicture.ContentHash != "" {
+		etag = `"` + accountPicture.ContentHash + `"`
+	}
+
+	var blob storage.Blob
+	var info *storage.BlobInfo
+	if size := ctx.Query("size"); size != "" {
+		variant, ok := s.findPictureVariant(size)
+		if !ok {
+			ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
+			return
+		}
+		variantName := picture.VariantFileName(accountPicture.PictureID, variant.Name, accountPicture.MimeType)
+		// Pictures uploaded before the variant was configured only have the original. Finding out by opening the
+		// variant saves another request to the storage when it is served.
+		var err error
+		if s.config.Storage.SignedURLs {
+			_, err = s.blobStore.Stat(variantName)
+		} else {
+			blob, info, err = s.blobStore.Get(variantName)
+		}
+		if err == nil {
+			fileName = variantName
+			mimeType = picture.VariantFormat(accountPicture.MimeType).MimeType
+			if accountPicture.ContentHash != "" {
+				etag = `"` + accountPicture.ContentHash + "-" + variant.Name + `"`
+			}
+		} else if err != storage.ErrNotFound {
+			log.Error(err)
+			ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+			return
+		}
+	}
+
+	if blob != nil {
+		defer blob.Close()
+	}
+
+	// Revalidation needs neither the storage nor a redirect
+	if etag != "" && etagMatches(ctx.GetHeader("If-None-Match"), etag) {
+		setPictureCacheHeaders(ctx, etag)
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+
+	if s.config.Storage.SignedURLs {
+		ttl := s.config.Storage.SignedURLTTL
+		if ttl <= 0 {
+			ttl = 15 * time.Minute
+		}
+		signedURL, err := s.blobStore.SignedURL(fileName, ttl)
+		if err != nil {
+			log.Error(err)
+			ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+			return
+		}
+		// The signed URL expires, only the picture behind it may be cached for long
+		ctx.Header("Cache-Control", "no-store")
+		ctx.Redirect(http.StatusFound, signedURL)
+		return
+	}
+
+	if blob == nil {
+		blob, info, err = s.blobStore.Get(fileName)
+		if err != nil {
+			if err == storage.ErrNotFound {
+				log.Errorf("Picture %s is missing from the storage", fileName)
+				ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.NOT_FOUND, nil))
+				return
+			}
+			log.Error(err)
+			ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+			return
+		}
+		defer blob.Close()
+	}
+
+	if etag == "" {
+		// Pictures uploaded before content hashes were stored get theirs when the original is served. A variant is
+		// tagged with its own hash, which is not stored, the hash of the picture is the one of the original.
+		hash := sha256.New()
+		_, err := io.Copy(hash, blob)
+		if err == nil {
+			_, err = blob.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			log.Error(err)
+			ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+			return
+		}
+		contentHash := hex.EncodeToString(hash.Sum(nil))
+		if fileName == accountPicture.PictureName {
+			if err := s.repos.Pictures.UpdatePictureContentHash(accountPicture.PictureID, contentHash); err != nil {
+				log.Error(err)
+			}
+			s.pictureCache.Remove(accountPicture.PictureID)
+		}
+		etag = `"` + contentHash + `"`
+	}
+
+	// Errors above must not be cached, the headers are only set for the picture itself.
+	// The picture is streamed from the storage, only the requested range is read.
+	setPictureCacheHeaders(ctx, etag)
+	ctx.Header("Content-Type", mimeType)
+	http.ServeContent(ctx.Writer, ctx.Request, fileName, info.ModTime, blob)
+}
+
+// Sets the headers a picture is cached by, on the picture and on revalidations of it.
+func setPictureCacheHeaders(ctx *gin.Context, etag string) {
+	ctx.Header("Cache-Control", PICTURE_CACHE_CONTROL)
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	ctx.Header("ETag", etag)
+}
+
+// Looks a picture up by its ID, from the cache when possible.
+func (s *server) getPicture(pictureID string) (*db.AccountPicture, error) {
+	if cached, ok := s.pictureCache.Get(pictureID); ok {
+		return cached.(*db.AccountPicture), nil
+	}
+	accountPicture, err := s.repos.Pictures.GetPictureById(pictureID)
+	if err != nil {
+		return nil, err
+	}
+	s.pictureCache.Add(pictureID, accountPicture)
+	return accountPicture, nil
+}
+
+func hashPictureContent(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Reports whether an If-None-Match header matches the strong etag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+//Requires a logged in admin session.
+func adminSessionAuth() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if _, err := getAdminUser(ctx); err != nil {
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+//Requires the role of the logged in account to grant the permission.
+func requirePermission(permission auth.Permission) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		account, err := getAdminUser(ctx)
+		if err != nil {
+			ctx.Abort()
+			return
+		}
+		if !auth.HasPermission(account.Role, permission) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, httpserver.ConstructResponse(httpserver.NO_PERMISSION, nil))
+			return
+		}
+		ctx.Next()
+	}
 }
 
 // Get User information from request context.
@@ -845,7 +1897,7 @@ func getAdminUser(ctx *gin.Context) (*db.AdminAccount, error) {
 }
 
 // Returns the club info of current login user.
-func getSelfClubInfo(ctx *gin.Context) {
+func (s *server) getSelfClubInfo(ctx *gin.Context) {
 	account, err := getAdminUser(ctx)
 	if err != nil {
 		return
@@ -860,26 +1912,25 @@ func getSelfClubInfo(ctx *gin.Context) {
 		return
 	}
 
-	clubInfo, err := db.GetClubInfoCountByClubId(account.ClubID)
+	clubInfo, err := s.repos.Clubs.GetClubInfoCountByClubId(account.ClubID)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
 
-	tagIDs, pictureIDs, err := getClubTagIdsAndPictureIds(clubInfo.ClubID,
-		clubInfo.Pic1ID, clubInfo.Pic2ID, clubInfo.Pic3ID, clubInfo.Pic4ID, clubInfo.Pic5ID, clubInfo.Pic6ID)
+	tagIDs, gallery, err := s.getClubTagIdsAndGallery(clubInfo.ClubID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
 
-	clubInfoResponse :=constructClubInfoCountPost(clubInfo, tagIDs, pictureIDs)
+	clubInfoResponse :=constructClubInfoCountPost(clubInfo, tagIDs, gallery)
 
 	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(clubInfoResponse))
 }
 
-func constructClubInfoPost(clubInfo *db.ClubInfo, tagIDs []string, pictureIDs []string) *ClubInfoPost {
+func constructClubInfoPost(clubInfo *db.ClubInfo, tagIDs []string, gallery []db.ClubPicture) *ClubInfoPost {
 	clubInfoResponse := ClubInfoPost{
 		ClubID:      clubInfo.ClubID,
 		Name:        clubInfo.Name,
@@ -891,15 +1942,37 @@ func constructClubInfoPost(clubInfo *db.ClubInfo, tagIDs []string, pictureIDs []
 		Description: clubInfo.Description,
 		LogoId:      clubInfo.LogoID,
 		TagIds:      tagIDs,
-		PictureIds:  pictureIDs,
+		PictureIds:  getGalleryPictureIDs(gallery),
+		Gallery:     constructGalleryPosts(gallery),
 	}
 	return &clubInfoResponse
 }
 
-//Returns club tag id list and picture id list.
-func getClubTagIdsAndPictureIds(clubID string, picIds ...string) ([]string, []string, error) {
+func constructGalleryPosts(gallery []db.ClubPicture) []GalleryPicturePost {
+	posts := make([]GalleryPicturePost, 0, len(gallery))
+	for _, clubPicture := range gallery {
+		posts = append(posts, GalleryPicturePost{
+			PictureID: clubPicture.PictureID,
+			Position:  clubPicture.Position,
+			Caption:   clubPicture.Caption,
+			Cover:     clubPicture.Cover,
+		})
+	}
+	return posts
+}
+
+func getGalleryPictureIDs(gallery []db.ClubPicture) []string {
+	pictureIDs := make([]string, 0, len(gallery))
+	for _, clubPicture := range gallery {
+		pictureIDs = append(pictureIDs, clubPicture.PictureID)
+	}
+	return pictureIDs
+}
+
+//Returns club tag id list and gallery pictures in their order.
+func (s *server) getClubTagIdsAndGallery(clubID string) ([]string, []db.ClubPicture, error) {
 	//Get club tags relationships from DB
-	tagRelationships, err := db.GetTagRelationshipsByClubID(clubID)
+	tagRelationships, err := s.repos.Clubs.GetTagRelationshipsByClubID(clubID)
 	if err != nil {
 		log.Error(err)
 		return nil, nil, err
@@ -911,16 +1984,13 @@ func getClubTagIdsAndPictureIds(clubID string, picIds ...string) ([]string, []st
 		tagIDs = append(tagIDs, tagRelationship.TagID)
 	}
 
-	//Unite non-nil pic ids
-	pictureIds := make([]string, 0)
-	for _, picId := range picIds {
-		if picId == "" {
-			break
-		}
-		pictureIds = append(pictureIds, picId)
+	gallery, err := s.repos.Clubs.GetClubGallery(clubID)
+	if err != nil {
+		log.Error(err)
+		return nil, nil, err
 	}
 
-	return tagIDs, pictureIds, nil
+	return tagIDs, gallery, nil
 }
 
 type PageResult struct {
@@ -931,17 +2001,7 @@ type PageResult struct {
 	Content    interface{} `json:"content"`
 }
 
-func listAllClubs(ctx *gin.Context) {
-	//check admin or not
-	account, err := getAdminUser(ctx)
-	if err != nil {
-		return
-	}
-	if !account.IsAdmin {
-		ctx.JSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.NO_PERMISSION, nil))
-		return
-	}
-
+func (s *server) listAllClubs(ctx *gin.Context) {
 	//get query params
 	condition, pagination, err := getClubInfoConditionFromRequest(ctx)
 	if err != nil {
@@ -951,7 +2011,7 @@ func listAllClubs(ctx *gin.Context) {
 	}
 
 	//query by given condition
-	clubInfos, err := db.GetClubInfoCountsByCondition(condition)
+	clubInfos, err := s.repos.Clubs.GetClubInfoCountsByCondition(condition)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
@@ -960,15 +2020,14 @@ func listAllClubs(ctx *gin.Context) {
 
 	var responseInfo []ClubInfoCountPost
 	for _, clubInfo := range clubInfos {
-		tagIds, pictureIds, err := getClubTagIdsAndPictureIds(clubInfo.ClubID,
-			clubInfo.Pic1ID, clubInfo.Pic2ID, clubInfo.Pic3ID, clubInfo.Pic4ID, clubInfo.Pic5ID, clubInfo.Pic6ID)
+		tagIds, gallery, err := s.getClubTagIdsAndGallery(clubInfo.ClubID)
 		if err != nil {
 			log.Error(err)
 			ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 			return
 		}
 
-		post := constructClubInfoCountPost(clubInfo, tagIds, pictureIds)
+		post := constructClubInfoCountPost(clubInfo, tagIds, gallery)
 		responseInfo = append(responseInfo, *post)
 	}
 
@@ -979,7 +2038,7 @@ func listAllClubs(ctx *gin.Context) {
 	}
 
 	//this is pagination query, get total size
-	totalSize, err := db.GetClubInfoNumByCondition(condition)
+	totalSize, err := s.repos.Clubs.GetClubInfoNumByCondition(condition)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
@@ -996,7 +2055,7 @@ func listAllClubs(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(pageResult))
 }
 
-func constructClubInfoCountPost(clubInfo db.ClubInfoCount, tagIDs []string, pictureIDs []string) *ClubInfoCountPost {
+func constructClubInfoCountPost(clubInfo db.ClubInfoCount, tagIDs []string, gallery []db.ClubPicture) *ClubInfoCountPost {
 	clubInfoPost := ClubInfoPost{
 		ClubID:      clubInfo.ClubID,
 		Name:        clubInfo.Name,
@@ -1008,12 +2067,14 @@ func constructClubInfoCountPost(clubInfo db.ClubInfoCount, tagIDs []string, pict
 		Description: clubInfo.Description,
 		LogoId:      clubInfo.LogoID,
 		TagIds:      tagIDs,
-		PictureIds:  pictureIDs,
+		PictureIds:  getGalleryPictureIDs(gallery),
+		Gallery:     constructGalleryPosts(gallery),
 	}
 	post := ClubInfoCountPost{
 		ClubInfoPost: clubInfoPost,
-		FavouriteNum: clubInfo.FavouriteNum,
-		ViewNum:      clubInfo.ViewNum,
+		FavouriteNum:    clubInfo.FavouriteNum,
+		ViewNum:         clubInfo.ViewNum,
+		UniqueViewerNum: clubInfo.UniqueViewerNum,
 	}
 	return &post
 }
@@ -1103,19 +2164,9 @@ func getClubInfoConditionFromRequest(ctx *gin.Context) (*db.ClubInfoCondition, b
 	return &condition, pagination, nil
 }
 
-func getAccountByUserId(ctx *gin.Context) {
-	//check admin or not
-	account, err := getAdminUser(ctx)
-	if err != nil {
-		return
-	}
-	if !account.IsAdmin {
-		ctx.JSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.NO_PERMISSION, nil))
-		return
-	}
-
+func (s *server) getAccountByUserId(ctx *gin.Context) {
 	userId := ctx.Param("userId")
-	account, err = db.GetAccountByUserId(userId)
+	account, err := s.repos.Accounts.GetAccountByUserId(userId)
 	if gorm.IsRecordNotFoundError(err) {
 		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.NOT_FOUND, nil))
 		return
@@ -1128,17 +2179,7 @@ func getAccountByUserId(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(account))
 }
 
-func listAllAccounts(ctx *gin.Context) {
-	//check admin or not
-	account, err := getAdminUser(ctx)
-	if err != nil {
-		return
-	}
-	if !account.IsAdmin {
-		ctx.JSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.NO_PERMISSION, nil))
-		return
-	}
-
+func (s *server) listAllAccounts(ctx *gin.Context) {
 	//query account info
 	condition, pagination, err := getAccountInfoConditionFromRequest(ctx)
 	if err != nil {
@@ -1147,7 +2188,7 @@ func listAllAccounts(ctx *gin.Context) {
 		return
 	}
 
-	accounts, err := db.GetAllAccountInfoByCondition(condition)
+	accounts, err := s.repos.Accounts.GetAllAccountInfoByCondition(condition)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
@@ -1160,7 +2201,7 @@ func listAllAccounts(ctx *gin.Context) {
 	}
 
 	//this is a pagination query
-	totalSize, err := db.GetTotalAccountNum()
+	totalSize, err := s.repos.Accounts.GetTotalAccountNum()
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
@@ -1186,111 +2227,631 @@ func genAuthString() string {
 	return str1 + str2
 }
 
-type NewClubAccountPost struct {
-	Email    string `json:"email"`
-	PhoneNum string `json:"phone_num"`
-	Note     string `json:"note"`
+// Returns the hash under which an auth string is stored
+func (s *server) hashAuthString(authString string) string {
+	return auth.HashSecret([]byte(s.config.AdminAuth.AuthHashKey), authString)
 }
 
-//creates a club account and its club info.
-func createNewClubAccount(ctx *gin.Context) {
-	//check admin or not
-	account, err := getAdminUser(ctx)
-	if err != nil {
-		return
-	}
-	if !account.IsAdmin {
-		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.NO_PERMISSION, nil))
-		return
-	}
+type RolePost struct {
+	Role string `json:"role"`
+}
 
-	//obtain and simply check request body param
-	newClub := new(NewClubAccountPost)
-	if err := ctx.ShouldBindJSON(newClub); err != nil {
+//Changes the role of an account. The account is logged out so the new role applies right away.
+func (s *server) setAccountRole(ctx *gin.Context) {
+	var rolePost RolePost
+	if err := ctx.ShouldBindJSON(&rolePost); err != nil {
+		log.Error(err)
 		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
 		return
 	}
-	if len(newClub.Email) == 0 || len(newClub.PhoneNum) == 0 || len(newClub.Note) == 0 || len(newClub.Note) > 200 {
+	if !db.IsValidRole(rolePost.Role) {
 		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
 		return
 	}
 
-	//construct account and club info
-	clubAccount := db.AdminAccount{
-		AccountID:  uuid.New().String(),
-		AuthString: genAuthString(),
-		ClubID:     uuid.New().String(),
-		Email:      newClub.Email,
-		PhoneNum:   newClub.PhoneNum,
-		Note:       newClub.Note,
-		IsAdmin:    false,
+	target, err := s.repos.Accounts.GetAccountByUserId(ctx.Param("id"))
+	if gorm.IsRecordNotFoundError(err) {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.NOT_FOUND, nil))
+		return
 	}
-
-	clubInfo := db.ClubInfo{
-		ClubID: clubAccount.ClubID,
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
 	}
 
-	//create transaction to insert account and club info
-	txDb := db.DB.Begin()
-	err = clubAccount.Insert(txDb)
+	err = s.repos.Accounts.UpdateAccountRole(target.AccountID, rolePost.Role)
 	if err != nil {
-		txDb.Rollback()
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
-	err = clubInfo.Insert(txDb)
+	err = s.sessionStore.RevokeAccount(target.AccountID)
 	if err != nil {
-		txDb.Rollback()
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
-	txDb.Commit()
 
-	//response account created
-	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(clubAccount))
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(nil))
 }
 
-func Pong(c *gin.Context) {
-	c.JSON(
-		http.StatusOK,
-		httpserver.ConstructResponse(httpserver.SUCCESS, gin.H{
-			"message": "pong",
-		}))
+//Suspends an account and logs it out everywhere. It can not log in until reactivated.
+func (s *server) suspendAccount(ctx *gin.Context) {
+	s.setAccountSuspended(ctx, true)
 }
 
-type LoginPost struct {
-	AuthToken string `json:"auth_token"`
+func (s *server) reactivateAccount(ctx *gin.Context) {
+	s.setAccountSuspended(ctx, false)
 }
 
-// Handles Admin Login
-func Login(c *gin.Context) {
-	loginPost := new(LoginPost)
-	if err := c.ShouldBindJSON(loginPost); err != nil {
-		c.JSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.AUTH_FAILED, nil))
-		log.Print(err)
+func (s *server) setAccountSuspended(ctx *gin.Context, suspended bool) {
+	account, err := getAdminUser(ctx)
+	if err != nil {
 		return
 	}
-
-	var Account db.AdminAccount
-	if err := db.DB.Where("auth_string = ?", loginPost.AuthToken).First(&Account).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.AUTH_FAILED, nil))
-		log.Print(err)
+	targetID := ctx.Param("id")
+	if targetID == account.AccountID {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, "can not change own account"))
 		return
 	}
 
-	// Save the username in the session
-	session := sessions.Default(c)
-	session.Set(USER, Account)
-	if err := session.Save(); err != nil {
-		c.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+	target, err := s.repos.Accounts.GetAccountByUserId(targetID)
+	if gorm.IsRecordNotFoundError(err) {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.NOT_FOUND, nil))
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
 
-	c.JSON(http.StatusOK, httpserver.SuccessResponse(Account))
-}
-
+	err = s.repos.Accounts.UpdateAccountSuspended(target.AccountID, suspended)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	if suspended {
+		err = s.sessionStore.RevokeAccount(target.AccountID)
+		if err != nil {
+			log.Error(err)
+			ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(nil))
+}
+
+//Deletes an account with DeleteAccountCascade, and drops the pictures of its club from the picture cache, as
+//deleting the last manager archives them.
+func (s *server) deleteAccountCascade(accountID string) error {
+	account, err := s.repos.Accounts.GetAccountByUserId(accountID)
+	if err != nil {
+		return err
+	}
+	pictures := make([]db.AccountPicture, 0)
+	if account.ClubID != "" {
+		pictures, err = s.repos.Pictures.GetClubPictureIDs(account.ClubID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.repos.Accounts.DeleteAccountCascade(accountID)
+	if err != nil {
+		return err
+	}
+	for _, accountPicture := range pictures {
+		s.pictureCache.Remove(accountPicture.PictureID)
+	}
+	return nil
+}
+
+//Deletes an account. Deleting the last manager of a club unpublishes the club and archives its pictures.
+func (s *server) deleteAccount(ctx *gin.Context) {
+	account, err := getAdminUser(ctx)
+	if err != nil {
+		return
+	}
+	targetID := ctx.Param("id")
+	if targetID == account.AccountID {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, "can not delete own account"))
+		return
+	}
+
+	err = s.deleteAccountCascade(targetID)
+	if gorm.IsRecordNotFoundError(err) {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.NOT_FOUND, nil))
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	err = s.sessionStore.RevokeAccount(targetID)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(nil))
+}
+
+//AccountAuthResponse carries a newly generated auth string. It is only ever shown once.
+type AccountAuthResponse struct {
+	db.AdminAccount
+	AuthString string `json:"auth_string"`
+}
+
+//Replaces the auth string of an account and logs the account out everywhere.
+func (s *server) rotateAccountAuthString(ctx *gin.Context) {
+	target, err := s.repos.Accounts.GetAccountByUserId(ctx.Param("id"))
+	if gorm.IsRecordNotFoundError(err) {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.NOT_FOUND, nil))
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	authString := genAuthString()
+	err = s.repos.Accounts.UpdateAccountAuthHash(target.AccountID, s.hashAuthString(authString))
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	//existing sessions were opened with the old auth string
+	err = s.sessionStore.RevokeAccount(target.AccountID)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(AccountAuthResponse{AdminAccount: *target, AuthString: authString}))
+}
+
+type NewClubAccountPost struct {
+	Email    string `json:"email"`
+	PhoneNum string `json:"phone_num"`
+	Note     string `json:"note"`
+}
+
+//creates a club account and its club info.
+func (s *server) createNewClubAccount(ctx *gin.Context) {
+	//obtain and simply check request body param
+	newClub := new(NewClubAccountPost)
+	if err := ctx.ShouldBindJSON(newClub); err != nil {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
+		return
+	}
+	if len(newClub.Email) == 0 || len(newClub.PhoneNum) == 0 || len(newClub.Note) == 0 || len(newClub.Note) > 200 {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
+		return
+	}
+
+	//construct account and club info, only the hash of the auth string is stored
+	authString := genAuthString()
+	clubAccount := db.AdminAccount{
+		AccountID: uuid.New().String(),
+		AuthHash:  s.hashAuthString(authString),
+		ClubID:    uuid.New().String(),
+		Email:     newClub.Email,
+		PhoneNum:  newClub.PhoneNum,
+		Note:      newClub.Note,
+		IsAdmin:   false,
+		Role:      db.ROLE_CLUB_MANAGER,
+	}
+
+	//insert account together with its club, which it owns
+	err := s.repos.Accounts.CreateClubAccount(&clubAccount)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	//response account created, this is the only time the auth string is shown
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(AccountAuthResponse{AdminAccount: clubAccount, AuthString: authString}))
+}
+
+func Pong(c *gin.Context) {
+	c.JSON(
+		http.StatusOK,
+		httpserver.ConstructResponse(httpserver.SUCCESS, gin.H{
+			"message": "pong",
+		}))
+}
+
+type LoginPost struct {
+	AuthToken string `json:"auth_token"`
+}
+
+// Handles Admin Login
+func (s *server) Login(c *gin.Context) {
+	ip := s.clientIP(c)
+	if allowed, wait := s.loginThrottle.Allow(ip, time.Now()); !allowed {
+		s.loginThrottled(c, wait)
+		return
+	}
+
+	loginPost := new(LoginPost)
+	if err := c.ShouldBindJSON(loginPost); err != nil {
+		s.loginFailed(c)
+		log.Print(err)
+		return
+	}
+
+	if loginPost.AuthToken == "" {
+		s.loginFailed(c)
+		return
+	}
+
+	Account, err := s.repos.Accounts.GetAccountByAuthHash(s.hashAuthString(loginPost.AuthToken))
+	if gorm.IsRecordNotFoundError(err) {
+		s.loginFailed(c)
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		c.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	if Account.Suspended {
+		s.recordLoginAttempt(c, Account.AccountID, db.LOGIN_SUSPENDED)
+		c.JSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.ACCOUNT_SUSPENDED, nil))
+		return
+	}
+
+	// Save the username in the session
+	if err := startAdminSession(c, Account); err != nil {
+		log.Error(err)
+		c.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	s.loginThrottle.Success(ip, time.Now())
+	s.recordLoginAttempt(c, Account.AccountID, db.LOGIN_SUCCESS)
+	c.JSON(http.StatusOK, httpserver.SuccessResponse(Account))
+}
+
+// Logs the account in with the admin session of current request. The session gets a new id, so one planted
+// before login can not be used to act as the account.
+func startAdminSession(c *gin.Context, account *db.AdminAccount) error {
+	session := sessions.Default(c)
+	session.Set(USER, *account)
+	sessionstore.Renew(session)
+	return session.Save()
+}
+
+// Prefix of the QR code content, so the app recognises login codes
+const QR_LOGIN_PREFIX = "tfc-login:"
+
+// Longest time a browser waits for an approval in one request
+const QR_POLL_TIMEOUT = 25 * time.Second
+
+// Carries the poll token when waiting for a challenge, a query parameter would end up in access logs and history
+const QR_POLL_TOKEN_HEADER = "X-Poll-Token"
+
+type QRChallengeResponse struct {
+	ChallengeID string `json:"challenge_id"`
+	// Only given to the browser, it is needed to wait for the challenge
+	PollToken string    `json:"poll_token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// PNG QR code as data URI
+	QRImage string `json:"qr_image"`
+}
+
+//Starts a QR code login. The returned QR code is scanned by a logged in device, which approves the challenge.
+func (s *server) createQRLoginChallenge(ctx *gin.Context) {
+	ttl := s.config.AdminAuth.QRChallengeTTL
+	if ttl <= 0 {
+		ttl = 2 * time.Minute
+	}
+
+	challengeID := uuid.New().String()
+	pollToken := uuid.New().String()
+	png, err := qrcode.Encode(QR_LOGIN_PREFIX+challengeID, qrcode.Medium, 256)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	challenge := db.LoginChallenge{
+		ChallengeID: challengeID,
+		PollHash:    s.hashAuthString(pollToken),
+		Status:      db.CHALLENGE_PENDING,
+		ExpiresAt:   time.Now().Add(ttl),
+	}
+	err = s.repos.Logins.InsertLoginChallenge(&challenge)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(QRChallengeResponse{
+		ChallengeID: challengeID,
+		PollToken:   pollToken,
+		ExpiresAt:   challenge.ExpiresAt,
+		QRImage:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}))
+}
+
+//Approves a scanned QR code login with the account logged in on this device.
+func (s *server) approveQRLoginChallenge(ctx *gin.Context) {
+	account, err := getAdminUser(ctx)
+	if err != nil {
+		return
+	}
+
+	approved, err := s.repos.Logins.ApproveLoginChallenge(ctx.Param("challengeID"), account.AccountID, time.Now())
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	if !approved {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.LOGIN_CHALLENGE_EXPIRED, nil))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(nil))
+}
+
+//Long polls a QR code login challenge. Once approved, the browser is logged in exactly as with Login.
+//Responds LOGIN_PENDING when nothing happened within QR_POLL_TIMEOUT, the browser then polls again.
+func (s *server) waitQRLoginChallenge(ctx *gin.Context) {
+	challengeID := ctx.Param("challengeID")
+	challenge, err := s.repos.Logins.GetLoginChallengeByID(challengeID)
+	if gorm.IsRecordNotFoundError(err) {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	//only the browser that created the challenge may wait for it
+	pollHash := s.hashAuthString(ctx.GetHeader(QR_POLL_TOKEN_HEADER))
+	if subtle.ConstantTimeCompare([]byte(pollHash), []byte(challenge.PollHash)) != 1 {
+		ctx.JSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.NOT_AUTHORIZED, nil))
+		return
+	}
+
+	deadline := time.Now().Add(QR_POLL_TIMEOUT)
+	for {
+		now := time.Now()
+		if challenge.Status == db.CHALLENGE_CONSUMED || now.After(challenge.ExpiresAt) {
+			ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.LOGIN_CHALLENGE_EXPIRED, nil))
+			return
+		}
+		if challenge.Status == db.CHALLENGE_APPROVED {
+			s.finishQRLogin(ctx, challenge)
+			return
+		}
+		if now.After(deadline) {
+			ctx.JSON(http.StatusOK, httpserver.ConstructResponse(httpserver.LOGIN_PENDING, nil))
+			return
+		}
+
+		select {
+		case <-ctx.Request.Context().Done():
+			return
+		case <-time.After(time.Second):
+		}
+
+		challenge, err = s.repos.Logins.GetLoginChallengeByID(challengeID)
+		if err != nil {
+			log.Error(err)
+			ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+			return
+		}
+	}
+}
+
+// Consumes an approved challenge and logs the approving account in.
+func (s *server) finishQRLogin(ctx *gin.Context, challenge *db.LoginChallenge) {
+	consumed, err := s.repos.Logins.ConsumeLoginChallenge(challenge.ChallengeID, time.Now())
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	if !consumed {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.LOGIN_CHALLENGE_EXPIRED, nil))
+		return
+	}
+
+	account, err := s.repos.Accounts.GetAccountByUserId(challenge.ApprovedBy)
+	if gorm.IsRecordNotFoundError(err) {
+		ctx.JSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.AUTH_FAILED, nil))
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	if account.Suspended {
+		s.recordLoginAttempt(ctx, account.AccountID, db.LOGIN_SUSPENDED)
+		ctx.JSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.ACCOUNT_SUSPENDED, nil))
+		return
+	}
+
+	if err := startAdminSession(ctx, account); err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	s.recordLoginAttempt(ctx, account.AccountID, db.LOGIN_SUCCESS)
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(account))
+}
+
+// Counts a failed login towards throttling and responds auth failed.
+func (s *server) loginFailed(c *gin.Context) {
+	s.loginThrottle.Failure(s.clientIP(c), time.Now())
+	s.recordLoginAttempt(c, "", db.LOGIN_FAILED)
+	c.JSON(http.StatusUnauthorized, httpserver.ConstructResponse(httpserver.AUTH_FAILED, nil))
+}
+
+func (s *server) loginThrottled(c *gin.Context, wait time.Duration) {
+	s.recordLoginAttempt(c, "", db.LOGIN_THROTTLED)
+	c.Header("Retry-After", strconv.FormatInt(int64(wait/time.Second)+1, 10))
+	c.JSON(http.StatusTooManyRequests, httpserver.ConstructResponse(httpserver.TOO_MANY_ATTEMPTS, nil))
+}
+
+// IP of the client of current request. Headers naming another client are only followed from trusted proxies.
+func (s *server) clientIP(c *gin.Context) string {
+	return auth.ClientIP(c.Request.RemoteAddr, c.GetHeader("X-Forwarded-For"), s.trustedProxies)
+}
+
+// Headers never dumped into login history
+var sensitiveHeaders = map[string]bool{"Cookie": true, "Authorization": true, QR_POLL_TOKEN_HEADER: true}
+
+const HEADER_DUMP_MAX_LEN = 1000
+
+// Saves a login attempt into login history. Failing to do so does not fail the login.
+func (s *server) recordLoginAttempt(c *gin.Context, accountID, result string) {
+	names := make([]string, 0, len(c.Request.Header))
+	for name := range c.Request.Header {
+		if !sensitiveHeaders[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var dump strings.Builder
+	for _, name := range names {
+		dump.WriteString(name + ": " + strings.Join(c.Request.Header[name], ", ") + "\n")
+	}
+	headerDump := dump.String()
+	if len(headerDump) > HEADER_DUMP_MAX_LEN {
+		headerDump = headerDump[:HEADER_DUMP_MAX_LEN]
+	}
+
+	history := db.LoginHistory{
+		Username:      accountID,
+		IP:            s.clientIP(c),
+		AttemptResult: result,
+		HeaderDump:    headerDump,
+	}
+	if err := s.repos.Logins.InsertLoginHistory(&history); err != nil {
+		log.Error(err)
+	}
+}
+
+func newLoginThrottle(conf config.AdminAuth) *auth.LoginThrottle {
+	if conf.MaxFailuresPerIP <= 0 {
+		conf.MaxFailuresPerIP = 5
+	}
+	if conf.MaxFailuresGlobal <= 0 {
+		conf.MaxFailuresGlobal = 100
+	}
+	if conf.ThrottleBaseDelay <= 0 {
+		conf.ThrottleBaseDelay = time.Second
+	}
+	if conf.ThrottleMaxDelay <= 0 {
+		conf.ThrottleMaxDelay = 15 * time.Minute
+	}
+	if conf.ThrottleWindow <= 0 {
+		conf.ThrottleWindow = 15 * time.Minute
+	}
+	if conf.ThrottleTrustSuccessFor <= 0 {
+		conf.ThrottleTrustSuccessFor = 24 * time.Hour
+	}
+	return auth.NewLoginThrottle(conf.MaxFailuresPerIP, conf.MaxFailuresGlobal,
+		conf.ThrottleBaseDelay, conf.ThrottleMaxDelay, conf.ThrottleWindow, conf.ThrottleTrustSuccessFor)
+}
+
+// Page size of login history when none is requested
+const LOGIN_HISTORY_PAGE_SIZE = 50
+
+//Returns login history, latest first, always paginated.
+func (s *server) listLoginHistory(ctx *gin.Context) {
+	condition, err := getLoginHistoryConditionFromRequest(ctx)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
+		return
+	}
+
+	histories, err := s.repos.Logins.GetLoginHistoryByCondition(condition)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	totalSize, err := s.repos.Logins.GetLoginHistoryNumByCondition(condition)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	pageResult := PageResult{
+		CurrPage:   condition.CurrPage,
+		PageSize:   condition.PageSize,
+		TotalSize:  totalSize,
+		TotalPages: getTotalPages(condition.Limit, totalSize),
+		Content:    histories,
+	}
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(pageResult))
+}
+
+func getLoginHistoryConditionFromRequest(ctx *gin.Context) (*db.LoginHistoryCondition, error) {
+	var condition db.LoginHistoryCondition
+	pageRequest, pagination, err := tryToGetPageRequest(ctx)
+	if err != nil {
+		return &condition, err
+	}
+	if !pagination {
+		pageRequest = &db.PageRequest{CurrPage: 1, PageSize: LOGIN_HISTORY_PAGE_SIZE, Limit: LOGIN_HISTORY_PAGE_SIZE}
+	}
+	condition.PageRequest = *pageRequest
+
+	condition.Username = ctx.Query("username")
+	condition.IP = ctx.Query("ip")
+
+	//if attempt result set
+	result := ctx.Query("result")
+	if result != "" && result != db.LOGIN_SUCCESS && result != db.LOGIN_FAILED &&
+		result != db.LOGIN_THROTTLED && result != db.LOGIN_SUSPENDED {
+		return &condition, errors.New("invalid attempt result " + result)
+	}
+	condition.AttemptResult = result
+
+	//time range, RFC3339
+	if from := ctx.Query("from"); from != "" {
+		condition.From, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			return &condition, err
+		}
+	}
+	if to := ctx.Query("to"); to != "" {
+		condition.To, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			return &condition, err
+		}
+	}
+
+	return &condition, nil
+}
+
 type ClubInfoPost struct {
 	ClubID      string   `json:"club_id" binding:"required"`
 	Name        string   `json:"name"`
@@ -1302,23 +2863,320 @@ type ClubInfoPost struct {
 	Description string   `json:"description"`
 	LogoId      string   `json:"logo_id"`
 	TagIds      []string `json:"tag_ids"`
-	PictureIds  []string `json:"picture_ids"`
+	// Gallery picture IDs in their order. Ignored on update when Gallery is sent.
+	PictureIds []string             `json:"picture_ids"`
+	Gallery    []GalleryPicturePost `json:"gallery"`
+}
+
+type GalleryPicturePost struct {
+	PictureID string `json:"picture_id"`
+	// Ignored on update, the order of the gallery counts
+	Position int    `json:"position"`
+	Caption  string `json:"caption"`
+	Cover    bool   `json:"cover"`
+}
+
+type ClubInfoCountPost struct {
+	ClubInfoPost
+	FavouriteNum int64 `json:"favourite_num"`
+	// Impressions, a club read again in the same view list counts once
+	ViewNum         int64 `json:"view_num"`
+	UniqueViewerNum int64 `json:"unique_viewer_num"`
+}
+
+const (
+	CLUB_NAME_MAX_LEN = 100
+	CLUB_TAG_MAX_NUM  = 4
+)
+
+// Maximum number of gallery pictures of a club, 6 unless configured.
+func (s *server) clubPictureLimit() int {
+	if s.config.General.ClubPictureLimit <= 0 {
+		return 6
+	}
+	return s.config.General.ClubPictureLimit
+}
+
+//Builds the gallery to store from a club info update. Clients sending only picture IDs keep the captions and
+//cover they set before. Without a cover, the first picture becomes the cover.
+func (s *server) getGalleryFromRequest(clubInfoPost *ClubInfoPost) ([]db.ClubPicture, error) {
+	galleryPosts := clubInfoPost.Gallery
+	if len(galleryPosts) == 0 && len(clubInfoPost.PictureIds) > 0 {
+		current, err := s.repos.Clubs.GetClubGallery(clubInfoPost.ClubID)
+		if err != nil {
+			return nil, err
+		}
+		currentByID := make(map[string]db.ClubPicture)
+		for _, clubPicture := range current {
+			currentByID[clubPicture.PictureID] = clubPicture
+		}
+		for _, pid := range clubInfoPost.PictureIds {
+			galleryPosts = append(galleryPosts, GalleryPicturePost{
+				PictureID: pid,
+				Caption:   currentByID[pid].Caption,
+				Cover:     currentByID[pid].Cover,
+			})
+		}
+	}
+
+	gallery := make([]db.ClubPicture, 0, len(galleryPosts))
+	pictureIDs := set.NewSet()
+	hasCover := false
+	for idx, post := range galleryPosts {
+		if post.PictureID == "" || !pictureIDs.Add(post.PictureID) {
+			return nil, errors.New("empty or duplicate picture")
+		}
+		if len(post.Caption) > PICTURE_CAPTION_MAX_LEN {
+			return nil, errors.New("caption too long")
+		}
+		if post.Cover && hasCover {
+			return nil, errors.New("more than one cover")
+		}
+		hasCover = hasCover || post.Cover
+		gallery = append(gallery, db.ClubPicture{
+			PictureID: post.PictureID,
+			Position:  idx + 1,
+			Caption:   post.Caption,
+			Cover:     post.Cover,
+		})
+	}
+	if !hasCover && len(gallery) > 0 {
+		gallery[0].Cover = true
+	}
+	return gallery, nil
 }
 
-type ClubInfoCountPost struct {
-	ClubInfoPost
-	FavouriteNum int64 `json:"favourite_num"`
-	ViewNum      int64 `json:"view_num"`
-}
+//Checks the account manages the club, responds FORBIDDEN when not.
+func checkClubOwnership(ctx *gin.Context, account *db.AdminAccount, clubID string) bool {
+	if account.ClubID == "" || account.ClubID != clubID {
+		ctx.JSON(http.StatusForbidden, httpserver.ConstructResponse(httpserver.FORBIDDEN, nil))
+		return false
+	}
+	return true
+}
+
+//Returns the membership of the account in its club, responds FORBIDDEN when the account is no manager of it.
+func (s *server) getClubMembership(ctx *gin.Context, account *db.AdminAccount) (*db.ClubMembership, bool) {
+	membership, err := s.repos.Accounts.GetClubMembership(account.ClubID, account.AccountID)
+	if gorm.IsRecordNotFoundError(err) {
+		ctx.JSON(http.StatusForbidden, httpserver.ConstructResponse(httpserver.FORBIDDEN, nil))
+		return nil, false
+	}
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return nil, false
+	}
+	return membership, true
+}
+
+//Lists all managers of the club of current account.
+func (s *server) listClubManagers(ctx *gin.Context) {
+	account, err := getAdminUser(ctx)
+	if err != nil {
+		return
+	}
+	if _, ok := s.getClubMembership(ctx, account); !ok {
+		return
+	}
+
+	managers, err := s.repos.Accounts.GetClubManagers(account.ClubID)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(managers))
+}
+
+// How long an invite to manage a club stays valid
+const CLUB_INVITE_TTL = 7 * 24 * time.Hour
+
+type InvitePost struct {
+	Email string `json:"email"`
+}
+
+//Club owner invites a new manager by email.
+func (s *server) inviteClubManager(ctx *gin.Context) {
+	account, err := getAdminUser(ctx)
+	if err != nil {
+		return
+	}
+	membership, ok := s.getClubMembership(ctx, account)
+	if !ok {
+		return
+	}
+	if membership.Role != db.MEMBER_OWNER {
+		ctx.JSON(http.StatusForbidden, httpserver.ConstructResponse(httpserver.FORBIDDEN, nil))
+		return
+	}
+
+	var invitePost InvitePost
+	if err := ctx.ShouldBindJSON(&invitePost); err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
+		return
+	}
+	if !strings.Contains(invitePost.Email, "@") {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
+		return
+	}
+	if len(invitePost.Email) > 100 {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.EMAIL_TOO_LONG, nil))
+		return
+	}
+	//the invite link is only ever sent by email, so no invite is created that could not be delivered
+	if !s.mailer.Configured() {
+		log.Error("Club invites can not be sent, SMTP is not configured")
+		ctx.JSON(http.StatusServiceUnavailable, httpserver.ConstructResponse(httpserver.MAIL_NOT_CONFIGURED, nil))
+		return
+	}
+
+	token := genAuthString()
+	invite := db.ClubInvite{
+		InviteHash: s.hashAuthString(token),
+		ClubID:     account.ClubID,
+		Email:      invitePost.Email,
+		InvitedBy:  account.AccountID,
+		ExpiresAt:  time.Now().Add(CLUB_INVITE_TTL),
+	}
+	err = s.repos.Accounts.InsertClubInvite(&invite)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	err = s.sendClubInvite(invite.Email, token)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(nil))
+}
+
+// Emails the invite link. The token is a credential, it must never be logged.
+func (s *server) sendClubInvite(email, token string) error {
+	link := token
+	if s.config.Mail.InviteURL != "" {
+		link = fmt.Sprintf(s.config.Mail.InviteURL, token)
+	}
+
+	body := "You have been invited to manage a club on Tinder for Clubs.\n\n" +
+		"Open the following link within 7 days to create your account:\n" + link + "\n"
+	return s.mailer.Send(email, "Invitation to manage a club on Tinder for Clubs", body)
+}
+
+type AcceptInvitePost struct {
+	Token    string `json:"token"`
+	PhoneNum string `json:"phone_num"`
+}
+
+//Creates the account of an invited club manager. The auth string of the new account is only shown here.
+func (s *server) acceptClubInvite(ctx *gin.Context) {
+	var acceptPost AcceptInvitePost
+	if err := ctx.ShouldBindJSON(&acceptPost); err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
+		return
+	}
+	if acceptPost.Token == "" || len(acceptPost.PhoneNum) > 20 {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
+		return
+	}
+
+	inviteHash := s.hashAuthString(acceptPost.Token)
+	invite, err := s.repos.Accounts.GetClubInviteByHash(inviteHash)
+	if gorm.IsRecordNotFoundError(err) {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVITE_INVALID, nil))
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	authString := genAuthString()
+	clubAccount := db.AdminAccount{
+		AccountID: uuid.New().String(),
+		AuthHash:  s.hashAuthString(authString),
+		ClubID:    invite.ClubID,
+		Email:     invite.Email,
+		PhoneNum:  acceptPost.PhoneNum,
+		Note:      "Invited by " + invite.InvitedBy,
+		IsAdmin:   false,
+		Role:      db.ROLE_CLUB_MANAGER,
+	}
+	//accept invite and create account as editor of the club, an invite creates one account at most
+	accepted, err := s.repos.Accounts.AcceptClubInvite(inviteHash, &clubAccount, time.Now())
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	if !accepted {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVITE_INVALID, nil))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(AccountAuthResponse{AdminAccount: clubAccount, AuthString: authString}))
+}
+
+//Club owner revokes a co-manager. The co-manager account is removed and logged out.
+func (s *server) revokeClubManager(ctx *gin.Context) {
+	account, err := getAdminUser(ctx)
+	if err != nil {
+		return
+	}
+	membership, ok := s.getClubMembership(ctx, account)
+	if !ok {
+		return
+	}
+	if membership.Role != db.MEMBER_OWNER {
+		ctx.JSON(http.StatusForbidden, httpserver.ConstructResponse(httpserver.FORBIDDEN, nil))
+		return
+	}
+
+	//only editors of the same club can be revoked, never the owner
+	targetID := ctx.Param("accountID")
+	target, err := s.repos.Accounts.GetClubMembership(account.ClubID, targetID)
+	if gorm.IsRecordNotFoundError(err) {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.NOT_FOUND, nil))
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	if target.Role == db.MEMBER_OWNER {
+		ctx.JSON(http.StatusForbidden, httpserver.ConstructResponse(httpserver.FORBIDDEN, nil))
+		return
+	}
+
+	err = s.deleteAccountCascade(targetID)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
 
-const (
-	CLUB_NAME_MAX_LEN = 100
-	CLUB_PIC_MAX_NUM  = 6
-	CLUB_TAG_MAX_NUM  = 4
-)
+	err = s.sessionStore.RevokeAccount(targetID)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(nil))
+}
 
 //Club user updates their club info.
-func updateClubInfo(ctx *gin.Context) {
+func (s *server) updateClubInfo(ctx *gin.Context) {
 	account, err := getAdminUser(ctx)
 	if err != nil {
 		return
@@ -1330,11 +3188,19 @@ func updateClubInfo(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
 		return
 	}
+	if !checkClubOwnership(ctx, account, clubInfoPost.ClubID) {
+		return
+	}
 	if len(clubInfoPost.Name) == 0 || len(clubInfoPost.Name) > CLUB_NAME_MAX_LEN {
 		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
 		return
 	}
-	if len(clubInfoPost.PictureIds) > CLUB_PIC_MAX_NUM {
+	gallery, err := s.getGalleryFromRequest(&clubInfoPost)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, err.Error()))
+		return
+	}
+	if len(gallery) > s.clubPictureLimit() {
 		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.CLUB_PIC_NUM_ABOVE_LIMIT, nil))
 		return
 	}
@@ -1365,7 +3231,7 @@ func updateClubInfo(ctx *gin.Context) {
 
 	// Club tags
 	if len(clubInfoPost.TagIds) > 0 {
-		tags, err := db.GetClubTagsByTagIds(clubInfoPost.TagIds)
+		tags, err := s.repos.Clubs.GetClubTagsByTagIds(clubInfoPost.TagIds)
 		if err != nil {
 			log.Error(err)
 			ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
@@ -1377,11 +3243,10 @@ func updateClubInfo(ctx *gin.Context) {
 			return
 		}
 	}
-	log.Println(clubInfoPost.PictureIds)
 
-	// Club picture upload
-	if len(clubInfoPost.PictureIds) > 0 {
-		dbPictureIDs, err := db.GetAccPictureIDS(account.AccountID)
+	// Club picture upload, gallery pictures and logo must be uploaded by a manager of this club
+	if len(gallery) > 0 || clubInfoPost.LogoId != "" {
+		dbPictureIDs, err := s.repos.Pictures.GetClubPictureIDs(account.ClubID)
 
 		dbPictureIDsSet := set.NewSet()
 		for _, accPic := range dbPictureIDs {
@@ -1394,12 +3259,16 @@ func updateClubInfo(ctx *gin.Context) {
 			return
 		}
 		// Check for invalid IDs
-		for _, pid := range clubInfoPost.PictureIds {
-			if !dbPictureIDsSet.Contains(pid) {
+		for _, clubPicture := range gallery {
+			if !dbPictureIDsSet.Contains(clubPicture.PictureID) {
 				ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, "does not contain this picture"))
 				return
 			}
 		}
+		if clubInfoPost.LogoId != "" && !dbPictureIDsSet.Contains(clubInfoPost.LogoId) {
+			ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, "does not contain this logo"))
+			return
+		}
 	}
 
 	// Construct club information
@@ -1415,48 +3284,227 @@ func updateClubInfo(ctx *gin.Context) {
 		LogoID:      clubInfoPost.LogoId,
 	}
 
-	for idx, pid := range clubInfoPost.PictureIds {
-		// TODO 最好不要这么写，现在为了方便先这样
-		reflect.ValueOf(&clubInfo).Elem().FieldByName(fmt.Sprintf("Pic%dID", idx+1)).SetString(pid)
+	// Update club info, gallery and tags relationship in one transaction
+	err = s.repos.Clubs.UpdateClubInfo(&clubInfo, clubInfoPost.TagIds, gallery)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(nil))
+}
+
+// Where a picture is used by its club
+const (
+	PICTURE_USAGE_LOGO    = "LOGO"
+	PICTURE_USAGE_GALLERY = "GALLERY"
+	PICTURE_USAGE_UNUSED  = "UNUSED"
+)
+
+const (
+	PICTURE_CAPTION_MAX_LEN  = 200
+	PICTURE_ALT_TEXT_MAX_LEN = 300
+)
+
+type ClubPicturePost struct {
+	db.AccountPicture
+	Usage string `json:"usage"`
+	// Position in the gallery starting from 1, 0 when not in the gallery
+	GalleryPosition int `json:"gallery_position"`
+}
+
+type ClubPictureTextPost struct {
+	Caption string `json:"caption"`
+	AltText string `json:"alt_text"`
+}
+
+type ClubPictureOrderPost struct {
+	PictureIds []string `json:"picture_ids"`
+}
+
+func getPictureUsage(clubInfo *db.ClubInfo, gallery []db.ClubPicture, pictureID string) (string, int) {
+	if clubInfo.LogoID == pictureID {
+		return PICTURE_USAGE_LOGO, 0
+	}
+	for _, clubPicture := range gallery {
+		if clubPicture.PictureID == pictureID {
+			return PICTURE_USAGE_GALLERY, clubPicture.Position
+		}
 	}
+	return PICTURE_USAGE_UNUSED, 0
+}
 
-	txDb := db.DB.Begin()
-	err = clubInfo.Update(txDb)
+func (s *server) getClubInfoAndGallery(ctx *gin.Context, clubID string) (*db.ClubInfo, []db.ClubPicture, bool) {
+	clubInfo, err := s.repos.Clubs.GetClubInfoByClubId(clubID)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return nil, nil, false
+	}
+	gallery, err := s.repos.Clubs.GetClubGallery(clubID)
 	if err != nil {
 		log.Error(err)
-		txDb.Rollback()
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return nil, nil, false
+	}
+	return clubInfo, gallery, true
+}
+
+//Lists the pictures of the club of current account, latest first, with where they are used.
+func (s *server) listClubPictures(ctx *gin.Context) {
+	account, err := getAdminUser(ctx)
+	if err != nil {
 		return
 	}
 
-	// Update club tags relationship
-	if len(clubInfoPost.TagIds) > 0 {
-		// Clean up old associations
-		err := db.CleanAllTags(txDb, clubInfoPost.ClubID)
-		if err != nil {
-			log.Error(err)
-			txDb.Rollback()
-			ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
-			return
-		}
+	clubInfo, gallery, ok := s.getClubInfoAndGallery(ctx, account.ClubID)
+	if !ok {
+		return
+	}
+	pictures, err := s.repos.Pictures.GetClubPictures(account.ClubID)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
 
-		// Then insert latest relationship
-		for _, tagId := range clubInfoPost.TagIds {
-			relationship := db.ClubTagRelationship{
-				ClubID: clubInfo.ClubID,
-				TagID:  tagId,
-			}
-			err := relationship.Insert(txDb)
-			if err != nil {
-				log.Error(err)
-				txDb.Rollback()
-				ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
-				return
-			}
-		}
+	picturePosts := make([]ClubPicturePost, 0, len(pictures))
+	for _, accountPicture := range pictures {
+		usage, position := getPictureUsage(clubInfo, gallery, accountPicture.PictureID)
+		picturePosts = append(picturePosts, ClubPicturePost{
+			AccountPicture:  accountPicture,
+			Usage:           usage,
+			GalleryPosition: position,
+		})
+	}
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(picturePosts))
+}
+
+//Looks up a picture of the club of current account, responds INVALID_PICTURE_ID when there is none.
+func (s *server) getOwnClubPicture(ctx *gin.Context, account *db.AdminAccount) (*db.AccountPicture, bool) {
+	accountPicture, err := s.repos.Pictures.GetClubPicture(account.ClubID, ctx.Param("id"))
+	if gorm.IsRecordNotFoundError(err) || account.ClubID == "" {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PICTURE_ID, nil))
+		return nil, false
+	}
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return nil, false
+	}
+	return accountPicture, true
+}
+
+//Sets the caption and alt text of a picture.
+func (s *server) updateClubPicture(ctx *gin.Context) {
+	account, err := getAdminUser(ctx)
+	if err != nil {
+		return
+	}
+
+	var textPost ClubPictureTextPost
+	if err := ctx.ShouldBindJSON(&textPost); err != nil {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
+		return
+	}
+	if len(textPost.Caption) > PICTURE_CAPTION_MAX_LEN || len(textPost.AltText) > PICTURE_ALT_TEXT_MAX_LEN {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
+		return
+	}
+
+	accountPicture, ok := s.getOwnClubPicture(ctx, account)
+	if !ok {
+		return
+	}
+	accountPicture.Caption = textPost.Caption
+	accountPicture.AltText = textPost.AltText
+	err = s.repos.Pictures.UpdatePictureText(accountPicture)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(accountPicture))
+}
+
+//Deletes a picture the club does not use as logo or in its gallery.
+func (s *server) deleteClubPicture(ctx *gin.Context) {
+	account, err := getAdminUser(ctx)
+	if err != nil {
+		return
+	}
+
+	accountPicture, ok := s.getOwnClubPicture(ctx, account)
+	if !ok {
+		return
+	}
+	clubInfo, gallery, ok := s.getClubInfoAndGallery(ctx, account.ClubID)
+	if !ok {
+		return
+	}
+	if usage, _ := getPictureUsage(clubInfo, gallery, accountPicture.PictureID); usage != PICTURE_USAGE_UNUSED {
+		ctx.JSON(http.StatusConflict, httpserver.ConstructResponse(httpserver.PICTURE_IN_USE, usage))
+		return
+	}
+
+	deleted, err := s.repos.Pictures.DeleteUnusedPicture(accountPicture.PictureID)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	//taken into use since its usage was checked
+	if !deleted {
+		ctx.JSON(http.StatusConflict, httpserver.ConstructResponse(httpserver.PICTURE_IN_USE, nil))
+		return
+	}
+	s.pictureCache.Remove(accountPicture.PictureID)
+	// The picture is gone for the club already, leftover files are only logged
+	if err := s.deletePictureBlobs(accountPicture); err != nil {
+		log.Error(err)
+	}
+	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(nil))
+}
+
+//Changes the order of the gallery pictures. The same pictures must be sent, only their order may change.
+func (s *server) reorderClubPictures(ctx *gin.Context) {
+	account, err := getAdminUser(ctx)
+	if err != nil {
+		return
+	}
+
+	var orderPost ClubPictureOrderPost
+	if err := ctx.ShouldBindJSON(&orderPost); err != nil {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
+		return
+	}
+	gallery, err := s.repos.Clubs.GetClubGallery(account.ClubID)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
+	galleryIDs := set.NewSet()
+	for _, clubPicture := range gallery {
+		galleryIDs.Add(clubPicture.PictureID)
+	}
+	orderIDs := set.NewSet()
+	for _, pid := range orderPost.PictureIds {
+		orderIDs.Add(pid)
+	}
+	if len(orderPost.PictureIds) != orderIDs.Cardinality() || !galleryIDs.Equal(orderIDs) {
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, "not the pictures of the gallery"))
+		return
 	}
 
-	txDb.Commit()
+	err = s.repos.Clubs.ReorderClubGallery(account.ClubID, orderPost.PictureIds)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
 	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(nil))
 }
 
@@ -1465,7 +3513,7 @@ type UploadPicResponse struct {
 }
 
 // Upload a picture and return an ID
-func uploadSinglePicture(ctx *gin.Context) {
+func (s *server) uploadSinglePicture(ctx *gin.Context) {
 	account, err := getAdminUser(ctx)
 	if err != nil {
 		return
@@ -1478,14 +3526,6 @@ func uploadSinglePicture(ctx *gin.Context) {
 		return
 	}
 
-	// ensures what's uploaded is a picture
-	if !strings.HasSuffix(file.Filename, ".jpg") && !strings.HasSuffix(file.Filename, ".jpeg") {
-		log.Println(file.Filename)
-		log.Errorf("Uploaded file is %v. The extension does noe match jpg ir jpeg", file.Filename)
-		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.UPLOAD_TYPE_NOT_SUPPORTED, nil))
-		return
-	}
-
 	MaxFileSize := int64(1 << 20)
 	// Check file size limit
 	if file.Size > MaxFileSize {
@@ -1494,30 +3534,244 @@ func uploadSinglePicture(ctx *gin.Context) {
 		return
 	}
 
+	data, err := readUploadedFile(file, MaxFileSize)
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.INVALID_PARAMS, nil))
+		return
+	}
+
+	// ensures what's uploaded is a picture, whatever its file name says
+	format, img, err := picture.Detect(data, s.pictureUploadLimits())
+	if err == picture.ErrTooLarge {
+		log.Errorf("Uploaded picture %v is too large: %v", file.Filename, err)
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.PIC_DIMENSIONS_TOO_LARGE, nil))
+		return
+	}
+	if err == picture.ErrAnimated {
+		log.Errorf("Uploaded picture %v is animated", file.Filename)
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.PIC_ANIMATED, nil))
+		return
+	}
+	if err != nil {
+		log.Errorf("Uploaded file %v is not a supported picture: %v", file.Filename, err)
+		ctx.JSON(http.StatusBadRequest, httpserver.ConstructResponse(httpserver.UPLOAD_TYPE_NOT_SUPPORTED, nil))
+		return
+	}
+
+	// Only the pixels are kept, so no metadata gets published and no other file type can hide in the picture
+	sanitized, err := picture.Sanitize(data, format, img, s.pictureQuality(), s.pictureMaxDimension())
+	if err != nil {
+		log.Error(err)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+	format = sanitized.Format
+
 	fileUUID := uuid.New().String()
-	fileName := fileUUID + path.Ext(file.Filename)
-	basePath := path.Join(globalConfig.General.PictureStoragePath, fileName)
+	fileName := fileUUID + format.Extension
 
-	err = ctx.SaveUploadedFile(file, basePath)
+	err = s.blobStore.Put(fileName, sanitized.Data, format.MimeType)
 	if err != nil {
 		log.Error(err)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
 
+	err = s.savePictureVariants(fileUUID, sanitized.Image, format.MimeType)
+	if err != nil {
+		log.Error(err)
+		s.blobStore.Delete(fileName)
+		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
+		return
+	}
+
 	// Sanity check done. Save picture info into db,
 	pictureEntry := db.AccountPicture{
 		AccountID:   account.AccountID,
+		ClubID:      account.ClubID,
 		PictureID:   fileUUID,
 		PictureName: fileName,
+		MimeType:    format.MimeType,
+		ContentHash: hashPictureContent(sanitized.Data),
 	}
 
-	err = db.DB.Create(&pictureEntry).Error
+	err = s.repos.Pictures.InsertPicture(&pictureEntry)
 	if err != nil {
 		log.Error(err)
+		s.deletePictureBlobs(&pictureEntry)
 		ctx.JSON(http.StatusInternalServerError, httpserver.ConstructResponse(httpserver.SYSTEM_ERROR, nil))
 		return
 	}
 
 	ctx.JSON(http.StatusOK, httpserver.SuccessResponse(UploadPicResponse{Pid: fileUUID}))
 }
+
+// Jpeg quality uploads are re-encoded at, 90 unless configured.
+func (s *server) pictureQuality() int {
+	quality := s.config.General.PictureQuality
+	if quality <= 0 || quality > 100 {
+		return 90
+	}
+	return quality
+}
+
+// Uploads are scaled down to fit in a square of this size, 2048 unless configured.
+func (s *server) pictureMaxDimension() int {
+	if s.config.General.PictureMaxDimension <= 0 {
+		return 2048
+	}
+	return s.config.General.PictureMaxDimension
+}
+
+// Dimensions uploads may have, 8192px by 8192px and 40 megapixels unless configured.
+func (s *server) pictureUploadLimits() picture.Limits {
+	limits := picture.Limits{
+		MaxWidth:  s.config.General.PictureMaxUploadWidth,
+		MaxHeight: s.config.General.PictureMaxUploadHeight,
+		MaxPixels: s.config.General.PictureMaxUploadPixels,
+	}
+	if limits.MaxWidth <= 0 {
+		limits.MaxWidth = 8192
+	}
+	if limits.MaxHeight <= 0 {
+		limits.MaxHeight = 8192
+	}
+	if limits.MaxPixels <= 0 {
+		limits.MaxPixels = 40000000
+	}
+	return limits
+}
+
+// Stores the configured variants of a picture next to the original. Nothing is left behind when one fails.
+func (s *server) savePictureVariants(pictureID string, img image.Image, mimeType string) error {
+	variantMimeType := picture.VariantFormat(mimeType).MimeType
+	written := make([]string, 0, len(s.pictureVariants))
+	for _, variant := range s.pictureVariants {
+		data, err := picture.EncodeVariant(img, variant, mimeType)
+		if err == nil {
+			key := picture.VariantFileName(pictureID, variant.Name, mimeType)
+			err = s.blobStore.Put(key, data, variantMimeType)
+			written = append(written, key)
+		}
+		if err != nil {
+			for _, key := range written {
+				s.blobStore.Delete(key)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes the original and the variants of a picture from the storage.
+func (s *server) deletePictureBlobs(accountPicture *db.AccountPicture) error {
+	keys := []string{accountPicture.PictureName}
+	for _, variant := range s.pictureVariants {
+		keys = append(keys, picture.VariantFileName(accountPicture.PictureID, variant.Name, accountPicture.MimeType))
+	}
+	for _, key := range keys {
+		if err := s.blobStore.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deletes pictures uploaded before the given time that are not used by any club.
+func (s *server) collectUnusedPictures(before time.Time) error {
+	pictures, err := s.repos.Pictures.GetUnusedPicturesCreatedBefore(before)
+	if err != nil {
+		return err
+	}
+	deletedNum := 0
+	for i := range pictures {
+		// Pictures taken into use since they were found unused are kept, with their files
+		deleted, err := s.repos.Pictures.DeleteUnusedPicture(pictures[i].PictureID)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			continue
+		}
+		s.pictureCache.Remove(pictures[i].PictureID)
+		deletedNum++
+		// The picture is gone already, leftover files are only logged
+		if err := s.deletePictureBlobs(&pictures[i]); err != nil {
+			log.Error(err)
+		}
+	}
+	if deletedNum > 0 {
+		log.Printf("Deleted %d unused pictures", deletedNum)
+	}
+	return nil
+}
+
+func (s *server) findPictureVariant(name string) (picture.Variant, bool) {
+	for _, variant := range s.pictureVariants {
+		if variant.Name == name {
+			return variant, true
+		}
+	}
+	return picture.Variant{}, false
+}
+
+// Builds the picture storage from the configured backend.
+func newBlobStore(conf config.GlobalConfiguration) storage.BlobStore {
+	if conf.Storage.SignedURLs && conf.Storage.Backend != "s3" {
+		log.Fatal("storage signed-urls requires the s3 backend")
+	}
+	switch conf.Storage.Backend {
+	case "", "local":
+		if conf.General.PictureStoragePath == "" {
+			log.Fatal("general static-storage-path is not configured")
+		}
+		return storage.NewLocalStore(conf.General.PictureStoragePath)
+	case "s3":
+		store, err := storage.NewS3Store(conf.Storage.S3Endpoint, conf.Storage.S3Region, conf.Storage.S3Bucket,
+			conf.Storage.S3AccessKey, conf.Storage.S3SecretKey)
+		common.ErrFatalLog(err)
+		return store
+	default:
+		log.Fatalf("unknown storage backend %s", conf.Storage.Backend)
+	}
+	return nil
+}
+
+// Builds the picture variants from the configuration, the defaults when none are configured.
+func newPictureVariants(conf config.General) []picture.Variant {
+	if len(conf.PictureVariants) == 0 {
+		return picture.DefaultVariants
+	}
+
+	variants := make([]picture.Variant, 0, len(conf.PictureVariants))
+	names := set.NewSet()
+	for _, v := range conf.PictureVariants {
+		if v.Name == "" || strings.ContainsAny(v.Name, "/\\.") || v.MaxWidth <= 0 || v.MaxHeight <= 0 {
+			log.Fatalf("invalid picture variant %+v", v)
+		}
+		if !names.Add(v.Name) {
+			log.Fatalf("duplicate picture variant %s", v.Name)
+		}
+		variants = append(variants, picture.Variant{Name: v.Name, MaxWidth: v.MaxWidth, MaxHeight: v.MaxHeight})
+	}
+	return variants
+}
+
+// Reads an uploaded file, refusing more than maxSize bytes whatever the multipart header claims.
+func readUploadedFile(file *multipart.FileHeader, maxSize int64) ([]byte, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(src, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, errors.New("uploaded file larger than its declared size")
+	}
+	return data, nil
+}
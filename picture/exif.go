@@ -0,0 +1,194 @@
+package picture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"golang.org/x/image/draw"
+	"image"
+)
+
+// EXIF orientations, how the stored pixels have to be transformed to be displayed upright.
+const (
+	OrientationNormal      = 1
+	OrientationFlipH       = 2
+	OrientationRotate180   = 3
+	OrientationFlipV       = 4
+	OrientationTranspose   = 5
+	OrientationRotate90CW  = 6
+	OrientationTransverse  = 7
+	OrientationRotate90CCW = 8
+)
+
+const exifOrientationTag = 0x0112
+
+// ReadOrientation returns the EXIF orientation of a jpeg, png or webp picture, OrientationNormal when it has none.
+func ReadOrientation(data []byte) int {
+	tiff := findExif(data)
+	if tiff == nil {
+		return OrientationNormal
+	}
+	orientation := tiffOrientation(tiff)
+	if orientation < OrientationNormal || orientation > OrientationRotate90CCW {
+		return OrientationNormal
+	}
+	return orientation
+}
+
+// Returns the TIFF structure holding the EXIF data of the picture, or nil.
+func findExif(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return findJPEGExif(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return findPNGExif(data)
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return findWebPExif(data)
+	}
+	return nil
+}
+
+func findJPEGExif(data []byte) []byte {
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil
+		}
+		marker := data[pos+1]
+		// Start of scan, no more metadata segments
+		if marker == 0xDA {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil
+		}
+		segment := data[pos+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		pos = end
+	}
+	return nil
+}
+
+func findPNGExif(data []byte) []byte {
+	pos := 8
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		chunkType := string(data[pos+4 : pos+8])
+		end := pos + 8 + length
+		if length < 0 || end > len(data) {
+			return nil
+		}
+		if chunkType == "eXIf" {
+			return data[pos+8 : end]
+		}
+		if chunkType == "IDAT" || chunkType == "IEND" {
+			return nil
+		}
+		// Skip data and CRC
+		pos = end + 4
+	}
+	return nil
+}
+
+func findWebPExif(data []byte) []byte {
+	pos := 12
+	for pos+8 <= len(data) {
+		chunkType := string(data[pos : pos+4])
+		length := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + length
+		if length < 0 || end > len(data) {
+			return nil
+		}
+		if chunkType == "EXIF" {
+			return bytes.TrimPrefix(data[pos+8:end], []byte("Exif\x00\x00"))
+		}
+		// Chunks are padded to an even size
+		pos = end + length%2
+	}
+	return nil
+}
+
+// Reads the orientation tag from the first IFD of a TIFF structure, 0 when missing.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// ApplyOrientation transforms img so it is displayed upright without its EXIF orientation.
+// Pixels are copied as RGBA bytes, still it is cheapest to orient pictures after scaling them down.
+func ApplyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= OrientationNormal || orientation > OrientationRotate90CCW {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	src, ok := img.(*image.RGBA)
+	if !ok || bounds.Min != (image.Point{}) {
+		src = image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	}
+	// Orientations from 5 on swap width and height
+	dstWidth, dstHeight := width, height
+	if orientation >= OrientationTranspose {
+		dstWidth, dstHeight = height, width
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case OrientationFlipH:
+				dx, dy = width-1-x, y
+			case OrientationRotate180:
+				dx, dy = width-1-x, height-1-y
+			case OrientationFlipV:
+				dx, dy = x, height-1-y
+			case OrientationTranspose:
+				dx, dy = y, x
+			case OrientationRotate90CW:
+				dx, dy = height-1-y, x
+			case OrientationTransverse:
+				dx, dy = height-1-y, width-1-x
+			case OrientationRotate90CCW:
+				dx, dy = y, width-1-x
+			}
+			srcOffset, dstOffset := src.PixOffset(x, y), dst.PixOffset(dx, dy)
+			copy(dst.Pix[dstOffset:dstOffset+4], src.Pix[srcOffset:srcOffset+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,123 @@
+package picture
+
+import (
+	"bytes"
+	"errors"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported picture format")
+
+// Returned when the dimensions of a picture exceed the limits, it is not decoded then
+var ErrTooLarge = errors.New("picture dimensions too large")
+
+// Returned for gifs of more than one frame, stored pictures are still images
+var ErrAnimated = errors.New("animated pictures are not supported")
+
+// Limits bound the dimensions of pictures, so a small file can not make the server decode huge images.
+type Limits struct {
+	MaxWidth  int
+	MaxHeight int
+	// Width times height
+	MaxPixels int64
+}
+
+// Format of an uploaded picture, detected from its content.
+type Format struct {
+	// Name as registered with the image package, e.g. "png"
+	Name      string
+	MimeType  string
+	Extension string
+}
+
+var formats = map[string]Format{
+	"jpeg": {Name: "jpeg", MimeType: "image/jpeg", Extension: ".jpg"},
+	"png":  {Name: "png", MimeType: "image/png", Extension: ".png"},
+	"gif":  {Name: "gif", MimeType: "image/gif", Extension: ".gif"},
+	"webp": {Name: "webp", MimeType: "image/webp", Extension: ".webp"},
+}
+
+// Detect decodes the whole picture to make sure it is a valid image of a supported format.
+// The file name or the type claimed by the client are never trusted. The dimensions are read from the header
+// first, pictures exceeding the limits are refused with ErrTooLarge without being decoded. Animated gifs are refused
+// with ErrAnimated, only their first frame would be kept.
+func Detect(data []byte, limits Limits) (Format, image.Image, error) {
+	config, name, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Format{}, nil, ErrUnsupportedFormat
+	}
+	format, ok := formats[name]
+	if !ok || config.Width <= 0 || config.Height <= 0 {
+		return Format{}, nil, ErrUnsupportedFormat
+	}
+	if config.Width > limits.MaxWidth || config.Height > limits.MaxHeight ||
+		int64(config.Width)*int64(config.Height) > limits.MaxPixels {
+		return Format{}, nil, ErrTooLarge
+	}
+	if name == "gif" && isAnimatedGIF(data) {
+		return Format{}, nil, ErrAnimated
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Format{}, nil, ErrUnsupportedFormat
+	}
+	return format, img, nil
+}
+
+// Reports whether a gif has more than one frame, by walking its blocks without decoding them.
+func isAnimatedGIF(data []byte) bool {
+	// Header and logical screen descriptor, followed by the global color table if there is one
+	const screenDescriptorEnd = 13
+	if len(data) < screenDescriptorEnd {
+		return false
+	}
+	pos := screenDescriptorEnd
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&0x07 + 1)
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21:
+			// Extension: introducer, label and data sub-blocks
+			pos = skipGIFSubBlocks(data, pos+2)
+		case 0x2C:
+			frames++
+			if frames > 1 {
+				return true
+			}
+			// Image descriptor, the local color table, the LZW code size and data sub-blocks
+			if pos+10 > len(data) {
+				return false
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos = skipGIFSubBlocks(data, pos+1)
+		default:
+			// Trailer
+			return false
+		}
+	}
+	return false
+}
+
+// Returns the position after the sub-blocks starting at pos, which end with an empty one.
+func skipGIFSubBlocks(data []byte, pos int) int {
+	for pos < len(data) {
+		size := int(data[pos])
+		pos += 1 + size
+		if size == 0 {
+			return pos
+		}
+	}
+	return len(data)
+}
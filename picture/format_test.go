@@ -0,0 +1,139 @@
+package picture
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// 1x1 lossless WebP, the standard library can not encode WebP
+const tinyWebP = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+var testLimits = Limits{MaxWidth: 8192, MaxHeight: 8192, MaxPixels: 40000000}
+
+func testImage() image.Image {
+	img := image.NewPaletted(image.Rect(0, 0, 4, 3), color.Palette{color.White, color.Black})
+	img.SetColorIndex(1, 1, 1)
+	return img
+}
+
+func TestDetect(t *testing.T) {
+	var pngBuf, jpegBuf, gifBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegBuf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.Encode(&gifBuf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	webp, err := base64.StdEncoding.DecodeString(tinyWebP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		data      []byte
+		mimeType  string
+		extension string
+	}{
+		{pngBuf.Bytes(), "image/png", ".png"},
+		{jpegBuf.Bytes(), "image/jpeg", ".jpg"},
+		{gifBuf.Bytes(), "image/gif", ".gif"},
+		{webp, "image/webp", ".webp"},
+	}
+	for _, c := range cases {
+		format, img, err := Detect(c.data, testLimits)
+		if err != nil {
+			t.Errorf("%s: %v", c.mimeType, err)
+			continue
+		}
+		if format.MimeType != c.mimeType || format.Extension != c.extension {
+			t.Errorf("expected %s %s, got %+v", c.mimeType, c.extension, format)
+		}
+		if img == nil {
+			t.Errorf("%s: no image decoded", c.mimeType)
+		}
+	}
+}
+
+func TestDetect_Rejects(t *testing.T) {
+	var pngBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	truncated := pngBuf.Bytes()[:pngBuf.Len()/2]
+
+	for name, data := range map[string][]byte{
+		"empty":     {},
+		"text":      []byte("<?php echo 'not a picture'; ?>"),
+		"truncated": truncated,
+	} {
+		if _, _, err := Detect(data, testLimits); err != ErrUnsupportedFormat {
+			t.Errorf("%s: expected ErrUnsupportedFormat, got %v", name, err)
+		}
+	}
+}
+
+func TestDetect_RejectsAnimatedGIF(t *testing.T) {
+	frame := testImage().(*image.Paletted)
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Detect(buf.Bytes(), testLimits); err != ErrAnimated {
+		t.Errorf("expected ErrAnimated, got %v", err)
+	}
+
+	// A single frame with a local color table is a still picture
+	frame.Palette = color.Palette{color.Black, color.White, color.Gray{Y: 128}}
+	buf.Reset()
+	if err := gif.EncodeAll(&buf, &gif.GIF{Image: []*image.Paletted{frame}, Delay: []int{0},
+		Config: image.Config{ColorModel: color.Palette{color.White, color.Black}, Width: 4, Height: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Detect(buf.Bytes(), testLimits); err != nil {
+		t.Errorf("a still gif should be accepted, got %v", err)
+	}
+}
+
+func TestDetect_RejectsLargeDimensions(t *testing.T) {
+	limits := Limits{MaxWidth: 4, MaxHeight: 3, MaxPixels: 12}
+	for name, c := range map[string]struct {
+		width, height int
+		limits        Limits
+	}{
+		"too wide":        {5, 3, limits},
+		"too high":        {4, 4, limits},
+		"too many pixels": {4, 3, Limits{MaxWidth: 4, MaxHeight: 3, MaxPixels: 11}},
+	} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, c.width, c.height))); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := Detect(buf.Bytes(), c.limits); err != ErrTooLarge {
+			t.Errorf("%s: expected ErrTooLarge, got %v", name, err)
+		}
+	}
+
+	// Only the header is read, a bomb claiming huge dimensions is refused without decoding the pixels
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	bomb := buf.Bytes()
+	// Width and height lead the IHDR chunk, which follows the 8 byte signature and the chunk length and type
+	copy(bomb[16:24], []byte{0, 1, 0, 0, 0, 1, 0, 0})
+	binary.BigEndian.PutUint32(bomb[29:33], crc32.ChecksumIEEE(bomb[12:29]))
+	if _, _, err := Detect(bomb, testLimits); err != ErrTooLarge {
+		t.Errorf("bomb: expected ErrTooLarge, got %v", err)
+	}
+}
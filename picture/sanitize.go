@@ -0,0 +1,49 @@
+package picture
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+)
+
+// Sanitized is a picture rebuilt from its pixels only.
+type Sanitized struct {
+	Format Format
+	// Upright and scaled down, to generate variants from
+	Image image.Image
+	Data  []byte
+}
+
+// Sanitize scales an uploaded picture down to fit in maxDimension, applies its EXIF orientation and re-encodes it
+// at the given jpeg quality. Only the pixels survive: metadata such as GPS coordinates, as well as anything hidden
+// in or appended to the file, are dropped.
+// Pictures that may be transparent become png, everything else jpeg. A gif keeps its only frame, Detect refuses
+// animated ones.
+func Sanitize(data []byte, format Format, img image.Image, quality, maxDimension int) (*Sanitized, error) {
+	// The bounds are square, so scaling before turning the picture upright gives the same size
+	img = Resize(img, maxDimension, maxDimension)
+	img = ApplyOrientation(img, ReadOrientation(data))
+
+	outFormat := formats["jpeg"]
+	switch format.Name {
+	case "png", "gif":
+		outFormat = formats["png"]
+	case "webp":
+		if opaque, ok := img.(interface{ Opaque() bool }); ok && !opaque.Opaque() {
+			outFormat = formats["png"]
+		}
+	}
+
+	var buf bytes.Buffer
+	var err error
+	if outFormat.Name == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Sanitized{Format: outFormat, Image: img, Data: buf.Bytes()}, nil
+}
@@ -0,0 +1,137 @@
+package picture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// Builds a jpeg carrying an EXIF orientation and some private metadata, followed by a script.
+func jpegWithExif(t *testing.T, width, height, orientation int) []byte {
+	var buf bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tiff := []byte("II")
+	tiff = append(tiff, 42, 0, 8, 0, 0, 0)
+	// One IFD entry: orientation, SHORT, count 1
+	tiff = append(tiff, 1, 0, 0x12, 0x01, 3, 0, 1, 0, 0, 0, byte(orientation), 0, 0, 0, 0, 0, 0, 0)
+	tiff = append(tiff, []byte("GPS 52.3676N 4.9041E")...)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	data := append([]byte{}, buf.Bytes()[:2]...)
+	data = append(data, app1...)
+	data = append(data, buf.Bytes()[2:]...)
+	return append(data, []byte("<?php system($_GET['c']); ?>")...)
+}
+
+func TestReadOrientation(t *testing.T) {
+	if o := ReadOrientation(jpegWithExif(t, 4, 2, OrientationRotate90CW)); o != OrientationRotate90CW {
+		t.Errorf("expected orientation 6, got %d", o)
+	}
+	if o := ReadOrientation([]byte("not a picture")); o != OrientationNormal {
+		t.Errorf("expected normal orientation without exif, got %d", o)
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// Red top left pixel of a 3x2 picture
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+
+	cases := []struct {
+		orientation int
+		width       int
+		height      int
+		redX, redY  int
+	}{
+		{OrientationNormal, 3, 2, 0, 0},
+		{OrientationFlipH, 3, 2, 2, 0},
+		{OrientationRotate180, 3, 2, 2, 1},
+		{OrientationFlipV, 3, 2, 0, 1},
+		{OrientationTranspose, 2, 3, 0, 0},
+		{OrientationRotate90CW, 2, 3, 1, 0},
+		{OrientationTransverse, 2, 3, 1, 2},
+		{OrientationRotate90CCW, 2, 3, 0, 2},
+	}
+	// The same picture as part of a larger one of another type
+	large := image.NewNRGBA(image.Rect(0, 0, 5, 5))
+	large.Set(1, 1, color.NRGBA{R: 255, A: 255})
+	sub := large.SubImage(image.Rect(1, 1, 4, 3))
+	for _, src := range []image.Image{img, sub} {
+		for _, c := range cases {
+			oriented := ApplyOrientation(src, c.orientation)
+			bounds := oriented.Bounds()
+			if bounds.Dx() != c.width || bounds.Dy() != c.height {
+				t.Errorf("orientation %d: expected %dx%d, got %v", c.orientation, c.width, c.height, bounds)
+				continue
+			}
+			x, y := bounds.Min.X+c.redX, bounds.Min.Y+c.redY
+			if r, _, _, _ := oriented.At(x, y).RGBA(); r != 0xFFFF {
+				t.Errorf("orientation %d of %T: expected the red pixel at %d,%d", c.orientation, src, c.redX, c.redY)
+			}
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	data := jpegWithExif(t, 400, 200, OrientationRotate90CW)
+	format, img, err := Detect(data, testLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sanitized, err := Sanitize(data, format, img, 85, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sanitized.Format.MimeType != "image/jpeg" {
+		t.Errorf("expected jpeg, got %s", sanitized.Format.MimeType)
+	}
+	for _, leaked := range []string{"Exif", "GPS", "<?php"} {
+		if bytes.Contains(sanitized.Data, []byte(leaked)) {
+			t.Errorf("sanitized picture still contains %q", leaked)
+		}
+	}
+
+	decoded, err := jpeg.Decode(bytes.NewReader(sanitized.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rotated upright, then scaled down to fit in 100x100
+	if decoded.Bounds().Dx() != 50 || decoded.Bounds().Dy() != 100 {
+		t.Errorf("expected 50x100, got %v", decoded.Bounds())
+	}
+}
+
+func TestSanitize_GIFBecomesPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 4, 3), color.Palette{color.White}), nil); err != nil {
+		t.Fatal(err)
+	}
+	format, img, err := Detect(buf.Bytes(), testLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sanitized, err := Sanitize(buf.Bytes(), format, img, 85, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sanitized.Format.MimeType != "image/png" {
+		t.Errorf("expected png, got %s", sanitized.Format.MimeType)
+	}
+	if _, err := png.Decode(bytes.NewReader(sanitized.Data)); err != nil {
+		t.Errorf("sanitized gif should be a png: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package picture
+
+import (
+	"bytes"
+	"golang.org/x/image/draw"
+	"image"
+	"image/jpeg"
+	"image/png"
+)
+
+// Quality of jpeg encoded variants
+const variantJPEGQuality = 85
+
+// Variant is a resized copy of a picture, fitting in MaxWidth x MaxHeight.
+type Variant struct {
+	Name      string
+	MaxWidth  int
+	MaxHeight int
+}
+
+// Variants used when none are configured.
+var DefaultVariants = []Variant{
+	{Name: "thumbnail", MaxWidth: 160, MaxHeight: 160},
+	{Name: "card", MaxWidth: 640, MaxHeight: 640},
+	{Name: "full", MaxWidth: 1280, MaxHeight: 1280},
+}
+
+// VariantFormat returns the format variants of a picture are encoded in. Pictures that may be transparent
+// become png, everything else jpeg. An empty MIME type is a picture from before formats were detected, a jpeg.
+func VariantFormat(mimeType string) Format {
+	if mimeType == formats["png"].MimeType || mimeType == formats["gif"].MimeType {
+		return formats["png"]
+	}
+	return formats["jpeg"]
+}
+
+// VariantFileName is the name a variant of a picture is stored under, next to the original.
+func VariantFileName(pictureID, variant, mimeType string) string {
+	return pictureID + "_" + variant + VariantFormat(mimeType).Extension
+}
+
+// Resize scales img down to fit in maxWidth x maxHeight, keeping its aspect ratio.
+// Pictures that already fit are returned as they are, they are never scaled up.
+func Resize(img image.Image, maxWidth, maxHeight int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxWidth && height <= maxHeight {
+		return img
+	}
+
+	// Scale by the side that exceeds its limit the most
+	var newWidth, newHeight int
+	if width*maxHeight > height*maxWidth {
+		newWidth, newHeight = maxWidth, height*maxWidth/width
+	} else {
+		newWidth, newHeight = width*maxHeight/height, maxHeight
+	}
+	if newWidth < 1 {
+		newWidth = 1
+	}
+	if newHeight < 1 {
+		newHeight = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// EncodeVariant resizes img for the variant and encodes it in the variant format of the original MIME type.
+func EncodeVariant(img image.Image, variant Variant, mimeType string) ([]byte, error) {
+	resized := Resize(img, variant.MaxWidth, variant.MaxHeight)
+
+	var buf bytes.Buffer
+	var err error
+	if VariantFormat(mimeType).Name == "png" {
+		err = png.Encode(&buf, resized)
+	} else {
+		err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: variantJPEGQuality})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
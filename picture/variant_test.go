@@ -0,0 +1,68 @@
+package picture
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestResize(t *testing.T) {
+	cases := []struct {
+		width, height       int
+		maxWidth, maxHeight int
+		expectW, expectH    int
+	}{
+		// landscape limited by width
+		{2000, 1000, 640, 640, 640, 320},
+		// portrait limited by height
+		{1000, 2000, 640, 640, 320, 640},
+		// never scaled up
+		{100, 50, 640, 640, 100, 50},
+		// extreme ratio keeps at least a pixel
+		{5000, 2, 160, 160, 160, 1},
+	}
+	for _, c := range cases {
+		img := image.NewRGBA(image.Rect(0, 0, c.width, c.height))
+		bounds := Resize(img, c.maxWidth, c.maxHeight).Bounds()
+		if bounds.Dx() != c.expectW || bounds.Dy() != c.expectH {
+			t.Errorf("%dx%d in %dx%d: expected %dx%d, got %dx%d", c.width, c.height, c.maxWidth, c.maxHeight,
+				c.expectW, c.expectH, bounds.Dx(), bounds.Dy())
+		}
+	}
+}
+
+func TestEncodeVariant(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	variant := Variant{Name: "thumbnail", MaxWidth: 160, MaxHeight: 160}
+
+	data, err := EncodeVariant(img, variant, "image/webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("webp variants should be jpeg: %v", err)
+	}
+	if decoded.Bounds().Dx() != 160 || decoded.Bounds().Dy() != 80 {
+		t.Errorf("unexpected variant size %v", decoded.Bounds())
+	}
+
+	data, err = EncodeVariant(img, variant, "image/gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("gif variants should be png: %v", err)
+	}
+}
+
+func TestVariantFileName(t *testing.T) {
+	if name := VariantFileName("abc", "card", ""); name != "abc_card.jpg" {
+		t.Errorf("legacy pictures should have jpeg variants, got %s", name)
+	}
+	if name := VariantFileName("abc", "card", "image/png"); name != "abc_card.png" {
+		t.Errorf("png pictures should have png variants, got %s", name)
+	}
+}
@@ -0,0 +1,163 @@
+package ranking
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Weights of the signals a club is scored by. Scores are normalised by the sum of the weights.
+type Weights struct {
+	// Share of the favourite clubs of the user having a tag of the club
+	Tags float64
+	// How often users favouring the tags of the user also favour the tags of the club
+	CoOccurrence float64
+	// Favourite count of the club compared to the most favoured club
+	Popularity float64
+}
+
+var DefaultWeights = Weights{Tags: 0.5, CoOccurrence: 0.3, Popularity: 0.2}
+
+// Signals are the data clubs are ranked from, the tags of every club and the favourite clubs of every user.
+// The statistics derived from them are computed once in NewSignals.
+type Signals struct {
+	clubTags   map[string][]string
+	favourites map[string][]string
+	// Users having favoured a club with the tag
+	tagUsers map[string]int
+	// Users having favoured clubs with both tags
+	tagPairUsers map[string]map[string]int
+	// Users having favoured the club
+	clubFavourites map[string]int
+	maxFavourites  int
+}
+
+// NewSignals takes the tag IDs of every club and the favourite club IDs of every user, both by ID.
+func NewSignals(clubTags map[string][]string, favourites map[string][]string) *Signals {
+	s := &Signals{
+		clubTags:       clubTags,
+		favourites:     favourites,
+		tagUsers:       make(map[string]int),
+		tagPairUsers:   make(map[string]map[string]int),
+		clubFavourites: make(map[string]int),
+	}
+	for _, clubIDs := range favourites {
+		tags := make(map[string]bool)
+		for _, clubID := range clubIDs {
+			s.clubFavourites[clubID]++
+			if s.clubFavourites[clubID] > s.maxFavourites {
+				s.maxFavourites = s.clubFavourites[clubID]
+			}
+			for _, tag := range clubTags[clubID] {
+				tags[tag] = true
+			}
+		}
+		for tag := range tags {
+			s.tagUsers[tag]++
+			for other := range tags {
+				if other == tag {
+					continue
+				}
+				if s.tagPairUsers[tag] == nil {
+					s.tagPairUsers[tag] = make(map[string]int)
+				}
+				s.tagPairUsers[tag][other]++
+			}
+		}
+	}
+	return s
+}
+
+// Returns the share of the favourite clubs of the user having each tag.
+func (s *Signals) tagAffinity(loopUID string) map[string]float64 {
+	affinity := make(map[string]float64)
+	clubIDs := s.favourites[loopUID]
+	for _, clubID := range clubIDs {
+		for _, tag := range s.clubTags[clubID] {
+			affinity[tag] += 1 / float64(len(clubIDs))
+		}
+	}
+	return affinity
+}
+
+// Returns how likely users favouring a tag of the profile favour tag as well, weighted by the affinity of the
+// user to the profile tags. Tag itself is left out, it is covered by the tag score.
+func (s *Signals) coOccurrence(affinity map[string]float64, tag string) float64 {
+	var weighted, total float64
+	for profileTag, weight := range affinity {
+		if profileTag == tag || s.tagUsers[profileTag] == 0 {
+			continue
+		}
+		weighted += weight * float64(s.tagPairUsers[profileTag][tag]) / float64(s.tagUsers[profileTag])
+		total += weight
+	}
+	if total == 0 {
+		return 0
+	}
+	return weighted / total
+}
+
+func (s *Signals) popularity(clubID string) float64 {
+	if s.maxFavourites == 0 {
+		return 0
+	}
+	return math.Log1p(float64(s.clubFavourites[clubID])) / math.Log1p(float64(s.maxFavourites))
+}
+
+// Ranker orders clubs for a user by their score. A share of the positions, the exploration ratio, is given to
+// randomly picked clubs instead, so users also get to see clubs outside of their interests.
+type Ranker struct {
+	weights          Weights
+	explorationRatio float64
+}
+
+// NewRanker takes an exploration ratio between 0, strictly by score, and 1, entirely random.
+func NewRanker(weights Weights, explorationRatio float64) *Ranker {
+	return &Ranker{weights: weights, explorationRatio: math.Min(math.Max(explorationRatio, 0), 1)}
+}
+
+// Score returns the score of each club for the user, between 0 and 1.
+func (r *Ranker) Score(signals *Signals, loopUID string, clubIDs []string) map[string]float64 {
+	totalWeight := r.weights.Tags + r.weights.CoOccurrence + r.weights.Popularity
+	affinity := signals.tagAffinity(loopUID)
+	scores := make(map[string]float64, len(clubIDs))
+	for _, clubID := range clubIDs {
+		if totalWeight <= 0 {
+			scores[clubID] = 0
+			continue
+		}
+		// The best matching tag counts, so clubs with several tags are not watered down
+		var tagScore, coOccurrenceScore float64
+		for _, tag := range signals.clubTags[clubID] {
+			tagScore = math.Max(tagScore, affinity[tag])
+			coOccurrenceScore = math.Max(coOccurrenceScore, signals.coOccurrence(affinity, tag))
+		}
+		score := r.weights.Tags*tagScore + r.weights.CoOccurrence*coOccurrenceScore +
+			r.weights.Popularity*signals.popularity(clubID)
+		scores[clubID] = score / totalWeight
+	}
+	return scores
+}
+
+// Rank returns clubIDs ordered for the user. Clubs of equal score, and the clubs picked for exploration, are
+// chosen with rnd, so the order is reproducible for a seeded rnd.
+func (r *Ranker) Rank(signals *Signals, loopUID string, clubIDs []string, rnd *rand.Rand) []string {
+	scores := r.Score(signals, loopUID, clubIDs)
+	ranked := make([]string, len(clubIDs))
+	copy(ranked, clubIDs)
+	rnd.Shuffle(len(ranked), func(i, j int) { ranked[i], ranked[j] = ranked[j], ranked[i] })
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return scores[ranked[i]] > scores[ranked[j]]
+	})
+
+	ordered := make([]string, 0, len(ranked))
+	for len(ranked) > 0 {
+		next := 0
+		if rnd.Float64() < r.explorationRatio {
+			next = rnd.Intn(len(ranked))
+		}
+		ordered = append(ordered, ranked[next])
+		ranked = append(ranked[:next], ranked[next+1:]...)
+	}
+	return ordered
+}
@@ -0,0 +1,113 @@
+package ranking
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var testClubTags = map[string][]string{
+	"football":   {"sports"},
+	"basketball": {"sports"},
+	"chess":      {"boardgames"},
+	"debate":     {"speech"},
+	"drama":      {"speech", "arts"},
+}
+
+func TestScorePrefersTagsOfFavourites(t *testing.T) {
+	signals := NewSignals(testClubTags, map[string][]string{
+		"student": {"football"},
+	})
+	ranker := NewRanker(Weights{Tags: 1}, 0)
+	scores := ranker.Score(signals, "student", []string{"basketball", "debate"})
+	if scores["basketball"] != 1 || scores["debate"] != 0 {
+		t.Errorf("club sharing the favourite tag should score highest, got %v", scores)
+	}
+}
+
+func TestScoreUsesTagCoOccurrence(t *testing.T) {
+	signals := NewSignals(testClubTags, map[string][]string{
+		"student": {"football"},
+		// Other sports fans play chess, nobody debates
+		"a": {"basketball", "chess"},
+		"b": {"football", "chess"},
+		"c": {"debate"},
+	})
+	ranker := NewRanker(Weights{CoOccurrence: 1}, 0)
+	scores := ranker.Score(signals, "student", []string{"chess", "debate", "basketball"})
+	// 2 of the 3 users favouring sports favour boardgames too
+	if scores["chess"] < 0.66 || scores["chess"] > 0.67 {
+		t.Errorf("unexpected co-occurrence score of chess %v", scores["chess"])
+	}
+	if scores["debate"] != 0 {
+		t.Errorf("debate never co-occurs with sports, got %v", scores["debate"])
+	}
+	if scores["basketball"] != 0 {
+		t.Errorf("the tags of the user are left to the tag score, got %v", scores["basketball"])
+	}
+}
+
+func TestRankNewUserByPopularity(t *testing.T) {
+	signals := NewSignals(testClubTags, map[string][]string{
+		"a": {"drama", "chess"},
+		"b": {"drama"},
+		"c": {"drama", "chess", "debate"},
+	})
+	ranker := NewRanker(DefaultWeights, 0)
+	ranked := ranker.Rank(signals, "newcomer", []string{"debate", "chess", "drama", "football"}, rand.New(rand.NewSource(1)))
+	expected := []string{"drama", "chess", "debate", "football"}
+	if !reflect.DeepEqual(ranked, expected) {
+		t.Errorf("expected %v, got %v", expected, ranked)
+	}
+}
+
+func TestRankCombinesSignals(t *testing.T) {
+	signals := NewSignals(testClubTags, map[string][]string{
+		"student": {"football"},
+		"a":       {"basketball", "chess"},
+		"b":       {"debate"},
+		"c":       {"debate"},
+		"d":       {"debate"},
+	})
+	ranker := NewRanker(DefaultWeights, 0)
+	ranked := ranker.Rank(signals, "student", []string{"debate", "chess", "basketball", "drama"}, rand.New(rand.NewSource(1)))
+	expected := []string{"basketball", "chess", "debate", "drama"}
+	if !reflect.DeepEqual(ranked, expected) {
+		t.Errorf("expected %v, got %v", expected, ranked)
+	}
+}
+
+func TestRankExplorationRatio(t *testing.T) {
+	signals := NewSignals(testClubTags, map[string][]string{"student": {"football"}})
+	clubIDs := []string{"debate", "chess", "basketball", "drama"}
+
+	exploring := NewRanker(DefaultWeights, 1)
+	first := exploring.Rank(signals, "student", clubIDs, rand.New(rand.NewSource(7)))
+	second := exploring.Rank(signals, "student", clubIDs, rand.New(rand.NewSource(7)))
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("the same seed should give the same order, got %v and %v", first, second)
+	}
+	sorted := append([]string(nil), first...)
+	sort.Strings(sorted)
+	if !reflect.DeepEqual(sorted, []string{"basketball", "chess", "debate", "drama"}) {
+		t.Errorf("every club should be ranked exactly once, got %v", first)
+	}
+
+	// Over many lists, basketball is not always first when exploring
+	notFirst := 0
+	for seed := int64(0); seed < 100; seed++ {
+		if exploring.Rank(signals, "student", clubIDs, rand.New(rand.NewSource(seed)))[0] != "basketball" {
+			notFirst++
+		}
+	}
+	if notFirst < 50 {
+		t.Errorf("exploring should mostly ignore the score, basketball was not first %d times", notFirst)
+	}
+	strict := NewRanker(DefaultWeights, 0)
+	for seed := int64(0); seed < 100; seed++ {
+		if strict.Rank(signals, "student", clubIDs, rand.New(rand.NewSource(seed)))[0] != "basketball" {
+			t.Fatal("without exploration the best scored club should always be first")
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package sessionstore
+
+import (
+	"github.com/jinzhu/gorm"
+	"time"
+	"tinder-for-clubs-backend/db"
+)
+
+// DBBackend keeps sessions in the admin_session table of the main database.
+type DBBackend struct {
+	db *gorm.DB
+}
+
+func NewDBBackend(conn *gorm.DB) *DBBackend {
+	return &DBBackend{db: conn}
+}
+
+func (b *DBBackend) Load(sessionID string) (*Record, error) {
+	session, err := db.GetAdminSessionByID(b.db, sessionID)
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Record{
+		SessionID:    session.SessionID,
+		AccountID:    session.AccountID,
+		Data:         session.Data,
+		ExpiresAt:    session.ExpiresAt,
+		LastActiveAt: session.LastActiveAt,
+	}, nil
+}
+
+func (b *DBBackend) Save(record *Record) error {
+	session := db.AdminSession{
+		SessionID:    record.SessionID,
+		AccountID:    record.AccountID,
+		Data:         record.Data,
+		ExpiresAt:    record.ExpiresAt,
+		LastActiveAt: record.LastActiveAt,
+	}
+	return session.InsertOrUpdate(b.db)
+}
+
+func (b *DBBackend) Touch(sessionID string, at time.Time) error {
+	return db.TouchAdminSession(b.db, sessionID, at)
+}
+
+func (b *DBBackend) Delete(sessionID string) error {
+	return db.DeleteAdminSession(b.db, sessionID)
+}
+
+func (b *DBBackend) DeleteByAccount(accountID string) error {
+	return db.DeleteAdminSessionsByAccountID(b.db, accountID)
+}
+
+func (b *DBBackend) DeleteExpired(now time.Time) error {
+	return db.DeleteExpiredAdminSessions(b.db, now)
+}
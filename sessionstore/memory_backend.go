@@ -0,0 +1,73 @@
+package sessionstore
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryBackend keeps sessions in process. Only meant for local development,
+// sessions are lost on restart and not shared between instances.
+type MemoryBackend struct {
+	mu       sync.Mutex
+	sessions map[string]Record
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{sessions: make(map[string]Record)}
+}
+
+func (b *MemoryBackend) Load(sessionID string) (*Record, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	record, ok := b.sessions[sessionID]
+	if !ok {
+		return nil, nil
+	}
+	return &record, nil
+}
+
+func (b *MemoryBackend) Save(record *Record) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sessions[record.SessionID] = *record
+	return nil
+}
+
+func (b *MemoryBackend) Touch(sessionID string, at time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if record, ok := b.sessions[sessionID]; ok {
+		record.LastActiveAt = at
+		b.sessions[sessionID] = record
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Delete(sessionID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.sessions, sessionID)
+	return nil
+}
+
+func (b *MemoryBackend) DeleteByAccount(accountID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id, record := range b.sessions {
+		if record.AccountID == accountID {
+			delete(b.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBackend) DeleteExpired(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id, record := range b.sessions {
+		if now.After(record.ExpiresAt) {
+			delete(b.sessions, id)
+		}
+	}
+	return nil
+}
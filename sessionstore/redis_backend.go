@@ -0,0 +1,167 @@
+package sessionstore
+
+import (
+	"encoding/json"
+	"github.com/gomodule/redigo/redis"
+	"time"
+)
+
+const (
+	redisSessionPrefix        = "admin_session:"
+	redisAccountSessionPrefix = "admin_session_account:"
+)
+
+// Extends the expiry of KEYS[1] to ARGV[1] milliseconds, never shortens it.
+var extendExpiryScript = redis.NewScript(1, `
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl >= 0 and ttl >= tonumber(ARGV[1]) then
+	return 0
+end
+return redis.call("PEXPIRE", KEYS[1], ARGV[1])
+`)
+
+// Sets the LastActiveAt of the record at KEYS[1] to ARGV[1], keeping the rest and the expiry. A session deleted
+// meanwhile is left deleted.
+var touchScript = redis.NewScript(1, `
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl <= 0 then
+	return 0
+end
+local record = cjson.decode(redis.call("GET", KEYS[1]))
+record.LastActiveAt = ARGV[1]
+return redis.call("SET", KEYS[1], cjson.encode(record), "PX", ttl)
+`)
+
+// RedisBackend keeps sessions in any Redis protocol compatible server supporting Lua scripts.
+// Every session is a key expiring with the session, and each account has a set of its session ids for revocation.
+// The set expires with the last of its sessions, so revocation finds all sessions still alive.
+type RedisBackend struct {
+	pool *redis.Pool
+}
+
+func NewRedisBackend(address, password string, database int) *RedisBackend {
+	pool := &redis.Pool{
+		MaxIdle:     10,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", address, redis.DialPassword(password), redis.DialDatabase(database))
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+	return &RedisBackend{pool: pool}
+}
+
+func (b *RedisBackend) Load(sessionID string) (*Record, error) {
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", redisSessionPrefix+sessionID))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (b *RedisBackend) Save(record *Record) error {
+	conn := b.pool.Get()
+	defer conn.Close()
+	return b.save(conn, record)
+}
+
+func (b *RedisBackend) save(conn redis.Conn, record *Record) error {
+	ttl := time.Until(record.ExpiresAt)
+	if ttl <= 0 {
+		_, err := conn.Do("DEL", redisSessionPrefix+record.SessionID)
+		return err
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+	if err := conn.Send("SET", redisSessionPrefix+record.SessionID, data, "PX", int64(ttl/time.Millisecond)); err != nil {
+		return err
+	}
+	if record.AccountID != "" {
+		accountKey := redisAccountSessionPrefix + record.AccountID
+		if err := conn.Send("SADD", accountKey, record.SessionID); err != nil {
+			return err
+		}
+		if err := extendExpiryScript.Send(conn, accountKey, int64(ttl/time.Millisecond)); err != nil {
+			return err
+		}
+	}
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// Touches in one script, a session revoked between loading and saving it would be saved again otherwise.
+func (b *RedisBackend) Touch(sessionID string, at time.Time) error {
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	// The format of time.Time in JSON
+	_, err := touchScript.Do(conn, redisSessionPrefix+sessionID, at.Format(time.RFC3339Nano))
+	return err
+}
+
+func (b *RedisBackend) Delete(sessionID string) error {
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	record, err := b.Load(sessionID)
+	if err != nil || record == nil {
+		return err
+	}
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+	if err := conn.Send("DEL", redisSessionPrefix+sessionID); err != nil {
+		return err
+	}
+	if record.AccountID != "" {
+		if err := conn.Send("SREM", redisAccountSessionPrefix+record.AccountID, sessionID); err != nil {
+			return err
+		}
+	}
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+func (b *RedisBackend) DeleteByAccount(accountID string) error {
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	accountKey := redisAccountSessionPrefix + accountID
+	sessionIDs, err := redis.Strings(conn.Do("SMEMBERS", accountKey))
+	if err != nil {
+		return err
+	}
+	keys := []interface{}{accountKey}
+	for _, sessionID := range sessionIDs {
+		keys = append(keys, redisSessionPrefix+sessionID)
+	}
+	_, err = conn.Do("DEL", keys...)
+	return err
+}
+
+// Redis expires session keys by itself.
+func (b *RedisBackend) DeleteExpired(now time.Time) error {
+	return nil
+}
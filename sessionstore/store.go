@@ -0,0 +1,237 @@
+package sessionstore
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Record is a session entry as persisted by a Backend.
+type Record struct {
+	SessionID    string
+	AccountID    string
+	Data         []byte
+	ExpiresAt    time.Time
+	LastActiveAt time.Time
+}
+
+// Backend persists session records server side.
+// Load returns (nil, nil) when the session does not exist.
+type Backend interface {
+	Load(sessionID string) (*Record, error)
+	Save(record *Record) error
+	Touch(sessionID string, at time.Time) error
+	Delete(sessionID string) error
+	DeleteByAccount(accountID string) error
+	DeleteExpired(now time.Time) error
+}
+
+// OwnerFunc returns the account that owns the given session, or "" when nobody is logged in.
+type OwnerFunc func(session *gsessions.Session) string
+
+// Only touch the last active time once per interval to avoid a write on every request.
+const touchInterval = time.Minute
+
+// Session value marking the session for a new id, removed before the session is persisted
+const renewKey = "sessionstore.renew"
+
+// Renew makes the session get a new id when it is saved next, and deletes the record under the former one.
+// Call it whenever the session gains privileges, e.g. on login, so an id planted before is useless after.
+func Renew(session sessions.Session) {
+	session.Set(renewKey, true)
+}
+
+// Store is a gin session store keeping session data in a Backend.
+// The cookie only carries the signed session id.
+type Store struct {
+	backend     Backend
+	codecs      []securecookie.Codec
+	serializer  securecookie.GobEncoder
+	options     *gsessions.Options
+	ttl         time.Duration
+	idleTimeout time.Duration
+	owner       OwnerFunc
+}
+
+var _ sessions.Store = &Store{}
+
+func NewStore(backend Backend, secretKey []byte, ttl, idleTimeout time.Duration, owner OwnerFunc) *Store {
+	codecs := securecookie.CodecsFromPairs(secretKey)
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(int(ttl / time.Second))
+		}
+	}
+	return &Store{
+		backend: backend,
+		codecs:  codecs,
+		options: &gsessions.Options{
+			Path:     "/",
+			MaxAge:   int(ttl / time.Second),
+			HttpOnly: true,
+		},
+		ttl:         ttl,
+		idleTimeout: idleTimeout,
+		owner:       owner,
+	}
+}
+
+func (s *Store) Options(options sessions.Options) {
+	s.options = &gsessions.Options{
+		Path:     options.Path,
+		Domain:   options.Domain,
+		MaxAge:   options.MaxAge,
+		Secure:   options.Secure,
+		HttpOnly: options.HttpOnly,
+	}
+}
+
+func (s *Store) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *Store) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	opts := *s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var sessionID string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &sessionID, s.codecs...); err != nil {
+		// Tampered or expired cookie, start over with a fresh session
+		return session, nil
+	}
+
+	record, err := s.load(sessionID)
+	if err != nil || record == nil {
+		return session, err
+	}
+	if err := s.serializer.Deserialize(record.Data, &session.Values); err != nil {
+		return session, err
+	}
+	session.ID = sessionID
+	session.IsNew = false
+	return session, nil
+}
+
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	// Deleting session
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.backend.Delete(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if renew, _ := session.Values[renewKey].(bool); renew {
+		delete(session.Values, renewKey)
+		if session.ID != "" {
+			if err := s.backend.Delete(session.ID); err != nil {
+				return err
+			}
+			session.ID = ""
+		}
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(s.ttl)
+	if session.ID == "" {
+		sessionID, err := genSessionID()
+		if err != nil {
+			return err
+		}
+		session.ID = sessionID
+	} else {
+		// The lifetime is absolute, saving again keeps the expiry set when the session was created or renewed
+		stored, err := s.backend.Load(session.ID)
+		if err != nil {
+			return err
+		}
+		if stored != nil {
+			expiresAt = stored.ExpiresAt
+		}
+	}
+	if session.Options.MaxAge > 0 {
+		session.Options.MaxAge = int(expiresAt.Sub(now) / time.Second)
+	}
+
+	data, err := s.serializer.Serialize(session.Values)
+	if err != nil {
+		return err
+	}
+	record := Record{
+		SessionID:    session.ID,
+		AccountID:    s.owner(session),
+		Data:         data,
+		ExpiresAt:    expiresAt,
+		LastActiveAt: now,
+	}
+	if err := s.backend.Save(&record); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// RevokeAccount removes every session of the given account, logging it out everywhere.
+func (s *Store) RevokeAccount(accountID string) error {
+	if accountID == "" {
+		return errors.New("empty account id")
+	}
+	return s.backend.DeleteByAccount(accountID)
+}
+
+// Cleanup removes expired sessions from the backend.
+func (s *Store) Cleanup() error {
+	return s.backend.DeleteExpired(time.Now())
+}
+
+// Loads a record and checks the absolute and idle expiry.
+func (s *Store) load(sessionID string) (*Record, error) {
+	record, err := s.backend.Load(sessionID)
+	if err != nil || record == nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expired := now.After(record.ExpiresAt)
+	if s.idleTimeout > 0 && now.Sub(record.LastActiveAt) > s.idleTimeout {
+		expired = true
+	}
+	if expired {
+		return nil, s.backend.Delete(sessionID)
+	}
+
+	if now.Sub(record.LastActiveAt) > touchInterval {
+		if err := s.backend.Touch(sessionID, now); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+func genSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(b), "="), nil
+}
@@ -0,0 +1,243 @@
+package sessionstore
+
+import (
+	"bytes"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gomodule/redigo/redis"
+	gsessions "github.com/gorilla/sessions"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+	"tinder-for-clubs-backend/config"
+	"tinder-for-clubs-backend/db"
+)
+
+const testSessionName = "TestSession"
+
+func testOwner(session *gsessions.Session) string {
+	accountID, _ := session.Values["account"].(string)
+	return accountID
+}
+
+func newTestStore(backend Backend, idleTimeout time.Duration) *Store {
+	return NewStore(backend, []byte("test session secret, at least 32 characters"), time.Hour, idleTimeout, testOwner)
+}
+
+// Saves a session for the account, "" for an anonymous one, and returns its cookie.
+func saveTestSession(t *testing.T, store *Store, cookie *http.Cookie, accountID string, renew bool) *http.Cookie {
+	router := gin.New()
+	router.Use(sessions.Sessions(testSessionName, store))
+	router.GET("/", func(ctx *gin.Context) {
+		session := sessions.Default(ctx)
+		session.Set("account", accountID)
+		if renew {
+			Renew(session)
+		}
+		if err := session.Save(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	if cookie != nil {
+		request.AddCookie(cookie)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected a session cookie, got %v", cookies)
+	}
+	return cookies[0]
+}
+
+// Loads the session of the cookie, nil when there is none.
+func loadTestSession(t *testing.T, store *Store, cookie *http.Cookie) *gsessions.Session {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.AddCookie(cookie)
+	session, err := store.New(request, testSessionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.IsNew {
+		return nil
+	}
+	return session
+}
+
+func testStore(t *testing.T, backend Backend) {
+	gin.SetMode(gin.TestMode)
+	store := newTestStore(backend, 0)
+
+	// Logging in renews the id, the one known before is useless after
+	anonymous := saveTestSession(t, store, nil, "", false)
+	planted := loadTestSession(t, store, anonymous)
+	if planted == nil {
+		t.Fatal("the anonymous session should be stored")
+	}
+	loggedIn := saveTestSession(t, store, anonymous, "account-1", true)
+	session := loadTestSession(t, store, loggedIn)
+	if session == nil || session.ID == planted.ID || testOwner(session) != "account-1" {
+		t.Fatalf("login should store the account under a new id, got %+v", session)
+	}
+	if _, ok := session.Values[renewKey]; ok {
+		t.Error("the renewal mark should not be stored")
+	}
+	if loadTestSession(t, store, anonymous) != nil {
+		t.Error("the session under the former id should be deleted")
+	}
+	// Saving without renewal keeps the id and the expiry
+	created, err := backend.Load(session.ID)
+	if err != nil || created == nil {
+		t.Fatalf("the session should be stored, got %+v %v", created, err)
+	}
+	if again := loadTestSession(t, store, saveTestSession(t, store, loggedIn, "account-1", false)); again == nil || again.ID != session.ID {
+		t.Errorf("the id should only change on renewal, got %+v", again)
+	}
+	if saved, err := backend.Load(session.ID); err != nil || saved == nil || !saved.ExpiresAt.Equal(created.ExpiresAt) {
+		t.Errorf("saving again should keep the expiry %v, got %+v %v", created.ExpiresAt, saved, err)
+	}
+
+	// Logging out everywhere removes every session of the account, and only those
+	other := saveTestSession(t, store, nil, "account-1", false)
+	another := saveTestSession(t, store, nil, "account-2", false)
+	if err := store.RevokeAccount("account-1"); err != nil {
+		t.Fatal(err)
+	}
+	if loadTestSession(t, store, loggedIn) != nil || loadTestSession(t, store, other) != nil {
+		t.Error("the sessions of the revoked account should be deleted")
+	}
+	if loadTestSession(t, store, another) == nil {
+		t.Error("the sessions of other accounts should be kept")
+	}
+	if err := store.RevokeAccount(""); err == nil {
+		t.Error("revoking without account should fail")
+	}
+
+	// Touching only moves the last active time, and does not bring back a revoked session
+	if err := backend.Touch(session.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := backend.Load(session.ID); err != nil || record != nil {
+		t.Errorf("touching a revoked session should not store it again, got %+v %v", record, err)
+	}
+	anotherSession := loadTestSession(t, store, another)
+	before, err := backend.Load(anotherSession.ID)
+	if err != nil || before == nil {
+		t.Fatalf("the session should be stored, got %+v %v", before, err)
+	}
+	touchedAt := time.Now().Add(30 * time.Second).Truncate(time.Second)
+	if err := backend.Touch(anotherSession.ID, touchedAt); err != nil {
+		t.Fatal(err)
+	}
+	touched, err := backend.Load(anotherSession.ID)
+	if err != nil || touched == nil || !touched.LastActiveAt.Equal(touchedAt) || !touched.ExpiresAt.Equal(before.ExpiresAt) ||
+		touched.AccountID != before.AccountID || !bytes.Equal(touched.Data, before.Data) {
+		t.Errorf("touching should only set the last active time to %v, got %+v from %+v %v", touchedAt, touched, before, err)
+	}
+
+	// Sessions past their expiry or idle for too long are not loaded
+	idleStore := newTestStore(backend, time.Minute)
+	idle := saveTestSession(t, idleStore, nil, "account-3", false)
+	idleSession := loadTestSession(t, idleStore, idle)
+	record, err := backend.Load(idleSession.ID)
+	if err != nil || record == nil {
+		t.Fatalf("the session should be stored, got %+v %v", record, err)
+	}
+	record.LastActiveAt = time.Now().Add(-2 * time.Minute)
+	if err := backend.Save(record); err != nil {
+		t.Fatal(err)
+	}
+	if loadTestSession(t, idleStore, idle) != nil {
+		t.Error("an idle session should not be loaded")
+	}
+	if record, err := backend.Load(idleSession.ID); err != nil || record != nil {
+		t.Errorf("an idle session should be deleted when loaded, got %+v %v", record, err)
+	}
+
+	expired := saveTestSession(t, store, nil, "account-4", false)
+	expiredSession := loadTestSession(t, store, expired)
+	record, err = backend.Load(expiredSession.ID)
+	if err != nil || record == nil {
+		t.Fatalf("the session should be stored, got %+v %v", record, err)
+	}
+	record.ExpiresAt = time.Now().Add(-time.Second)
+	if err := backend.Save(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := backend.Load(expiredSession.ID); err != nil || record != nil {
+		t.Errorf("an expired session should be cleaned up, got %+v %v", record, err)
+	}
+	if loadTestSession(t, store, expired) != nil {
+		t.Error("an expired session should not be loaded")
+	}
+	if loadTestSession(t, store, another) == nil {
+		t.Error("cleaning up should keep sessions that are still valid")
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	testStore(t, NewMemoryBackend())
+}
+
+func TestDBBackend(t *testing.T) {
+	db.Init(config.DBCredential{Driver: "sqlite3", DBPath: ":memory:"})
+	defer db.Close()
+	db.DB.LogMode(false)
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, NewDBBackend(db.DB))
+}
+
+// Needs a Redis server, whose database TEST_REDIS_DB (0 by default) is emptied
+func TestRedisBackend(t *testing.T) {
+	address := os.Getenv("TEST_REDIS_ADDRESS")
+	if address == "" {
+		t.Skip("TEST_REDIS_ADDRESS is not set")
+	}
+	database := 0
+	if value := os.Getenv("TEST_REDIS_DB"); value != "" {
+		var err error
+		if database, err = strconv.Atoi(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backend := NewRedisBackend(address, os.Getenv("TEST_REDIS_PASSWORD"), database)
+	conn := backend.pool.Get()
+	_, err := conn.Do("FLUSHDB")
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, backend)
+
+	// The set of sessions of an account lives as long as its longest session, and forgets deleted ones
+	accountKey := redisAccountSessionPrefix + "account-5"
+	for _, record := range []*Record{
+		{SessionID: "long", AccountID: "account-5", ExpiresAt: time.Now().Add(time.Hour)},
+		{SessionID: "short", AccountID: "account-5", ExpiresAt: time.Now().Add(time.Minute)},
+	} {
+		if err := backend.Save(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn = backend.pool.Get()
+	defer conn.Close()
+	if ttl, err := redis.Int64(conn.Do("PTTL", accountKey)); err != nil || ttl < int64(59*time.Minute/time.Millisecond) {
+		t.Errorf("saving a shorter session should not shorten the account set, got %d %v", ttl, err)
+	}
+	if err := backend.Delete("short"); err != nil {
+		t.Fatal(err)
+	}
+	if member, err := redis.Bool(conn.Do("SISMEMBER", accountKey, "short")); err != nil || member {
+		t.Errorf("a deleted session should be removed from the account set, got %v %v", member, err)
+	}
+}
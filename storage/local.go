@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LocalStore keeps blobs as files in a directory of the local disk.
+// The content type is derived from the file extension.
+type LocalStore struct {
+	dir string
+}
+
+var _ BlobStore = &LocalStore{}
+
+func NewLocalStore(dir string) *LocalStore {
+	return &LocalStore{dir: dir}
+}
+
+func (s *LocalStore) Put(key string, data []byte, contentType string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(s.dir, key), data, 0644)
+}
+
+func (s *LocalStore) Get(key string) (Blob, *BlobInfo, error) {
+	if err := checkKey(key); err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(filepath.Join(s.dir, key))
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, s.blobInfo(key, stat), nil
+}
+
+func (s *LocalStore) Stat(key string) (*BlobInfo, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(filepath.Join(s.dir, key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.blobInfo(key, stat), nil
+}
+
+func (s *LocalStore) Delete(key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(s.dir, key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *LocalStore) SignedURL(key string, expiry time.Duration) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (s *LocalStore) blobInfo(key string, stat os.FileInfo) *BlobInfo {
+	return &BlobInfo{
+		Size:        stat.Size(),
+		ModTime:     stat.ModTime(),
+		ContentType: mime.TypeByExtension(filepath.Ext(key)),
+	}
+}
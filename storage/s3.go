@@ -0,0 +1,378 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3AmzDateFormat   = "20060102T150405Z"
+	s3DateFormat      = "20060102"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// S3Store keeps blobs in a bucket of an S3 compatible object storage such as AWS S3 or MinIO.
+// Requests are signed with AWS signature version 4 and use path style URLs, <endpoint>/<bucket>/<key>.
+type S3Store struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+	now       func() time.Time
+}
+
+var _ BlobStore = &S3Store{}
+
+// NewS3Store creates a store for the bucket, endpoint is e.g. "https://s3.eu-west-1.amazonaws.com".
+func NewS3Store(endpoint, region, bucket, accessKey, secretKey string) (*S3Store, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %s", endpoint)
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is not configured")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3Store{
+		endpoint:  u,
+		region:    region,
+		bucket:    bucket,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		now:       time.Now,
+	}, nil
+}
+
+func (s *S3Store) Put(key string, data []byte, contentType string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	req, err := s.newRequest(http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, data)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkS3Response(resp)
+}
+
+// Get starts downloading the blob. Seeking elsewhere than the download is at downloads the rest of the blob from
+// there, with a ranged request.
+func (s *S3Store) Get(key string) (Blob, *BlobInfo, error) {
+	if err := checkKey(key); err != nil {
+		return nil, nil, err
+	}
+	resp, err := s.getFrom(key, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	info := s3BlobInfo(resp)
+	return &s3Blob{store: s, key: key, size: info.Size, body: resp.Body}, info, nil
+}
+
+// Requests the blob from offset on.
+func (s *S3Store) getFrom(key string, offset int64) (*http.Response, error) {
+	req, err := s.newRequest(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	s.sign(req, nil)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkS3Response(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+type s3Blob struct {
+	store *S3Store
+	key   string
+	// -1 when the storage did not tell
+	size int64
+	// Where the next read starts, and where the body being downloaded is at
+	offset     int64
+	body       io.ReadCloser
+	bodyOffset int64
+}
+
+func (b *s3Blob) Read(p []byte) (int, error) {
+	if b.size >= 0 && b.offset >= b.size {
+		return 0, io.EOF
+	}
+	if b.body == nil || b.bodyOffset != b.offset {
+		if b.body != nil {
+			b.body.Close()
+			b.body = nil
+		}
+		resp, err := b.store.getFrom(b.key, b.offset)
+		if err != nil {
+			return 0, err
+		}
+		b.body, b.bodyOffset = resp.Body, b.offset
+	}
+	n, err := b.body.Read(p)
+	b.offset += int64(n)
+	b.bodyOffset += int64(n)
+	return n, err
+}
+
+// Seek only moves the offset, the blob is downloaded from there once it is read.
+func (b *s3Blob) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += b.offset
+	case io.SeekEnd:
+		if b.size < 0 {
+			return 0, fmt.Errorf("size of blob %s is unknown", b.key)
+		}
+		offset += b.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d in blob %s", offset, b.key)
+	}
+	b.offset = offset
+	return offset, nil
+}
+
+func (b *s3Blob) Close() error {
+	if b.body == nil {
+		return nil
+	}
+	err := b.body.Close()
+	b.body = nil
+	return err
+}
+
+func (s *S3Store) Stat(key string) (*BlobInfo, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	req, err := s.newRequest(http.MethodHead, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, nil)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkS3Response(resp); err != nil {
+		return nil, err
+	}
+	return s3BlobInfo(resp), nil
+}
+
+// Delete removes the blob, deleting a missing blob is not an error.
+func (s *S3Store) Delete(key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	req, err := s.newRequest(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, nil)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkS3Response(resp); err != nil && err != ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// SignedURL returns a presigned GET URL, valid for expiry (at most 7 days).
+func (s *S3Store) SignedURL(key string, expiry time.Duration) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	if expiry <= 0 || expiry > 7*24*time.Hour {
+		return "", fmt.Errorf("invalid signed url expiry %v", expiry)
+	}
+	return s.presign(http.MethodGet, s.endpoint.Host, s.objectPath(key), s.now().UTC(), expiry), nil
+}
+
+func (s *S3Store) objectPath(key string) string {
+	return strings.TrimRight(s.endpoint.Path, "/") + "/" + s.bucket + "/" + key
+}
+
+func (s *S3Store) newRequest(method, key string, body []byte) (*http.Request, error) {
+	u := *s.endpoint
+	u.Path = s.objectPath(key)
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	return http.NewRequest(method, u.String(), reader)
+}
+
+// Signs the request in the Authorization header.
+func (s *S3Store) sign(req *http.Request, payload []byte) {
+	now := s.now().UTC()
+	payloadHash := sha256Hex(payload)
+	req.Header.Set("X-Amz-Date", now.Format(s3AmzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Content-Type") != "" {
+		signedHeaders = append([]string{"content-type"}, signedHeaders...)
+	}
+	var canonicalHeaders strings.Builder
+	for _, h := range signedHeaders {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+	signature := s.signature(now, canonicalRequest)
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.accessKey, s.scope(now), strings.Join(signedHeaders, ";"), signature))
+}
+
+// Signs a request in its query string, so it can be handed out as a plain URL.
+func (s *S3Store) presign(method, host, path string, now time.Time, expiry time.Duration) string {
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", s3Algorithm)
+	query.Set("X-Amz-Credential", s.accessKey+"/"+s.scope(now))
+	query.Set("X-Amz-Date", now.Format(s3AmzDateFormat))
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(expiry/time.Second), 10))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	u := url.URL{Scheme: s.endpoint.Scheme, Host: host, Path: path}
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		canonicalQuery(query),
+		"host:" + host + "\n",
+		"host",
+		s3UnsignedPayload,
+	}, "\n")
+
+	u.RawQuery = canonicalQuery(query) + "&X-Amz-Signature=" + s.signature(now, canonicalRequest)
+	return u.String()
+}
+
+func (s *S3Store) scope(now time.Time) string {
+	return now.Format(s3DateFormat) + "/" + s.region + "/s3/aws4_request"
+}
+
+func (s *S3Store) signature(now time.Time, canonicalRequest string) string {
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		now.Format(s3AmzDateFormat),
+		s.scope(now),
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format(s3DateFormat))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// Query parameters sorted by name and escaped as RFC 3986 requires.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3Escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+func checkS3Response(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 request failed with %s: %s", resp.Status, body)
+}
+
+func s3BlobInfo(resp *http.Response) *BlobInfo {
+	info := &BlobInfo{
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return info
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
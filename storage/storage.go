@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// ErrNotSupported is returned by stores that can not hand out signed URLs.
+var ErrNotSupported = errors.New("not supported by this blob store")
+
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// BlobInfo describes a stored blob.
+type BlobInfo struct {
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+}
+
+// Blob is the content of a stored blob, read from wherever Seek moves to, so it can be served in ranges.
+type Blob interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// BlobStore keeps pictures and other uploaded files, addressed by a key such as "<uuid>.png".
+// Get and Stat return ErrNotFound when there is no blob with the key.
+type BlobStore interface {
+	Put(key string, data []byte, contentType string) error
+	Get(key string) (Blob, *BlobInfo, error)
+	Stat(key string) (*BlobInfo, error)
+	Delete(key string) error
+	// SignedURL returns a URL the blob can be downloaded from directly until it expires,
+	// or ErrNotSupported when the store has none.
+	SignedURL(key string, expiry time.Duration) (string, error)
+}
+
+// Keys are flat file names, so they can neither escape a directory nor need escaping in URLs.
+func checkKey(key string) error {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, "/\\?#%") {
+		return ErrInvalidKey
+	}
+	return nil
+}